@@ -17,6 +17,6 @@ var (
 func main() {
 	if err := run(); err != nil {
 		logger.Errorf("(%s/main): %s", pkgName, err)
-		exit(-10)
+		exit(cmd.ExitCode(err))
 	}
 }
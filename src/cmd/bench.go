@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+// benchFlags contains values for flags of the `bench` command
+var benchFlags = struct {
+	algos []string
+}{}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench <path>",
+	Short: "Measure read strategies and checksum algorithms on a path",
+	Long: `
+Hash all files in a path with each read strategy and checksum algorithm, and print the
+throughput of each - helps pick the best settings for the underlying storage
+
+Rows name the strategies files were actually read with - strategies that are not
+supported for a file fall back to buffered reads, and are skipped if no file supports
+them
+
+Except for direct reads, files read once are likely served from the page cache on later
+runs. Use a path larger than the available memory to measure the storage itself
+`,
+	Example: `  crcgen bench --algo crc32,crc32c --buffer-size 4194304 /mnt/array`,
+	Args:    checkArgs(cobra.ExactArgs(1)),
+	RunE:    runBench,
+}
+
+func init() {
+	setupBenchFlags()
+	Root.AddCommand(benchCmd)
+}
+
+/*
+setupBenchFlags defines flags for the `bench` command
+*/
+func setupBenchFlags() {
+	benchCmd.Flags().StringSliceVarP(
+		&benchFlags.algos, "algo", "a", lib.Algorithms(),
+		"checksum algorithms to measure: "+strings.Join(lib.Algorithms(), ", "),
+	)
+
+	benchCmd.Flags().IntVar(
+		&readOpts.BufferSize, "buffer-size", lib.DefaultBufferSize,
+		"size of buffers used to read files, in bytes",
+	)
+}
+
+/*
+completeStrategy completes names of read strategies
+*/
+func completeStrategy(
+	*cobra.Command, []string, string,
+) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, strategy := range lib.ReadStrategies() {
+		names = append(names, strategy.String())
+	}
+
+	return append(names, lib.ReadAuto.String()), cobra.ShellCompDirectiveNoFileComp
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	if _, err := lib.NewHashes(benchFlags.algos); err != nil {
+		return errors.Wrapf(errInvalidArgs, "%v", err)
+	}
+
+	cmd.SilenceUsage = true
+
+	var paths []string
+	err := lib.WalkPath(args[0], lib.OnErrorAbort, func(
+		path string, info fs.FileInfo, err error,
+	) error {
+		if err == nil && info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return errors.Wrapf(err, "(%s/bench)", pkgName)
+	}
+
+	out := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(out, "STRATEGY\tALGO\tBYTES\tTIME\tMiB/s")
+
+	for _, strategy := range lib.ReadStrategies() {
+		for _, algo := range benchFlags.algos {
+			if cmd.Context().Err() != nil {
+				return errors.Wrapf(errInterrupted, "(%s/bench)", pkgName)
+			}
+
+			opts := lib.ReadOptions{Strategy: strategy, BufferSize: readOpts.BufferSize}
+			if err = benchRun(out, paths, algo, opts); err != nil {
+				return errors.Wrapf(err, "(%s/bench)", pkgName)
+			}
+		}
+	}
+
+	return errors.Wrapf(out.Flush(), "(%s/bench)", pkgName)
+}
+
+/*
+benchRun hashes each file with a single algorithm and read strategy, and writes the
+time taken as a row in the output. The row names the strategies files were actually
+read with, and is skipped if the strategy is not supported for any file - strategies
+fall back to buffered reads, see lib.ChecksumFile
+*/
+func benchRun(out io.Writer, paths []string, algo string, opts lib.ReadOptions) error {
+	var total int64
+
+	used := map[lib.ReadStrategy]bool{}
+	start := time.Now()
+	for _, path := range paths {
+		file, err := openPath(path, false)
+		if err != nil {
+			return err
+		}
+
+		info, err := file.Stat()
+		if err == nil {
+			var strategy lib.ReadStrategy
+			_, strategy, err = lib.ChecksumFile(file, info.Size(), []string{algo}, opts)
+
+			// Empty files are read the same way by every strategy
+			used[strategy] = used[strategy] || info.Size() > 0
+		}
+
+		_ = file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "%s", path)
+		}
+
+		total += info.Size()
+	}
+
+	elapsed := time.Since(start)
+	if total > 0 && !used[opts.Strategy] {
+		logger.Infof("(%s/bench): %s not supported, skipped", pkgName, opts.Strategy)
+		return nil
+	}
+
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(total) / (1 << 20) / elapsed.Seconds()
+	}
+
+	_, err := fmt.Fprintf(
+		out, "%s\t%s\t%d\t%s\t%.1f\n",
+		strategyNames(used, opts.Strategy), algo, total,
+		elapsed.Round(time.Microsecond), rate,
+	)
+
+	return err
+}
+
+/*
+strategyNames joins the names of the strategies files were read with, i.e.
+`buffered+direct` - the strategy asked for is named if no data was read
+*/
+func strategyNames(used map[lib.ReadStrategy]bool, strategy lib.ReadStrategy) string {
+	var names []string
+	for _, s := range lib.ReadStrategies() {
+		if used[s] {
+			names = append(names, s.String())
+		}
+	}
+
+	if len(names) == 0 {
+		return strategy.String()
+	}
+
+	return strings.Join(names, "+")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+)
+
+func resetBench() {
+	openPath = lib.OpenFile
+
+	// Flags retain values across runs of the command, redefine them
+	benchCmd.ResetFlags()
+	setupBenchFlags()
+}
+
+func TestBenchCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetBench()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(
+			t, os.WriteFile(filepath.Join(dir, name), []byte("123456789"), 0o600),
+		)
+	}
+
+	resetBench()
+	out, err := execute(t, "bench", "-a", "crc32,sha1", "--buffer-size", "4096", dir)
+	require.NoError(t, err)
+
+	// Strategies not supported by the file system (i.e. direct on tmpfs) are skipped
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.GreaterOrEqual(t, len(lines), 1+2*2)
+	require.LessOrEqual(t, len(lines), 1+2*len(lib.ReadStrategies()))
+	assert.Equal(
+		t, []string{"STRATEGY", "ALGO", "BYTES", "TIME", "MiB/s"}, strings.Fields(lines[0]),
+	)
+
+	var names []string
+	for _, strategy := range lib.ReadStrategies() {
+		names = append(names, strategy.String())
+	}
+
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		assert.Contains(t, names, fields[0])
+		assert.Equal(t, "18", fields[2], "bytes not counted for both files")
+	}
+
+	assert.True(t, strings.HasPrefix(lines[1], "buffered"))
+
+	// Unknown algorithms, and missing paths should fail
+	resetBench()
+	_, err = execute(t, "bench", "-a", "crc16", dir)
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+
+	resetBench()
+	_, err = execute(t, "bench", filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestStrategyNames(t *testing.T) {
+	used := map[lib.ReadStrategy]bool{lib.ReadDirect: true, lib.ReadBuffered: true}
+	assert.Equal(t, "buffered+direct", strategyNames(used, lib.ReadDirect))
+
+	used[lib.ReadBuffered] = false
+	assert.Equal(t, "direct", strategyNames(used, lib.ReadDirect))
+
+	// Nothing read, the strategy asked for is named
+	assert.Equal(t, "mmap", strategyNames(nil, lib.ReadMmap))
+}
+
+func TestBenchCmd_Completion(t *testing.T) {
+	reset()
+	resetEnv()
+
+	for _, name := range []string{"hash", "generate", "verify", "scrub"} {
+		out, err := execute(t, "__complete", name, "--strategy", "")
+		require.NoError(t, err)
+
+		for _, strategy := range []string{"auto", "buffered", "mmap", "direct"} {
+			assert.Containsf(t, out, strategy, "failed for %s", name)
+		}
+	}
+}
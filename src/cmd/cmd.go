@@ -11,7 +11,11 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/notsatan/crcgen/src/cmd/version"
+	"github.com/notsatan/crcgen/src/lib"
 	"github.com/notsatan/crcgen/src/logger"
+
+	// Register handlers for output files
+	_ "github.com/notsatan/crcgen/src/writer/json"
 )
 
 const (
@@ -22,11 +26,15 @@ const (
 var (
 	exit        = os.Exit
 	initLogger  = logger.Log
-	execCmd     = Root.Execute
+	execCmd     = Root.ExecuteContext
 	cmdUsage    = (*cobra.Command).Usage
 	closeLogger = logger.Stop
 )
 
+// errPolicy decides how subcommands react to errors for individual files, set through
+// the `--on-error` flag
+var errPolicy = lib.OnErrorAbort
+
 // Root is the central command that nests all subcommands under it
 var Root = &cobra.Command{
 	Use:   "crcgen",
@@ -43,7 +51,7 @@ crcgen batch generates file checksums for files in a directory
 		if err != nil {
 			logger.Warnf("(%s/Root.Run): %v", pkgName, err)
 			closeRes()
-			exit(-10)
+			exit(ExitIOError)
 		}
 	},
 }
@@ -54,6 +62,10 @@ func init() {
 		"{{with .Name}}{{printf \"%s \" .}}{{end}}{{printf \"%s\" .Version}}\n\n",
 	)
 
+	Root.PersistentFlags().Var(
+		&errPolicy, "on-error", "action on errors for individual files: abort, continue",
+	)
+
 	setupCmdTemplate(Root)
 }
 
@@ -63,6 +75,9 @@ to the main command-line interface
 
 Once the root command completes, Run is also responsible to safely close the resources,
 before returning the flow-of-control
+
+The first SIGINT or SIGTERM cancels the context passed down to the subcommands, in which
+case Run returns an error that can be checked with IsInterruptedErr
 */
 func Run() error {
 	defer closeRes()
@@ -77,7 +92,23 @@ func Run() error {
 		logger.Debugf("(%s/main): Detected `debug` mode", pkgName)
 	}
 
-	err := execCmd() // run the root command
+	if err := registerCompletions(Root); err != nil {
+		return errors.Wrapf(err, "(%s/Run)", pkgName)
+	}
+
+	ctx, stop := handleSignals()
+	defer stop()
+
+	err := execCmd(ctx) // run the root command
+	if ctx.Err() != nil {
+		// Interrupted by a signal - whatever was written is partial
+		if err != nil {
+			logger.Warnf("(%s/Run): error after interrupt: %v", pkgName, err)
+		}
+
+		err = errInterrupted
+	}
+
 	return errors.Wrapf(err, "(%s/Run)", pkgName)
 }
 
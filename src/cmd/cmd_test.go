@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"testing"
 
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/notsatan/crcgen/src/lib"
 	"github.com/notsatan/crcgen/src/logger"
 )
 
@@ -27,9 +31,11 @@ func resetEnv() {
 func reset() {
 	exit = os.Exit
 	initLogger = logger.Log
-	execCmd = Root.Execute
+	execCmd = Root.ExecuteContext
 	cmdUsage = (*cobra.Command).Usage
 	closeLogger = logger.Stop
+	notifySignal = signal.Notify
+	stopSignal = signal.Stop
 }
 
 func TestMain(m *testing.M) {
@@ -51,7 +57,7 @@ func TestRun_DebugMode(t *testing.T) {
 	t.Setenv(debugMode, envDebug)
 
 	calls := 0
-	execCmd = func() error { return nil }
+	execCmd = func(context.Context) error { return nil }
 	initLogger = func(file bool) (err error) {
 		if file {
 			calls++
@@ -87,7 +93,7 @@ func TestRun_ProdMode(t *testing.T) {
 	resetEnv()
 
 	calls := 0
-	execCmd = func() error { return nil }
+	execCmd = func(context.Context) error { return nil }
 	initLogger = func(bool) (err error) {
 		calls++
 		return nil
@@ -103,13 +109,30 @@ func TestRun_CmdFail(t *testing.T) {
 	reset()
 	resetEnv()
 
-	execCmd = func() error {
+	execCmd = func(context.Context) error {
 		return fmt.Errorf("(%s/TestRun_CmdFail): test error", pkgName)
 	}
 
 	assert.Error(t, Run())
 }
 
+func TestRun_CompletionFail(t *testing.T) {
+	// Completions failing to register should be returned as an error
+
+	reset()
+	resetEnv()
+
+	cmd := &cobra.Command{Use: "test-cmd"}
+	cmd.Flags().String("algo", "", "")
+	require.NoError(t, cmd.RegisterFlagCompletionFunc("algo", completeAlgo))
+
+	Root.AddCommand(cmd)
+	defer Root.RemoveCommand(cmd)
+
+	execCmd = func(context.Context) error { return nil }
+	assert.Error(t, Run())
+}
+
 func TestCloseRes(t *testing.T) {
 	reset()
 
@@ -143,3 +166,15 @@ func TestRootCmd(t *testing.T) {
 	assert.NoError(t, Root.Execute())
 	assert.Equal(t, 1, calls)
 }
+
+func TestRootCmd_OnError(t *testing.T) {
+	defer func() { errPolicy = lib.OnErrorAbort }()
+
+	flag := Root.PersistentFlags().Lookup("on-error")
+	assert.NotNil(t, flag)
+	assert.Equal(t, "abort", flag.DefValue)
+
+	assert.NoError(t, flag.Value.Set("continue"))
+	assert.Equal(t, lib.OnErrorContinue, errPolicy)
+	assert.Error(t, flag.Value.Set("retry"))
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"github.com/spf13/pflag"
+
+	"github.com/notsatan/crcgen/src/cmd/version"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+var (
+	genManTree = doc.GenManTree // maps to doc.GenManTree
+	mkdirAll   = os.MkdirAll    // maps to os.MkdirAll
+)
+
+// flagCompletions maps flag names to functions providing dynamic completions for their
+// values. Registered on all subcommands by registerCompletions
+var flagCompletions = map[string]func(
+	*cobra.Command, []string, string,
+) ([]string, cobra.ShellCompDirective){
+	"algo":     completeAlgo,
+	"format":   completeFormat,
+	"strategy": completeStrategy,
+}
+
+// completedFlags tracks flags with a completion registered - cobra rejects registering
+// a flag twice
+var completedFlags = map[*pflag.Flag]bool{}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Generate shell completion script",
+	Long: `
+Generate the completion script for crcgen for the specified shell, and write it to
+stdout. As an example, to load completions in the current bash session;
+
+	source <(crcgen completion bash)
+`,
+	Args:                  checkArgs(cobra.ExactValidArgs(1)),
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+
+		out := cmd.OutOrStdout()
+		switch args[0] {
+		case "bash":
+			err = Root.GenBashCompletionV2(out, true)
+		case "zsh":
+			err = Root.GenZshCompletion(out)
+		case "fish":
+			err = Root.GenFishCompletion(out, true)
+		case "powershell":
+			err = Root.GenPowerShellCompletionWithDesc(out)
+		}
+
+		return errors.Wrapf(err, "(%s/completion)", pkgName)
+	},
+}
+
+var manCmd = &cobra.Command{
+	Use:   "man <dir>",
+	Short: "Generate man pages for crcgen",
+	Long: `
+Generate man pages for crcgen and all its commands, and write them to a directory. The
+directory is created if needed
+`,
+	Args:                  checkArgs(cobra.ExactArgs(1)),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := mkdirAll(args[0], 0o755); err != nil {
+			return errors.Wrapf(err, "(%s/man)", pkgName)
+		}
+
+		header := &doc.GenManHeader{
+			Title:   "CRCGEN",
+			Section: "1",
+			Source:  "crcgen " + version.Get(),
+		}
+
+		err := genManTree(Root, header, args[0])
+		return errors.Wrapf(err, "(%s/man)", pkgName)
+	},
+}
+
+func init() {
+	// Replaced by completionCmd, which respects the custom usage template
+	Root.CompletionOptions.DisableDefaultCmd = true
+
+	Root.AddCommand(completionCmd, manCmd)
+}
+
+/*
+registerCompletions registers dynamic completions for the flags of a command, and the
+commands nested within it. Flags registered by an earlier call are skipped
+*/
+func registerCompletions(cmd *cobra.Command) error {
+	for name, complete := range flagCompletions {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || completedFlags[flag] {
+			continue
+		}
+
+		if err := cmd.RegisterFlagCompletionFunc(name, complete); err != nil {
+			return errors.Wrapf(err, "(%s/registerCompletions)", pkgName)
+		}
+
+		completedFlags[flag] = true
+	}
+
+	for _, sub := range cmd.Commands() {
+		if err := registerCompletions(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+completeOutput completes arguments of the `generate` command - the directory to be
+processed, followed by the path to the output file, limited to the extensions for which
+a writer.Handler has been registered
+*/
+func completeOutput(
+	_ *cobra.Command, args []string, _ string,
+) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	case 1:
+		return writer.FileTypes(), cobra.ShellCompDirectiveFilterFileExt
+	}
+
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
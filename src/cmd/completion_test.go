@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetCompletion() {
+	genManTree = doc.GenManTree
+	mkdirAll = os.MkdirAll
+}
+
+// execute runs the Root command with the arguments, returns the output
+func execute(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	Root.SetOut(&out)
+	Root.SetArgs(args)
+
+	defer func() {
+		Root.SetOut(nil)
+		Root.SetArgs(nil)
+	}()
+
+	// Registered by Run, which is bypassed here
+	require.NoError(t, registerCompletions(Root))
+
+	err := Root.Execute()
+	return out.String(), err
+}
+
+func TestCompletionCmd(t *testing.T) {
+	reset()
+	resetEnv()
+
+	for shell, marker := range map[string]string{
+		"bash":       "bash completion V2 for crcgen",
+		"zsh":        "#compdef _crcgen crcgen",
+		"fish":       "fish completion for crcgen",
+		"powershell": "powershell completion for crcgen",
+	} {
+		out, err := execute(t, "completion", shell)
+		assert.NoErrorf(t, err, `failed for "%s"`, shell)
+		assert.Containsf(t, out, marker, `failed for "%s"`, shell)
+	}
+
+	_, err := execute(t, "completion", "tcsh")
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err), "invalid shell not rejected")
+}
+
+func TestManCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	resetCompletion()
+
+	dir := filepath.Join(t.TempDir(), "man") // should be created
+	_, err := execute(t, "man", dir)
+	require.NoError(t, err)
+
+	for _, page := range []string{"crcgen.1", "crcgen-man.1", "crcgen-completion.1"} {
+		assert.FileExists(t, filepath.Join(dir, page))
+	}
+
+	mkdirAll = func(string, os.FileMode) error { return os.ErrPermission }
+	_, err = execute(t, "man", dir)
+	assert.Error(t, err)
+
+	resetCompletion()
+	genManTree = func(*cobra.Command, *doc.GenManHeader, string) error {
+		return fmt.Errorf("(%s/TestManCmd): test error", pkgName)
+	}
+
+	_, err = execute(t, "man", dir)
+	assert.Error(t, err)
+}
+
+func TestRegisterCompletions(t *testing.T) {
+	cmd := &cobra.Command{Use: "test-cmd", Run: func(*cobra.Command, []string) {}}
+	cmd.Flags().String("format", "", "")
+	Root.AddCommand(cmd)
+
+	defer Root.RemoveCommand(cmd)
+
+	// Registering again should skip flags registered earlier
+	require.NoError(t, registerCompletions(Root))
+	require.NoError(t, registerCompletions(Root))
+
+	out, err := execute(t, cobra.ShellCompRequestCmd, "test-cmd", "--format", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, "json")
+
+	// Registration errors are returned, not panics
+	other := &cobra.Command{Use: "other-cmd"}
+	other.Flags().String("algo", "", "")
+	require.NoError(t, other.RegisterFlagCompletionFunc("algo", completeAlgo))
+	assert.Error(t, registerCompletions(other))
+}
+
+func TestCompleteOutput(t *testing.T) {
+	reset()
+	resetEnv()
+
+	// The directory to be processed, followed by the output file
+	out, err := execute(t, cobra.ShellCompRequestCmd, "generate", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, fmt.Sprintf(":%d", cobra.ShellCompDirectiveFilterDirs))
+
+	out, err = execute(t, cobra.ShellCompRequestCmd, "generate", "dir", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, "json")
+	assert.Contains(t, out, fmt.Sprintf(":%d", cobra.ShellCompDirectiveFilterFileExt))
+
+	out, err = execute(t, cobra.ShellCompRequestCmd, "generate", "dir", "out.json", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, fmt.Sprintf(":%d", cobra.ShellCompDirectiveNoFileComp))
+}
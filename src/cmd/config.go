@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+const (
+	// configName is the name of the config file looked up in the working directory
+	configName = ".crcgen.yaml"
+
+	// envPrefix is prepended to flag names to form the environment variable for a flag
+	envPrefix = "CRCGEN_"
+
+	// configured is the annotation marking flags set through the config or environment
+	configured = "crcgen/configured"
+)
+
+// errConfig indicates that the config file could not be read or applied
+var errConfig = fmt.Errorf("(%s): invalid config file: %w", pkgName, errInvalidArgs)
+
+var (
+	getwd      = os.Getwd          // maps to os.Getwd
+	userConfig = os.UserConfigDir  // maps to os.UserConfigDir
+	readConfig = os.ReadFile       // maps to os.ReadFile
+	lookupEnv  = os.LookupEnv      // maps to os.LookupEnv
+	pathExists = pathExistsDefault // checks if a file exists
+)
+
+// configPath contains the path to the config file passed with the `--config` flag
+var configPath string
+
+// skipFlags contains flags that can't be set through the config file or environment
+var skipFlags = map[string]bool{"config": true, "help": true, "version": true}
+
+func init() {
+	Root.PersistentFlags().StringVar(
+		&configPath, "config", "",
+		"config file (default ./"+configName+", or $XDG_CONFIG_HOME/crcgen/"+configName+")",
+	)
+
+	// Runs before every subcommand, unless the subcommand overrides it
+	Root.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		return applyConfig(cmd)
+	}
+}
+
+func pathExistsDefault(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+/*
+findConfig locates the config file. A path passed through `--config` is used as is, if
+not, the working directory is searched followed by the user config directory
+
+Returns an empty string if no config file could be found
+*/
+func findConfig() string {
+	if configPath != "" {
+		return configPath
+	}
+
+	if dir, err := getwd(); err == nil && pathExists(filepath.Join(dir, configName)) {
+		return filepath.Join(dir, configName)
+	}
+
+	// os.UserConfigDir respects $XDG_CONFIG_HOME
+	if dir, err := userConfig(); err == nil {
+		if path := filepath.Join(dir, "crcgen", configName); pathExists(path) {
+			return path
+		}
+	}
+
+	return ""
+}
+
+/*
+loadConfig reads the config file, and returns the values applicable to a command. Keys
+at the top-level of the config file apply to all commands, while keys nested under the
+name of a command apply to that command alone (and take precedence)
+
+Example;
+
+	on-error: continue
+	hash:
+	  algo: crc32c
+*/
+func loadConfig(path, cmdName string) (map[string]string, error) {
+	data, err := readConfig(path)
+	if err != nil {
+		return nil, errors.Wrapf(errConfig, "(%s/loadConfig): %v", pkgName, err)
+	}
+
+	raw := map[string]interface{}{}
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrapf(errConfig, "(%s/loadConfig): %v", pkgName, err)
+	}
+
+	values := map[string]string{}
+	for key, val := range raw {
+		if _, ok := val.(map[string]interface{}); !ok {
+			values[key] = configValue(val)
+		}
+	}
+
+	if section, ok := raw[cmdName].(map[string]interface{}); ok {
+		for key, val := range section {
+			values[key] = configValue(val)
+		}
+	}
+
+	return values, nil
+}
+
+/*
+configValue converts a value from the config file to the string form accepted by flags,
+lists are joined with commas
+*/
+func configValue(val interface{}) string {
+	list, ok := val.([]interface{})
+	if !ok {
+		return fmt.Sprint(val)
+	}
+
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		items = append(items, fmt.Sprint(item))
+	}
+
+	return strings.Join(items, ",")
+}
+
+/*
+envName returns the name of the environment variable for a flag, i.e. the flag
+`on-error` maps to `CRCGEN_ON_ERROR`
+*/
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+/*
+applyConfig sets flags of a command that have not been passed on the command line, the
+precedence being; flag > environment variable > config file > default
+
+Flags set here are not marked as changed, checks for conflicting flags only apply to
+the command line. Use `isConfigured` to find flags set through the config or environment
+*/
+func applyConfig(cmd *cobra.Command) error {
+	values := map[string]string{}
+	if path := findConfig(); path != "" {
+		logger.Debugf(`(%s/applyConfig): using config file "%s"`, pkgName, path)
+
+		var err error
+		if values, err = loadConfig(path, cmd.Name()); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || skipFlags[flag.Name] {
+			return
+		}
+
+		val, ok := lookupEnv(envName(flag.Name))
+		if !ok {
+			val, ok = values[flag.Name]
+		}
+
+		if ok {
+			if e := flag.Value.Set(val); e != nil {
+				err = errors.Wrapf(
+					errConfig, "(%s/applyConfig): --%s: %v", pkgName, flag.Name, e,
+				)
+
+				return
+			}
+
+			_ = cmd.Flags().SetAnnotation(flag.Name, configured, []string{val})
+		}
+	})
+
+	return err
+}
+
+// isConfigured checks if a flag was set through the config file or environment
+func isConfigured(cmd *cobra.Command, name string) bool {
+	flag := cmd.Flags().Lookup(name)
+	return flag != nil && flag.Annotations[configured] != nil
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetConfig() {
+	getwd = os.Getwd
+	userConfig = os.UserConfigDir
+	readConfig = os.ReadFile
+	lookupEnv = os.LookupEnv
+	pathExists = pathExistsDefault
+	configPath = ""
+}
+
+// writeConfig writes a config file to a temporary directory, returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+// testCmd creates a command with a few flags to apply the config to
+func testCmd() *cobra.Command {
+	cmd := &cobra.Command{Use: "hash"}
+	cmd.Flags().String("algo", "crc32", "")
+	cmd.Flags().Int("jobs", 1, "")
+	cmd.Flags().StringSlice("exclude", nil, "")
+	cmd.Flags().String("on-error", "abort", "")
+
+	return cmd
+}
+
+func TestEnvName(t *testing.T) {
+	for flag, expected := range map[string]string{
+		"on-error": "CRCGEN_ON_ERROR",
+		"algo":     "CRCGEN_ALGO",
+		"a-b-c":    "CRCGEN_A_B_C",
+	} {
+		assert.Equal(t, expected, envName(flag))
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	resetConfig()
+	defer resetConfig()
+
+	cwd := filepath.Dir(writeConfig(t, configName, ""))
+	xdg := filepath.Dir(writeConfig(t, "crcgen/"+configName, ""))
+
+	getwd = func() (string, error) { return cwd, nil }
+	userConfig = func() (string, error) { return filepath.Dir(xdg), nil }
+
+	// Working directory takes precedence over the user config directory
+	assert.Equal(t, filepath.Join(cwd, configName), findConfig())
+
+	getwd = func() (string, error) { return "", os.ErrNotExist }
+	assert.Equal(t, filepath.Join(xdg, configName), findConfig())
+
+	userConfig = func() (string, error) { return "", os.ErrNotExist }
+	assert.Empty(t, findConfig())
+
+	// Path passed with `--config` should always be used
+	configPath = "/path/to/config.yaml"
+	assert.Equal(t, configPath, findConfig())
+}
+
+func TestLoadConfig(t *testing.T) {
+	resetConfig()
+	defer resetConfig()
+
+	path := writeConfig(t, configName, `
+algo: sha256
+jobs: 4
+exclude:
+  - "*.tmp"
+  - .git
+hash:
+  algo: crc32c
+verify:
+  algo: md5
+`)
+
+	values, err := loadConfig(path, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"algo": "crc32c", "jobs": "4", "exclude": "*.tmp,.git",
+	}, values)
+
+	values, err = loadConfig(path, "scrub")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256", values["algo"])
+
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), "hash")
+	assert.True(t, errors.Is(err, errInvalidArgs))
+
+	_, err = loadConfig(writeConfig(t, "bad.yaml", "algo: [unterminated"), "hash")
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestApplyConfig(t *testing.T) {
+	resetConfig()
+	defer resetConfig()
+
+	configPath = writeConfig(t, configName, "algo: sha256\njobs: 4\non-error: continue")
+	lookupEnv = func(key string) (string, bool) {
+		if key == "CRCGEN_JOBS" {
+			return "8", true
+		}
+
+		return "", false
+	}
+
+	cmd := testCmd()
+	require.NoError(t, cmd.ParseFlags([]string{"--on-error", "abort"}))
+	require.NoError(t, applyConfig(cmd))
+
+	// flag > env > config > default
+	for flag, expected := range map[string]string{
+		"on-error": "abort",
+		"jobs":     "8",
+		"algo":     "sha256",
+		"exclude":  "[]",
+	} {
+		assert.Equalf(t, expected, cmd.Flags().Lookup(flag).Value.String(), flag)
+	}
+
+	// Only flags passed on the command line should be marked as changed
+	assert.True(t, cmd.Flags().Changed("on-error"))
+	assert.False(t, isConfigured(cmd, "on-error"))
+
+	for _, flag := range []string{"jobs", "algo"} {
+		assert.Falsef(t, cmd.Flags().Changed(flag), flag)
+		assert.Truef(t, isConfigured(cmd, flag), flag)
+	}
+
+	assert.False(t, isConfigured(cmd, "exclude"))
+	assert.False(t, isConfigured(cmd, "missing"))
+}
+
+func TestApplyConfig_Fail(t *testing.T) {
+	resetConfig()
+	defer resetConfig()
+
+	// Invalid values should be reported as invalid arguments
+	configPath = writeConfig(t, configName, "jobs: many")
+	assert.Equal(t, ExitInvalidArgs, ExitCode(applyConfig(testCmd())))
+
+	configPath = filepath.Join(t.TempDir(), "missing.yaml")
+	assert.Error(t, applyConfig(testCmd()))
+
+	// No config file should not be an error
+	configPath = ""
+	getwd = func() (string, error) { return t.TempDir(), nil }
+	userConfig = func() (string, error) { return t.TempDir(), nil }
+	assert.NoError(t, applyConfig(testCmd()))
+}
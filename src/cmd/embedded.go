@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+var embeddedCmd = &cobra.Command{
+	Use:   "check-embedded <paths...>",
+	Short: "Validate checksums embedded in zip, gzip and PNG files",
+	Long: `
+Recompute checksums embedded in files, and validate them against the stored values -
+without needing an output file. Supports zip archives (CRC32 of each entry), gzip files
+(CRC32 in the trailer), and PNG images (CRC32 of each chunk)
+
+Directories are walked recursively, files in other formats are skipped
+`,
+	Args: checkArgs(cobra.MinimumNArgs(1)),
+	RunE: runEmbedded,
+}
+
+func init() {
+	Root.AddCommand(embeddedCmd)
+}
+
+/*
+embeddedStats counts the outcome of checking embedded checksums
+*/
+type embeddedStats struct {
+	checked, corrupt, failed int
+}
+
+/*
+result converts the counts into the error returned by the command, if any
+*/
+func (stats *embeddedStats) result() error {
+	const logTag = "(" + pkgName + "/check-embedded)"
+
+	switch {
+	case stats.corrupt > 0:
+		return errors.Wrapf(errMismatch, "%s: %d corrupt", logTag, stats.corrupt)
+	case stats.failed > 0:
+		return errors.Wrapf(errPartial, "%s: %d failed", logTag, stats.failed)
+	}
+
+	return nil
+}
+
+func runEmbedded(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	var stats embeddedStats
+	walkFunc := func(path string, info fs.FileInfo, err error) error {
+		switch {
+		case cmd.Context().Err() != nil:
+			return cmd.Context().Err() // interrupted, stop the walk
+		case err != nil:
+			stats.failed++
+			return err
+		case !info.Mode().IsRegular():
+			return nil // special files are never read
+		}
+
+		return checkEmbedded(cmd.OutOrStdout(), path, &stats)
+	}
+
+	for _, path := range args {
+		// Partial walks are reflected in the counts, errors have already been logged
+		err := lib.WalkPath(path, errPolicy, walkFunc)
+		if err != nil && !lib.IsPartialErr(err) {
+			return errors.Wrapf(err, "(%s/check-embedded)", pkgName)
+		}
+	}
+
+	logger.Infof(
+		"(%s/check-embedded): %d checked, %d corrupt, %d failed",
+		pkgName, stats.checked, stats.corrupt, stats.failed,
+	)
+
+	return stats.result()
+}
+
+/*
+checkEmbedded validates the checksums embedded in a file, and prints the outcome. Files
+without embedded checksums are skipped silently
+*/
+func checkEmbedded(out io.Writer, path string, stats *embeddedStats) error {
+	err := lib.CheckEmbedded(path)
+
+	status := "ok"
+	switch {
+	case lib.IsNoEmbeddedErr(err):
+		return nil
+
+	case lib.IsEmbeddedCRCErr(err):
+		logger.Warnf("(%s/checkEmbedded): %v", pkgName, err)
+		stats.corrupt++
+		status = "corrupt"
+
+	case err != nil:
+		stats.failed++
+		return err
+	}
+
+	stats.checked++
+	_, err = fmt.Fprintf(out, "%s  %s\n", status, path)
+	return errors.Wrapf(err, "(%s/checkEmbedded)", pkgName)
+}
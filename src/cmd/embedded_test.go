@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+)
+
+// writeGzip writes a gzip file to the directory, corrupting its trailer if needed
+func writeGzip(t *testing.T, dir, name string, corrupt bool) string {
+	t.Helper()
+
+	var data bytes.Buffer
+	gz := gzip.NewWriter(&data)
+	_, err := gz.Write([]byte("123456789"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	raw := data.Bytes()
+	if corrupt {
+		raw[len(raw)-8] ^= 1 // flip a bit in the stored CRC32
+	}
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, raw, 0o600))
+
+	return path
+}
+
+func TestEmbeddedCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	defer func() { errPolicy = lib.OnErrorAbort }()
+
+	dir := t.TempDir()
+	valid := writeGzip(t, dir, "valid.gz", false)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600))
+
+	// Files without embedded checksums should be skipped
+	out, err := execute(t, "check-embedded", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok  "+valid+"\n", out)
+
+	corrupt := writeGzip(t, dir, "corrupt.gz", true)
+	out, err = execute(t, "check-embedded", dir)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Contains(t, out, "corrupt  "+corrupt+"\n")
+	assert.Contains(t, out, "ok  "+valid+"\n")
+
+	_, err = execute(t, "check-embedded")
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestEmbeddedCmd_OnError(t *testing.T) {
+	reset()
+	resetEnv()
+	defer func() { errPolicy = lib.OnErrorAbort }()
+
+	dir := t.TempDir()
+	valid := writeGzip(t, dir, "valid.gz", false)
+
+	// Truncated gzip files can't be checked at all
+	broken := filepath.Join(dir, "broken.gz")
+	require.NoError(t, os.WriteFile(broken, []byte{0x1f, 0x8b, 8}, 0o600))
+
+	// Abort on the first file that can't be checked
+	_, err := execute(t, "check-embedded", broken, valid)
+	assert.Error(t, err)
+	assert.NotEqual(t, ExitPartial, ExitCode(err))
+
+	out, err := execute(t, "check-embedded", "--on-error", "continue", broken, valid)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+	assert.Equal(t, "ok  "+valid+"\n", out)
+}
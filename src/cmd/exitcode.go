@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+/*
+Exit codes used by crcgen. These values are stable - scripts can safely rely on them to
+tell apart different kinds of failures
+*/
+const (
+	// ExitOK indicates a successful run
+	ExitOK = 0
+
+	// ExitFailure indicates an unexpected failure not covered by other exit codes
+	ExitFailure = 1
+
+	// ExitInvalidArgs indicates invalid flags, arguments, or paths passed to crcgen
+	ExitInvalidArgs = 2
+
+	// ExitIOError indicates that a file could not be read from or written to
+	ExitIOError = 3
+
+	// ExitInvalidManifest indicates that an existing output file could not be parsed
+	ExitInvalidManifest = 4
+
+	// ExitPartial indicates that the run completed, but some files were skipped due
+	// to errors
+	ExitPartial = 5
+
+	// ExitMissing indicates that files listed in the output file no longer exist
+	ExitMissing = 6
+
+	// ExitMismatch indicates that checksums of one or more files did not match, while
+	// their mtime was unchanged - probable silent corruption
+	ExitMismatch = 7
+
+	// ExitModified indicates that one or more files were modified after their
+	// checksums were recorded, i.e. checksums and mtime both changed
+	ExitModified = 8
+
+	// ExitDrift indicates that permissions, ownership, or extended attributes of one or
+	// more files changed, while their contents did not
+	ExitDrift = 9
+
+	// ExitUnexpected indicates that entries absent from the output file were found -
+	// empty directories, or files added to archives
+	ExitUnexpected = 10
+
+	// ExitInterrupted indicates that the run was stopped by a signal
+	ExitInterrupted = 130
+)
+
+var (
+	// errInvalidArgs indicates that flags or arguments passed to a command were invalid
+	errInvalidArgs = fmt.Errorf("(%s): invalid arguments", pkgName)
+
+	// errPartial indicates that a command completed, but skipped some files due to
+	// errors
+	errPartial = fmt.Errorf("(%s): completed with errors", pkgName)
+
+	// errMismatch indicates that checksums for one or more files did not match
+	errMismatch = fmt.Errorf("(%s): checksum mismatch", pkgName)
+
+	// errModified indicates that files were modified after checksums were recorded
+	errModified = fmt.Errorf("(%s): files modified", pkgName)
+
+	// errUnexpected indicates that entries absent from an output file were found
+	errUnexpected = fmt.Errorf("(%s): unexpected entries", pkgName)
+
+	// errDrift indicates that metadata of files changed after it was recorded
+	errDrift = fmt.Errorf("(%s): metadata drift", pkgName)
+
+	// errMissing indicates that files listed in an output file no longer exist
+	errMissing = fmt.Errorf("(%s): files missing", pkgName)
+)
+
+func init() {
+	// Flag errors are raised by cobra with plain errors, mark them to be detected
+	Root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return errors.Wrapf(errInvalidArgs, "%v", err)
+	})
+}
+
+/*
+checkArgs wraps a validator for positional arguments, so that errors are mapped to the
+ExitInvalidArgs exit code
+*/
+func checkArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return errors.Wrapf(errInvalidArgs, "%v", err)
+		}
+
+		return nil
+	}
+}
+
+/*
+ExitCode maps an error returned by Run to the exit code crcgen should exit with
+*/
+func ExitCode(err error) int {
+	var pathErr *fs.PathError
+
+	switch {
+	case err == nil:
+		return ExitOK
+
+	case IsInterruptedErr(err):
+		return ExitInterrupted
+
+	case errors.Is(err, errMismatch):
+		return ExitMismatch
+
+	case errors.Is(err, errMissing):
+		return ExitMissing
+
+	case errors.Is(err, errModified):
+		return ExitModified
+
+	case errors.Is(err, errUnexpected):
+		return ExitUnexpected
+
+	case errors.Is(err, errDrift):
+		return ExitDrift
+
+	case lib.IsPartialErr(err), errors.Is(err, errPartial):
+		return ExitPartial
+
+	// Checked before IsInvalidFileErr - malformed output files match both
+	case writer.IsInvalidManifestErr(err):
+		return ExitInvalidManifest
+
+	case errors.Is(err, errInvalidArgs),
+		lib.IsInvalidPathErr(err),
+		lib.IsInvalidPolicyErr(err),
+		writer.IsInvalidFileErr(err),
+		writer.IsInvalidExtErr(err),
+		writer.IsAbsPathErr(err),
+		writer.IsPathDirErr(err),
+		writer.IsHandlerNotFoundErr(err):
+		return ExitInvalidArgs
+
+	case writer.IsReadFileErr(err),
+		writer.IsPathNotWriteableErr(err),
+		lib.IsUnstableErr(err),
+		errors.As(err, &pathErr):
+		return ExitIOError
+
+	default:
+		return ExitFailure
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func TestExitCode(t *testing.T) {
+	// Trigger real errors from packages wherever possible, the sentinel errors are
+	// not exported
+	walkErr := lib.WalkPath("<invalid path>", lib.OnErrorAbort, nil)
+	_, policyErr := lib.ParseErrorPolicy("invalid")
+	_, readErr := os.ReadFile(filepath.Join(t.TempDir(), "missing"))
+
+	for err, expected := range map[error]int{
+		nil:                                     ExitOK,
+		errInterrupted:                          ExitInterrupted,
+		errors.Wrap(errInterrupted, "test"):     ExitInterrupted,
+		errors.Wrap(errInvalidArgs, "test"):     ExitInvalidArgs,
+		errors.Wrap(errMismatch, "test"):        ExitMismatch,
+		errors.Wrap(errMissing, "test"):         ExitMissing,
+		errors.Wrap(errModified, "test"):        ExitModified,
+		errors.Wrap(errDrift, "test"):           ExitDrift,
+		errors.Wrap(errUnexpected, "test"):      ExitUnexpected,
+		errors.Wrap(errPartial, "test"):         ExitPartial,
+		walkErr:                                 ExitInvalidArgs,
+		policyErr:                               ExitInvalidArgs,
+		readErr:                                 ExitIOError,
+		&fs.PathError{Err: os.ErrPermission}:    ExitIOError,
+		fmt.Errorf("(%s): test error", pkgName): ExitFailure,
+	} {
+		assert.Equalf(t, expected, ExitCode(err), `failed for "%v"`, err)
+	}
+}
+
+func TestExitCode_Writer(t *testing.T) {
+	// Output file with an unknown extension should be treated as invalid arguments
+	err := writer.Start(filepath.Join(t.TempDir(), "output.unknown"))
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestRootCmd_FlagError(t *testing.T) {
+	reset()
+	resetEnv()
+
+	Root.SetArgs([]string{"--unknown-flag"})
+	defer Root.SetArgs(nil)
+
+	assert.Equal(t, ExitInvalidArgs, ExitCode(Root.Execute()))
+}
+
+func TestCheckArgs(t *testing.T) {
+	validate := checkArgs(cobra.ExactArgs(1))
+
+	assert.NoError(t, validate(Root, []string{"a"}))
+	assert.Equal(t, ExitInvalidArgs, ExitCode(validate(Root, []string{"a", "b"})))
+}
@@ -0,0 +1,506 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// generateFlags contains values for flags of the `generate` command
+var generateFlags = struct {
+	resume       bool
+	intoArchives bool
+	blockSize    int64
+	cache        bool
+	metadata     bool
+	metaXattrs   []string
+	quick        bool
+	fromXattr    bool
+}{}
+
+// previous contains entries from the output file of an earlier run with --quick
+var previous map[string]writer.FileInfo
+
+var generateCmd = &cobra.Command{
+	Use:   "generate <dir> <output-file>",
+	Short: "Write checksums for all files in a directory to an output file",
+	Long: `
+Compute checksums for every file in a directory (and directories nested within it), and
+write them to an output file. The format of the output file is picked based on its
+extension
+
+Completed files are periodically checkpointed to a journal next to the output file
+(<output-file>.journal). If a run is stopped before it completes, use --resume to pick
+up where it stopped - files recorded in the journal are skipped, unless they were
+modified since. The journal is removed once the output file is written
+
+With --into-archives, files inside zip, tar, and tar.gz archives are recorded as well,
+nested under the path to the archive as though it was a directory. These are verified
+against the contents of the archive - in addition to the archive file itself
+
+With --cache, checksums of files that are unchanged since they were last hashed (same
+device, inode, size, and mtime) are reused from the cache shared with hash --cache,
+instead of reading the files again. Not used along with --block-size
+
+With --block-size, a crc32 is also recorded for each block of the given size. When a
+file no longer matches, verify then reports the byte ranges that differ - rather than
+only the file as a whole
+
+With --quick, a quick hash (see hash --quick) is recorded for each file as well. When
+the output file exists, the crc32 recorded for a file is reused unless its size or
+quick hash changed - only new and changed files are read completely
+
+With --from-xattr, the crc32 stored in the extended attributes of a file (see xattr
+write) is recorded as is, if the file wasn't modified since - instead of reading the
+file. Not used along with --block-size
+
+With --metadata, permissions, ownership, and the extended attributes named by
+--metadata-xattrs (POSIX ACLs by default) are recorded for each file as well - compared
+by verify --metadata
+
+Every directory is recorded along with its mode and mtime, including empty directories -
+verify reports recorded directories that went missing, and empty directories that
+appeared since
+
+Special files - FIFOs, sockets, and devices - are recorded with their type (and device
+numbers) without being read. Symbolic links are never followed, and are recorded along
+with their target. verify reports special files replaced by a different type, device,
+or target as modified
+
+With --on-error continue, files that can't be read are recorded in the output file with
+the error, and listed once the run completes - exiting with code 5 (partial)
+
+When stopped by SIGINT or SIGTERM, files completed so far are written to the output
+file, marked as partial - and the journal is kept to resume the run
+`,
+	Example: `  crcgen generate /mnt/archive /mnt/archive/checksums.json
+  crcgen generate --resume /mnt/archive /mnt/archive/checksums.json
+  crcgen generate --into-archives /mnt/backups /mnt/backups/checksums.json`,
+	Args:              checkArgs(cobra.ExactArgs(2)),
+	ValidArgsFunction: completeOutput,
+	RunE:              runGenerate,
+}
+
+func init() {
+	setupGenerateFlags()
+	Root.AddCommand(generateCmd)
+}
+
+/*
+setupGenerateFlags defines flags for the `generate` command
+*/
+func setupGenerateFlags() {
+	generateCmd.Flags().BoolVar(
+		&generateFlags.resume, "resume", false,
+		"skip files recorded in the journal of an earlier run that was stopped",
+	)
+
+	generateCmd.Flags().BoolVar(
+		&generateFlags.intoArchives, "into-archives", false,
+		"also record files inside zip, tar, and tar.gz archives",
+	)
+
+	generateCmd.Flags().Int64Var(
+		&generateFlags.blockSize, "block-size", 0,
+		"also record crc32 for each block of this size (in bytes)",
+	)
+
+	generateCmd.Flags().BoolVar(
+		&generateFlags.cache, "cache", false,
+		"reuse checksums of unchanged files from the cache, and cache new checksums",
+	)
+
+	generateCmd.Flags().BoolVar(
+		&generateFlags.quick, "quick", false,
+		"also record quick hashes, reusing checksums of files whose quick hash is unchanged",
+	)
+
+	generateCmd.Flags().BoolVar(
+		&generateFlags.fromXattr, "from-xattr", false,
+		"trust crc32 stored in extended attributes, if files weren't modified since",
+	)
+
+	generateCmd.Flags().BoolVar(
+		&generateFlags.metadata, "metadata", false,
+		"also record permissions, ownership, and extended attributes",
+	)
+
+	generateCmd.Flags().StringSliceVar(
+		&generateFlags.metaXattrs, "metadata-xattrs", lib.ACLXattrs,
+		"extended attributes recorded with --metadata",
+	)
+
+	setupReadFlags(generateCmd)
+}
+
+func runGenerate(cmd *cobra.Command, args []string) error {
+	if err := validateBlockSize(generateFlags.blockSize, formatJSON); err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(args[0])
+	if err != nil {
+		return errors.Wrapf(errInvalidArgs, "%v", err)
+	}
+
+	output, err := filepath.Abs(args[1])
+	if err != nil {
+		return errors.Wrapf(errInvalidArgs, "%v", err)
+	}
+
+	cmd.SilenceUsage = true
+
+	if generateFlags.cache {
+		closeCache := openCache()
+		defer closeCache()
+	}
+
+	journal, done, err := openJournal(output)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/generate)", pkgName)
+	}
+
+	previous = previousEntries(output)
+
+	tree := writer.DirInfo{Path: root}
+	skip := map[string]bool{
+		output: true, output + ".tmp": true, writer.JournalPath(output): true,
+	}
+
+	walkFunc := func(path string, info fs.FileInfo, err error) error {
+		switch {
+		case cmd.Context().Err() != nil:
+			return cmd.Context().Err() // interrupted, stop the walk
+		case skip[path]:
+			return nil
+		case err != nil:
+			// Only reached with the continue policy, the walk stops on errors otherwise
+			tree.AddFile(writer.FileInfo{Path: path, Error: lib.ErrorKind(err)})
+			return err
+		}
+
+		// Entries from the journal are only reused if they have the same blocks
+		entry, ok := done[path]
+		ok = ok && entry.BlockSize == generateFlags.blockSize
+		if !ok || !writer.Resumable(&entry, info) {
+			if entry, err = generateFile(path, info); err != nil {
+				tree.AddFile(entry) // recorded with the error, never journaled
+				return err
+			}
+
+			if err = journal.Add(&entry); err != nil {
+				return err
+			}
+		}
+
+		// Metadata is captured afresh, changing it leaves the mtime of files as is
+		if err = captureMetadata(&entry, info); err != nil {
+			tree.AddFile(entry)
+			return err
+		}
+
+		return addEntry(&tree, entry)
+	}
+
+	err = lib.WalkPath(root, errPolicy, walkFunc)
+	if e := journal.Close(); err == nil {
+		err = e
+	}
+
+	// Files completed before an interrupt are written, with the output marked partial
+	interrupted := cmd.Context().Err() != nil
+	if err != nil && !lib.IsPartialErr(err) && !interrupted {
+		return errors.Wrapf(err, "(%s/generate)", pkgName)
+	}
+
+	tree.Partial = interrupted
+	_ = tree.CalcModTime()
+	if !interrupted {
+		if e := recordDirs(&tree); e != nil {
+			return errors.Wrapf(e, "(%s/generate)", pkgName)
+		}
+	}
+
+	_ = tree.CalcDigest()
+	if e := writer.WriteManifest(output, &tree); e != nil {
+		return errors.Wrapf(e, "(%s/generate)", pkgName)
+	}
+
+	// The output file is complete, the journal is no longer needed. Partial runs keep
+	// the journal, to be resumed
+	if interrupted {
+		logger.Warnf("(%s/generate): interrupted, partial output written", pkgName)
+	} else if e := writer.RemoveJournal(writer.JournalPath(output)); e != nil {
+		logger.Warnf("(%s/generate): failed to remove journal: %v", pkgName, e)
+	}
+
+	failed := tree.Failed()
+	if e := printFailed(cmd.OutOrStdout(), failed); e != nil {
+		return errors.Wrapf(e, "(%s/generate)", pkgName)
+	}
+
+	logger.Infof(
+		"(%s/generate): %d files written to %s, %d failed",
+		pkgName, len(tree.AllFiles()), output, len(failed),
+	)
+
+	return errors.Wrapf(err, "(%s/generate)", pkgName)
+}
+
+/*
+printFailed writes the summary of files that could not be processed, one line per file
+along with the error
+*/
+func printFailed(out io.Writer, failed []writer.FileInfo) error {
+	for i := range failed {
+		_, err := fmt.Fprintf(out, "error  %s  (%s)\n", failed[i].Path, failed[i].Error)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+openJournal opens the journal for the output file. With --resume, entries recorded by
+an earlier run are returned, mapped to their paths - otherwise, the journal is started
+afresh
+*/
+func openJournal(output string) (*writer.Journal, map[string]writer.FileInfo, error) {
+	path := writer.JournalPath(output)
+
+	done := map[string]writer.FileInfo{}
+	if generateFlags.resume {
+		entries, err := writer.ReadJournal(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		logger.Infof("(%s/generate): resuming with %d files", pkgName, len(entries))
+		done = entries
+	} else if err := writer.RemoveJournal(path); err != nil {
+		return nil, nil, err
+	}
+
+	journal, err := writer.OpenJournal(path, 0)
+	return journal, done, err
+}
+
+/*
+addEntry adds the entry for a file to the tree. With --into-archives, files inside an
+archive are added as well, nested under the path to the archive. Contents of archives
+are not journaled, and are read again when resuming. Special files are never expanded,
+whatever their name
+*/
+func addEntry(tree *writer.DirInfo, entry writer.FileInfo) error {
+	if !generateFlags.intoArchives || entry.Type != "" || !lib.IsArchive(entry.Path) {
+		tree.AddFile(entry)
+		return nil
+	}
+
+	archive, err := lib.ArchiveDir(entry.Path)
+	if err != nil {
+		entry.Error = lib.ErrorKind(err) // the archive file itself is still recorded
+		tree.AddFile(entry)
+		return err
+	}
+
+	tree.AddFile(entry)
+	tree.AddDir(archive)
+	return nil
+}
+
+/*
+generateFile computes the entry for a single file in the output file, from the result
+of `os.Lstat` for the file. Special files are recorded with their type (and device
+numbers), without being read - symbolic links are never followed, and are recorded
+along with their target. Regular files are left to quickFile with --quick, and to
+regularEntry otherwise. Files that can't be read are returned with the error recorded
+in the entry
+*/
+func generateFile(path string, info fs.FileInfo) (writer.FileInfo, error) {
+	entry := writer.FileInfo{Path: path}
+	if lib.FileType(info.Mode()) == lib.TypeSymlink {
+		target, err := lib.LinkTarget(path)
+		if err != nil {
+			entry.Error = lib.ErrorKind(err)
+			return entry, err
+		}
+
+		entry.Type, entry.Target = lib.TypeSymlink, target
+		return entry, nil
+	}
+
+	switch {
+	case !info.Mode().IsRegular():
+		return readEntry(path)
+	case generateFlags.quick:
+		return quickFile(path, info)
+	}
+
+	return regularEntry(path, info)
+}
+
+/*
+regularEntry computes the entry for a regular file. With --from-xattr, the crc32 stored
+in the extended attributes of the file is used if the file wasn't modified since - see
+lib.TrustedXattrs. Otherwise, the file is read completely
+*/
+func regularEntry(path string, info fs.FileInfo) (writer.FileInfo, error) {
+	if !generateFlags.fromXattr || generateFlags.blockSize > 0 {
+		return readEntry(path)
+	}
+
+	sums, ok := lib.TrustedXattrs(path, info, []string{lib.AlgoCRC32})
+	if !ok {
+		return readEntry(path)
+	}
+
+	return writer.FileInfo{
+		Path:      path,
+		Checksums: writer.Checksums{CRC32: sums[lib.AlgoCRC32]},
+		Size:      info.Size(),
+		LastMod:   info.ModTime().Unix(),
+	}, nil
+}
+
+/*
+readEntry computes the entry for a file by reading it completely, special files are
+recorded with their type (and device numbers)
+*/
+func readEntry(path string) (writer.FileInfo, error) {
+	entry := writer.FileInfo{Path: path}
+	res, info, err := readFile(path, []string{lib.AlgoCRC32}, generateFlags.blockSize)
+	if lib.IsSpecialErr(err) {
+		res, err = specialResult(path)
+		if err == nil {
+			entry.Type, entry.Device = res.Type, res.Device
+			return entry, nil
+		}
+	}
+
+	if err != nil {
+		entry.Error, entry.Unstable = lib.ErrorKind(err), lib.IsUnstableErr(err)
+		entry.BadRanges = lib.UnreadableRanges(err)
+		return entry, err
+	}
+
+	entry.Checksums.CRC32 = res.Checksums[lib.AlgoCRC32]
+	entry.Size, entry.LastMod = info.Size(), info.ModTime().Unix()
+	entry.BlockSize, entry.Blocks = res.BlockSize, res.Blocks
+	return entry, nil
+}
+
+/*
+quickFile computes the entry for a regular file with --quick, along with its quick hash.
+The entry recorded for the file by an earlier run is reused, unless the file is new, or
+its size or quick hash changed since - see lib.NeedsFullHash
+*/
+func quickFile(path string, info fs.FileInfo) (writer.FileInfo, error) {
+	quick, err := readQuick(path, info.Size())
+	if err != nil {
+		return writer.FileInfo{Path: path, Error: lib.ErrorKind(err)}, err
+	}
+
+	prev, ok := previous[path]
+	if ok && prev.BlockSize == generateFlags.blockSize &&
+		!lib.NeedsFullHash(&prev, info.Size(), quick) {
+		return writer.FileInfo{
+			Path:      path,
+			Checksums: prev.Checksums,
+			Size:      info.Size(),
+			LastMod:   info.ModTime().Unix(),
+			BlockSize: prev.BlockSize,
+			Blocks:    prev.Blocks,
+		}, nil
+	}
+
+	entry, err := regularEntry(path, info)
+	if err == nil {
+		entry.Checksums.Quick = quick
+	}
+
+	return entry, err
+}
+
+/*
+readQuick computes the quick hash for the first `size` bytes of a file, see
+lib.QuickHash
+*/
+func readQuick(path string, size int64) (string, error) {
+	file, err := openPath(path, false)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = file.Close() }()
+	return lib.QuickHash(file, size, lib.DefaultQuickSize)
+}
+
+/*
+previousEntries returns entries from the output file of an earlier run mapped to their
+paths, with --quick. Every file is read completely if there is no such output file, or
+it can't be read
+*/
+func previousEntries(output string) map[string]writer.FileInfo {
+	if !generateFlags.quick || !pathExists(output) {
+		return nil
+	}
+
+	root, err := writer.ReadManifest(output)
+	if err != nil {
+		logger.Warnf("(%s/generate): ignoring existing output file: %v", pkgName, err)
+		return nil
+	}
+
+	entries := map[string]writer.FileInfo{}
+	for _, file := range root.AllFiles() {
+		entries[file.Path] = *file
+	}
+
+	return entries
+}
+
+/*
+captureMetadata records the metadata of a file in its entry with --metadata, see
+lib.CaptureMetadata. Files whose metadata can't be captured get the error recorded in
+the entry
+*/
+func captureMetadata(entry *writer.FileInfo, info fs.FileInfo) error {
+	if !generateFlags.metadata {
+		return nil
+	}
+
+	meta, err := lib.CaptureMetadata(entry.Path, info, generateFlags.metaXattrs)
+	if err != nil {
+		entry.Error = lib.ErrorKind(err)
+		return err
+	}
+
+	entry.Metadata = meta
+	return nil
+}
+
+/*
+recordDirs records every directory under the root in the tree, see lib.RecordDirs. With
+the continue policy, directories that can't be read are recorded with the error instead
+of failing the run - verify then skips reporting unexpected directories within them
+*/
+func recordDirs(tree *writer.DirInfo) error {
+	err := lib.RecordDirs(tree)
+	if err == nil || errPolicy == lib.OnErrorAbort {
+		return err
+	}
+
+	logger.Warnf("(%s/recordDirs): directories not recorded completely: %v", pkgName, err)
+	return nil
+}
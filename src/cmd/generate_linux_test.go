@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func TestGenerateCmd_Special(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	fifo := filepath.Join(dir, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o600))
+
+	// FIFOs are recorded without being opened, which would block forever
+	resetGenerate()
+	_, err := execute(t, "generate", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 2)
+
+	assert.Equal(t, fifo, root.Files[1].Path)
+	assert.Equal(t, lib.TypeFIFO, root.Files[1].Type)
+	assert.Empty(t, root.Files[1].Checksums.CRC32)
+
+	resetVerify()
+	out, err := execute(t, "verify", output)
+	require.NoError(t, err)
+	assert.Contains(t, out, "ok  "+fifo+"\n")
+
+	// Special files replaced by a different type are modified
+	require.NoError(t, os.Remove(fifo))
+	require.NoError(t, os.WriteFile(fifo, nil, 0o600))
+
+	resetVerify()
+	out, err = execute(t, "verify", output)
+	assert.Equal(t, ExitModified, ExitCode(err))
+	assert.Contains(t, out, "modified  "+fifo+"\n")
+}
+
+func TestGenerateCmd_SpecialArchive(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer resetHash()
+
+	dir := generateTree(t, "a.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	fifo := filepath.Join(dir, "x.zip")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o600))
+
+	// Special files named as archives are recorded without being expanded
+	resetGenerate()
+	_, err := execute(t, "generate", "--into-archives", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 2)
+	assert.Equal(t, lib.TypeFIFO, root.Files[1].Type)
+	assert.Empty(t, root.Dirs)
+
+	resetHash()
+	out, err := execute(t, "hash", "--into-archives", "-f", "json", fifo)
+	require.NoError(t, err)
+	assert.Contains(t, out, lib.TypeFIFO)
+}
@@ -0,0 +1,616 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func resetGenerate() {
+	openPath = lib.OpenFile
+	stableRead = lib.StableRead
+	errPolicy = lib.OnErrorAbort
+	readOpts = lib.ReadOptions{}
+
+	// Flags retain values across runs of the command, redefine them
+	generateCmd.ResetFlags()
+	setupGenerateFlags()
+}
+
+// generateTree creates a directory with files containing the standard check input,
+// returns the path to the directory
+func generateTree(t *testing.T, names ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("123456789"), 0o600))
+	}
+
+	return dir
+}
+
+// writeZip writes a zip archive with files mapped to their contents, a new file is
+// written on each call
+func writeZip(t *testing.T, path string, files map[string]string, method uint16) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+
+	archive := zip.NewWriter(file)
+	for name, contents := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, archive.Close())
+	require.NoError(t, file.Close())
+}
+
+func TestGenerateCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt", "sub/b.txt", "sub/deep/c.txt")
+	output := filepath.Join(dir, "checksums.json") // skipped by the walk
+
+	resetGenerate()
+	_, err := execute(t, "generate", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	assert.Equal(t, dir, root.Path)
+	assert.NotEmpty(t, root.Digest)
+
+	files := root.AllFiles()
+	require.Len(t, files, 3)
+	for _, file := range files {
+		assert.Equal(t, "cbf43926", file.Checksums.CRC32)
+		assert.Equal(t, int64(9), file.Size)
+	}
+
+	nested := root.Dirs[0].Dirs[0]
+	assert.Equal(t, filepath.Join(dir, "sub", "deep", "c.txt"), nested.Files[0].Path)
+
+	// The journal is removed once the output file is written
+	assert.NoFileExists(t, writer.JournalPath(output))
+
+	resetGenerate()
+	_, err = execute(t, "generate", dir)
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestGenerateCmd_Parallel(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer func(threshold int64) { lib.ParallelThreshold = threshold }(
+		lib.ParallelThreshold,
+	)
+
+	defer func() { numCPU = runtime.NumCPU }()
+
+	dir := generateTree(t, "a.txt", "sub/b.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	// Files above the threshold should be hashed on each core
+	calls := 0
+	numCPU = func() int { calls++; return 4 }
+	lib.ParallelThreshold = 1
+
+	resetGenerate()
+	_, err := execute(t, "generate", dir, output)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	for _, file := range root.AllFiles() {
+		assert.Equal(t, "cbf43926", file.Checksums.CRC32)
+	}
+
+	calls = 0
+
+	resetVerify()
+	_, err = execute(t, "verify", output)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestGenerateCmd_Strategy(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer resetVerify()
+
+	dir := generateTree(t, "a.txt", "sub/b.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	// Commands reading files should take the read strategy as well
+	for _, strategy := range []string{"auto", "buffered", "mmap", "direct"} {
+		resetGenerate()
+		_, err := execute(
+			t, "generate", "--strategy", strategy, "--buffer-size", "2", dir, output,
+		)
+		require.NoErrorf(t, err, "failed for %s", strategy)
+
+		for _, args := range [][]string{
+			{"verify", output}, {"scrub", "--budget", "1h", output},
+		} {
+			resetVerify()
+			args = append(args, "--strategy", strategy, "--buffer-size", "2")
+
+			_, err = execute(t, args...)
+			require.NoErrorf(t, err, "failed for %s %s", args[0], strategy)
+		}
+	}
+
+	resetGenerate()
+	_, err := execute(t, "generate", "--strategy", "aio", dir, output)
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestGenerateCmd_Quick(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt", "b.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetGenerate()
+	_, err := execute(t, "generate", "--quick", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	files := root.AllFiles()
+	require.Len(t, files, 2)
+	for _, file := range files {
+		assert.Equal(t, "cbf43926", file.Checksums.CRC32)
+		assert.Len(t, file.Checksums.Quick, 16)
+
+		// Reused checksums are recognized by a bogus value
+		file.Checksums.CRC32 = "00000000"
+	}
+
+	require.NoError(t, writer.WriteManifest(output, &root))
+
+	// Only files whose quick hash changed should be read again
+	changed := filepath.Join(dir, "b.txt")
+	require.NoError(t, os.WriteFile(changed, []byte("987654321"), 0o600))
+
+	resetGenerate()
+	_, err = execute(t, "generate", "--quick", dir, output)
+	require.NoError(t, err)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	files = root.AllFiles()
+	require.Len(t, files, 2)
+	assert.Equal(t, "00000000", files[0].Checksums.CRC32)
+	assert.NotEqual(t, "00000000", files[1].Checksums.CRC32)
+	assert.NotEqual(t, "cbf43926", files[1].Checksums.CRC32)
+	assert.NotEqual(t, files[0].Checksums.Quick, files[1].Checksums.Quick)
+
+	// Without --quick, every file is read again
+	resetGenerate()
+	_, err = execute(t, "generate", dir, output)
+	require.NoError(t, err)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+	assert.Equal(t, "cbf43926", root.AllFiles()[0].Checksums.CRC32)
+	assert.Empty(t, root.AllFiles()[0].Checksums.Quick)
+}
+
+func TestGenerateCmd_Resume(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt", "b.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	// A stopped run left the first file in the journal, with a checksum that would
+	// never be computed - proving the file is not read again
+	info, err := os.Stat(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+
+	journal, err := writer.OpenJournal(writer.JournalPath(output), 0)
+	require.NoError(t, err)
+	require.NoError(t, journal.Add(&writer.FileInfo{
+		Path: filepath.Join(dir, "a.txt"), Checksums: writer.Checksums{CRC32: "journal"},
+		Size: info.Size(), LastMod: info.ModTime().Unix(),
+	}))
+	require.NoError(t, journal.Close())
+
+	resetGenerate()
+	_, err = execute(t, "generate", "--resume", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 2)
+	assert.Equal(t, "journal", root.Files[0].Checksums.CRC32)
+	assert.Equal(t, "cbf43926", root.Files[1].Checksums.CRC32)
+
+	// Without the flag, files are read again
+	require.NoError(t, os.WriteFile(writer.JournalPath(output), []byte("{}\n"), 0o600))
+
+	resetGenerate()
+	_, err = execute(t, "generate", dir, output)
+	require.NoError(t, err)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+	assert.Equal(t, "cbf43926", root.Files[0].Checksums.CRC32)
+}
+
+func TestGenerateCmd_Interrupted(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt", "b.txt", "c.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	// Interrupted while the second file is being read, which still completes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resetGenerate()
+	opened := 0
+	openPath = func(path string, devices bool) (*os.File, error) {
+		if opened++; opened == 2 {
+			cancel()
+		}
+
+		return lib.OpenFile(path, devices)
+	}
+
+	// Subcommands keep the context of their first run, run afresh
+	cmd := &cobra.Command{RunE: runGenerate, SilenceErrors: true, SilenceUsage: true}
+	cmd.SetArgs([]string{dir, output})
+
+	err := cmd.ExecuteContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	assert.True(t, root.Partial)
+	assert.Len(t, root.Files, 2)
+
+	// The journal is kept to resume the run
+	entries, err := writer.ReadJournal(writer.JournalPath(output))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	resetGenerate()
+	_, err = execute(t, "generate", "--resume", dir, output)
+	require.NoError(t, err)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+	assert.False(t, root.Partial)
+	assert.Len(t, root.Files, 3)
+}
+
+func TestGenerateCmd_OnError(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt", "b.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+	denied := filepath.Join(dir, "b.txt")
+
+	mockOpen := func() {
+		openPath = func(path string, devices bool) (*os.File, error) {
+			if path == denied {
+				return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrPermission}
+			}
+
+			return lib.OpenFile(path, devices)
+		}
+	}
+
+	// Abort on the first file that can't be read, without an output file
+	resetGenerate()
+	mockOpen()
+	_, err := execute(t, "generate", dir, output)
+	assert.Error(t, err)
+	assert.NotEqual(t, ExitPartial, ExitCode(err))
+	assert.NoFileExists(t, output)
+
+	// Failed files are recorded in the output file, and listed in the summary
+	resetGenerate()
+	mockOpen()
+	out, err := execute(t, "generate", "--on-error", "continue", dir, output)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+	assert.Equal(t, "error  "+denied+"  (permission denied)\n", out)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 2)
+
+	failed := root.Failed()
+	require.Len(t, failed, 1)
+	assert.Equal(t, denied, failed[0].Path)
+	assert.Equal(t, "permission denied", failed[0].Error)
+
+	// Failed files are never journaled, a resumed run reads them again
+	entries, err := writer.ReadJournal(writer.JournalPath(output))
+	require.NoError(t, err)
+	assert.NotContains(t, entries, denied)
+
+	// Files that keep changing while being read are marked as unstable
+	resetGenerate()
+	modTime := time.Unix(1_600_000_000, 0)
+	stableRead = func(
+		file *os.File, retries int, read func(os.FileInfo) error,
+	) (os.FileInfo, error) {
+		return lib.StableRead(file, retries, func(info os.FileInfo) error {
+			if file.Name() == denied {
+				modTime = modTime.Add(time.Second)
+				require.NoError(t, os.Chtimes(denied, modTime, modTime))
+			}
+
+			return read(info)
+		})
+	}
+
+	out, err = execute(t, "generate", "--on-error", "continue", dir, output)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+	assert.Equal(t, "error  "+denied+"  (changed while being read)\n", out)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	failed = root.Failed()
+	require.Len(t, failed, 1)
+	assert.True(t, failed[0].Unstable)
+	assert.Empty(t, failed[0].Checksums.CRC32)
+
+	// Byte ranges that can't be read are recorded along with the error
+	resetGenerate()
+	ranges := []writer.ByteRange{{Start: 4096, End: 8192}}
+	stableRead = func(
+		file *os.File, retries int, read func(os.FileInfo) error,
+	) (os.FileInfo, error) {
+		if file.Name() == denied {
+			return nil, &lib.UnreadableError{Ranges: ranges, Err: syscall.EIO}
+		}
+
+		return lib.StableRead(file, retries, read)
+	}
+
+	out, err = execute(t, "generate", "--on-error", "continue", dir, output)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+	assert.Equal(t, "error  "+denied+"  (I/O error, unreadable bytes 4096-8192)\n", out)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	failed = root.Failed()
+	require.Len(t, failed, 1)
+	assert.Equal(t, ranges, failed[0].BadRanges)
+	assert.False(t, failed[0].Unstable)
+}
+
+func TestGenerateCmd_Metadata(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt", "b.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetGenerate()
+	_, err := execute(t, "generate", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	assert.Nil(t, root.Files[0].Metadata, "metadata is only recorded with the flag")
+
+	resetGenerate()
+	_, err = execute(t, "generate", "--metadata", dir, output)
+	require.NoError(t, err)
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 2)
+
+	for _, file := range root.Files {
+		require.NotNilf(t, file.Metadata, `failed for "%s"`, file.Path)
+		assert.Equal(t, os.FileMode(0o600), file.Metadata.Mode)
+	}
+
+	// Recorded metadata is compared by verify
+	require.NoError(t, os.Chmod(root.Files[0].Path, 0o644))
+
+	resetVerify()
+	out, err := execute(t, "verify", "--metadata", output)
+	assert.Equal(t, ExitDrift, ExitCode(err))
+	assert.Contains(
+		t, out, "drift  "+root.Files[0].Path+"  (mode -rw------- -> -rw-r--r--)\n",
+	)
+}
+
+func TestGenerateCmd_Dirs(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "spool", "in"), 0o700))
+
+	resetGenerate()
+	_, err := execute(t, "generate", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	dirs := root.AllDirs()
+	require.Len(t, dirs, 3)
+	for _, recorded := range dirs {
+		assert.NotNilf(t, recorded.Mode, `failed for "%s"`, recorded.Path)
+		assert.NotZerof(t, recorded.DirMod, `failed for "%s"`, recorded.Path)
+	}
+
+	assert.Equal(t, filepath.Join(dir, "spool", "in"), dirs[2].Path)
+	assert.Equal(t, os.FileMode(0o700), *dirs[2].Mode)
+
+	// Directories that can't be read are recorded with the error with the continue
+	// policy, leaving the rest as is
+	if os.Geteuid() == 0 {
+		return // permissions are not enforced for root
+	}
+
+	locked := filepath.Join(dir, "spool")
+	require.NoError(t, os.Chmod(locked, 0))
+	defer func() { _ = os.Chmod(locked, 0o700) }()
+
+	resetGenerate()
+	_, err = execute(t, "generate", "--on-error", "continue", dir, output)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+
+	root, err = writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.NotNil(t, root.Mode)
+
+	for _, recorded := range root.AllDirs() {
+		if recorded.Path == locked {
+			assert.NotEmpty(t, recorded.Error)
+		} else {
+			assert.Emptyf(t, recorded.Error, `failed for "%s"`, recorded.Path)
+		}
+	}
+}
+
+func TestGenerateCmd_Symlinks(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir := generateTree(t, "a.txt")
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "data"), 0o755))
+	require.NoError(t, os.Symlink("data", filepath.Join(dir, "dir-link")))
+	require.NoError(t, os.Symlink("missing", filepath.Join(dir, "dangling")))
+
+	// Symlinks are recorded with their target, without being followed - even with the
+	// abort policy, links to directories or missing files are not errors
+	resetGenerate()
+	_, err := execute(t, "generate", dir, output)
+	require.NoError(t, err)
+	assert.NoFileExists(t, writer.JournalPath(output))
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+
+	links := map[string]string{}
+	for _, file := range root.AllFiles() {
+		if file.Type == lib.TypeSymlink {
+			assert.Empty(t, file.Checksums.CRC32)
+			links[filepath.Base(file.Path)] = file.Target
+		}
+	}
+
+	assert.Equal(t, map[string]string{"dir-link": "data", "dangling": "missing"}, links)
+
+	resetVerify()
+	_, err = execute(t, "verify", output)
+	require.NoError(t, err)
+
+	// Metadata of links is that of the link itself, on both sides
+	resetGenerate()
+	_, err = execute(t, "generate", "--metadata", dir, output)
+	require.NoError(t, err)
+
+	resetVerify()
+	_, err = execute(t, "verify", "--metadata", output)
+	require.NoError(t, err)
+
+	// Links pointing elsewhere are modified
+	require.NoError(t, os.Remove(filepath.Join(dir, "dangling")))
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(dir, "dangling")))
+
+	resetVerify()
+	out, err := execute(t, "verify", output)
+	assert.Equal(t, ExitModified, ExitCode(err))
+	assert.Contains(t, out, "modified  "+filepath.Join(dir, "dangling")+"\n")
+}
+
+func TestGenerateCmd_IntoArchives(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer resetVerify()
+
+	dir := generateTree(t, "a.txt")
+	path := filepath.Join(dir, "test.zip")
+	writeZip(t, path, map[string]string{"dir/check.txt": "123456789"}, zip.Deflate)
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetGenerate()
+	_, err := execute(t, "generate", "--into-archives", dir, output)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(output)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 2) // the archive file is recorded too
+
+	archives := root.Archives()
+	require.Len(t, archives, 1)
+	assert.Equal(t, path, archives[0].Path)
+
+	files := archives[0].AllFiles()
+	require.Len(t, files, 1)
+	assert.Equal(t, filepath.Join(path, "dir", "check.txt"), files[0].Path)
+	assert.Equal(t, "cbf43926", files[0].Checksums.CRC32)
+
+	// Files within a repacked archive still match, even though the archive does not
+	resetVerify()
+	modified := time.Now().Add(time.Hour)
+	writeZip(t, path, map[string]string{"dir/check.txt": "123456789"}, zip.Store)
+	require.NoError(t, os.Chtimes(path, modified, modified))
+
+	out, err := execute(t, "verify", output)
+	assert.Equal(t, ExitModified, ExitCode(err))
+	assert.Contains(t, out, "modified  "+path+"\n")
+	assert.Contains(t, out, "ok  "+files[0].Path+"\n")
+
+	resetVerify()
+	writeZip(t, path, map[string]string{"dir/check.txt": "987654321"}, zip.Store)
+	out, err = execute(t, "verify", output)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Contains(t, out, "mismatch  "+files[0].Path+"\n")
+}
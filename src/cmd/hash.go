@@ -0,0 +1,739 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// stdinPath is the path used to read data from stdin
+const stdinPath = "-"
+
+/*
+Output formats supported by the `hash` command
+*/
+const (
+	formatPlain = "plain" // `crc32:cbf43926 sha1:...  path`
+	formatSFV   = "sfv"   // `path CBF43926` - limited to crc32
+	formatSum   = "sum"   // `cbf43926  path` - as in `sha256sum`, single algorithm
+	formatJSON  = "json"  // one JSON object per line
+)
+
+var (
+	openPath   = lib.OpenFile   // maps to lib.OpenFile
+	numCPU     = runtime.NumCPU // maps to runtime.NumCPU
+	stableRead = lib.StableRead // maps to lib.StableRead
+)
+
+// readOpts decides how files are read while computing checksums
+var readOpts lib.ReadOptions
+
+// hashFlags contains values for flags of the `hash` command
+var hashFlags = struct {
+	algos        []string
+	format       string
+	intoArchives bool
+	blockSize    int64
+	quick        bool
+	quickSize    int64
+	cache        bool
+	fromXattr    bool
+	retries      int
+	metadata     bool
+	metaXattrs   []string
+	devices      bool
+}{}
+
+// hashCache contains checksums cached across runs, nil unless enabled through a flag
+var hashCache *lib.Cache
+
+var hashCmd = &cobra.Command{
+	Use:   "hash [files...]",
+	Short: "Print checksums for files, or stdin",
+	Long: `
+Print checksums for individual files. Use "-" as the path to read from stdin, which is
+also the default when no path is passed
+
+Special files - FIFOs, sockets, and devices - are never read, their type (along with
+device numbers) is printed with the json format, and they are skipped otherwise. Use
+--hash-devices to read block devices as a whole, e.g. to hash a disk image
+`,
+	Example: `  crcgen hash --algo crc32c,sha256 file.iso
+  curl -sL https://example.com/file.zip | crcgen hash --algo crc32c -`,
+	Args: checkArgs(cobra.ArbitraryArgs),
+	RunE: runHash,
+}
+
+func init() {
+	setupHashFlags()
+	Root.AddCommand(hashCmd)
+}
+
+/*
+setupHashFlags defines flags for the `hash` command
+*/
+func setupHashFlags() {
+	hashCmd.Flags().StringSliceVarP(
+		&hashFlags.algos, "algo", "a", []string{lib.AlgoCRC32},
+		"checksum algorithms: "+strings.Join(lib.Algorithms(), ", "),
+	)
+
+	hashCmd.Flags().StringVarP(
+		&hashFlags.format, "format", "f", formatPlain,
+		"output format: plain, sfv, sum, json",
+	)
+
+	hashCmd.Flags().BoolVar(
+		&hashFlags.intoArchives, "into-archives", false,
+		"also hash files inside zip, tar, and tar.gz archives",
+	)
+
+	hashCmd.Flags().Int64Var(
+		&hashFlags.blockSize, "block-size", 0,
+		"also compute crc32 for each block of this size (in bytes), json format only",
+	)
+
+	setupReadFlags(hashCmd)
+
+	hashCmd.Flags().BoolVar(
+		&hashFlags.cache, "cache", false,
+		"reuse checksums of unchanged files from the cache, and cache new checksums",
+	)
+
+	hashCmd.Flags().BoolVar(
+		&hashFlags.fromXattr, "from-xattr", false,
+		"trust checksums stored in extended attributes, if files weren't modified since",
+	)
+
+	hashCmd.Flags().BoolVar(
+		&hashFlags.metadata, "metadata", false,
+		"also capture permissions, ownership, and extended attributes, json format only",
+	)
+
+	hashCmd.Flags().StringSliceVar(
+		&hashFlags.metaXattrs, "metadata-xattrs", lib.ACLXattrs,
+		"extended attributes captured with --metadata",
+	)
+
+	hashCmd.Flags().BoolVar(
+		&hashFlags.devices, "hash-devices", false,
+		"read, and hash the contents of block devices",
+	)
+
+	hashCmd.Flags().IntVar(
+		&hashFlags.retries, "retries", lib.DefaultRetries,
+		"times to read a file again if it changes while being read",
+	)
+
+	hashCmd.Flags().BoolVar(
+		&hashFlags.quick, "quick", false,
+		"only hash the size, and the first, middle and last parts of each file",
+	)
+
+	hashCmd.Flags().Int64Var(
+		&hashFlags.quickSize, "quick-size", lib.DefaultQuickSize>>10,
+		"size of each part hashed with --quick, in KiB",
+	)
+}
+
+/*
+completeAlgo completes names of checksum algorithms
+*/
+func completeAlgo(
+	*cobra.Command, []string, string,
+) ([]string, cobra.ShellCompDirective) {
+	return lib.Algorithms(), cobra.ShellCompDirectiveNoFileComp
+}
+
+/*
+completeFormat completes output formats for the `hash` command
+*/
+func completeFormat(
+	*cobra.Command, []string, string,
+) ([]string, cobra.ShellCompDirective) {
+	formats := []string{formatPlain, formatSFV, formatSum, formatJSON}
+	return formats, cobra.ShellCompDirectiveNoFileComp
+}
+
+/*
+hashResult contains checksums computed for a single path
+*/
+type hashResult struct {
+	Path      string
+	Size      int64
+	Checksums map[string]string
+
+	// Blocks contains block checksums, computed only when a block size is set
+	BlockSize int64    `json:",omitempty"`
+	Blocks    []string `json:",omitempty"`
+
+	// Metadata contains permissions, ownership, and extended attributes of the file,
+	// captured only when enabled
+	Metadata *writer.Metadata `json:",omitempty"`
+
+	// Type, and Device describe special files - see writer.FileInfo. Checksums are only
+	// computed for block devices, and only when enabled
+	Type   string         `json:",omitempty"`
+	Device *writer.Device `json:",omitempty"`
+}
+
+/*
+setupReadFlags defines flags deciding how files are read, for commands that read files
+*/
+func setupReadFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(
+		&readOpts.Strategy, "strategy",
+		"how files are read: buffered, mmap, direct, auto (by size)",
+	)
+
+	cmd.Flags().IntVar(
+		&readOpts.BufferSize, "buffer-size", lib.DefaultBufferSize,
+		"size of buffers used to read files, in bytes",
+	)
+}
+
+func runHash(cmd *cobra.Command, args []string) error {
+	if err := validateFormat(hashFlags.format, hashFlags.algos); err != nil {
+		return err
+	}
+
+	if _, err := lib.NewHashes(hashFlags.algos); err != nil {
+		return errors.Wrapf(errInvalidArgs, "%v", err)
+	}
+
+	if len(args) == 0 {
+		args = []string{stdinPath}
+	}
+
+	if err := validateQuick(cmd, args); err != nil {
+		return err
+	}
+
+	if err := validateBlockSize(hashFlags.blockSize, hashFlags.format); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true // arguments are valid, failures beyond are not usage errors
+
+	if hashFlags.cache {
+		closeCache := openCache()
+		defer closeCache()
+	}
+
+	failed := 0
+	for _, path := range args {
+		if cmd.Context().Err() != nil {
+			break // interrupted, stop processing new files
+		}
+
+		res, err := hashPath(cmd.InOrStdin(), path)
+		if err == nil {
+			err = printResult(cmd.OutOrStdout(), res)
+		}
+
+		// Special files are never expanded, whatever their name
+		if err == nil && hashFlags.intoArchives && res.Type == "" && lib.IsArchive(path) {
+			err = hashArchive(cmd.OutOrStdout(), path)
+		}
+
+		if err == nil {
+			continue
+		} else if errPolicy == lib.OnErrorAbort {
+			return errors.Wrapf(err, "(%s/hash)", pkgName)
+		}
+
+		failed++
+		logger.Errorf(`(%s/hash): "%s": %s`, pkgName, path, lib.ErrorKind(err))
+	}
+
+	if failed > 0 {
+		return errors.Wrapf(errPartial, "(%s/hash): %d failed", pkgName, failed)
+	}
+
+	return nil
+}
+
+/*
+validateFormat checks if the output format is known, and if it can represent the
+selected algorithms
+*/
+func validateFormat(format string, algos []string) error {
+	switch format {
+	case formatPlain, formatJSON:
+		return nil
+
+	case formatSFV:
+		if len(algos) == 1 && strings.EqualFold(algos[0], lib.AlgoCRC32) {
+			return nil
+		}
+
+		return errors.Wrapf(errInvalidArgs, "format %s only supports crc32", format)
+
+	case formatSum:
+		if len(algos) == 1 {
+			return nil
+		}
+
+		return errors.Wrapf(errInvalidArgs, "format %s needs a single algorithm", format)
+	}
+
+	return errors.Wrapf(errInvalidArgs, "unknown output format: %s", format)
+}
+
+/*
+validateBlockSize checks if block checksums can be computed with the block size, and
+represented in the output format. A block size of zero disables block checksums
+*/
+func validateBlockSize(blockSize int64, format string) error {
+	switch {
+	case blockSize < 0:
+		return errors.Wrapf(errInvalidArgs, "invalid block size: %d", blockSize)
+	case blockSize > 0 && format != formatJSON:
+		return errors.Wrapf(errInvalidArgs, "--block-size needs json format")
+	}
+
+	return nil
+}
+
+/*
+validateQuick checks if the flags, and paths can be used to compute quick hashes. Quick
+hashes need random access to files, ruling out stdin and files within archives. Quick
+hashes replace checksums - they can't be combined with --algo, or written in formats
+meant for checksums of a single algorithm
+*/
+func validateQuick(cmd *cobra.Command, paths []string) error {
+	switch {
+	case !hashFlags.quick:
+		return nil
+	case hashFlags.format == formatSFV || hashFlags.format == formatSum:
+		return errors.Wrapf(
+			errInvalidArgs, "--quick can't be used with %s format", hashFlags.format,
+		)
+	case cmd.Flags().Changed("algo"):
+		return errors.Wrap(errInvalidArgs, "--quick can't be used with --algo")
+	case hashFlags.intoArchives:
+		return errors.Wrap(errInvalidArgs, "--quick can't be used with --into-archives")
+	case hashFlags.blockSize > 0:
+		return errors.Wrap(errInvalidArgs, "--quick can't be used with --block-size")
+	case hashFlags.quickSize < 1:
+		return errors.Wrap(errInvalidArgs, "--quick-size must be positive")
+	}
+
+	for _, path := range paths {
+		if path == stdinPath {
+			return errors.Wrap(errInvalidArgs, "--quick can't read from stdin")
+		}
+	}
+
+	return nil
+}
+
+/*
+quickHash computes the quick hash for a file, in place of its checksums
+*/
+func quickHash(path string, file *os.File) (*hashResult, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s/quickHash)", pkgName)
+	}
+
+	quick, err := lib.QuickHash(file, info.Size(), hashFlags.quickSize<<10)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hashResult{
+		Path:      path,
+		Size:      info.Size(),
+		Checksums: map[string]string{lib.AlgoQuick: quick},
+	}, nil
+}
+
+/*
+hashPath computes checksums for a path, reading from stdin if the path is stdinPath.
+Special files are described without being read, other than block devices with
+--hash-devices
+*/
+func hashPath(stdin io.Reader, path string) (*hashResult, error) {
+	if path == stdinPath {
+		return hashReader(path, stdin)
+	}
+
+	file, err := openPath(path, hashFlags.devices)
+	if lib.IsSpecialErr(err) {
+		return specialResult(path)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "(%s/hashPath)", pkgName)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s/hashPath)", pkgName)
+	}
+
+	switch {
+	case info.Mode().IsRegular() && hashFlags.quick:
+		return quickHash(path, file)
+
+	case info.Mode().IsRegular():
+		return hashRegular(path, file)
+	}
+
+	res, err := hashReader(path, file)
+	if err == nil {
+		res.Type, res.Device = lib.DescribeSpecial(info)
+	}
+
+	return res, err
+}
+
+/*
+specialResult describes a special file, without reading it
+*/
+func specialResult(path string) (*hashResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s/specialResult)", pkgName)
+	}
+
+	res := &hashResult{Path: path}
+	res.Type, res.Device = lib.DescribeSpecial(info)
+	return res, nil
+}
+
+/*
+hashRegular computes checksums for a regular file. Files changing while being read are
+read again, up to the number of retries - see lib.StableRead. Reads failing with I/O
+errors are retried too, failing with the unreadable byte ranges - see lib.RetryIO
+*/
+func hashRegular(path string, file *os.File) (*hashResult, error) {
+	var (
+		res      *hashResult
+		computed bool // checksums were computed, and not taken from a cache
+	)
+
+	info, err := stableRead(file, hashFlags.retries, func(info os.FileInfo) error {
+		return lib.RetryIO(file, info.Size(), lib.DefaultIORetries, func() (err error) {
+			res = &hashResult{Path: path, Size: info.Size()}
+			if hashFlags.blockSize > 0 {
+				res.BlockSize = hashFlags.blockSize
+				res.Checksums, res.Blocks, err = lib.BlockChecksums(
+					file, info.Size(), hashFlags.algos, hashFlags.blockSize,
+				)
+
+				return err
+			}
+
+			res.Checksums, computed, err = cachedChecksums(file, info)
+			return err
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hashFlags.metadata {
+		res.Metadata, err = lib.CaptureMetadata(path, info, hashFlags.metaXattrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only cached once the file is known to be unchanged, torn reads are never cached
+	if computed {
+		cachePut(path, info, res.Checksums)
+	}
+
+	return res, nil
+}
+
+/*
+readChecksums computes checksums for a file with the algorithms, see readFile
+*/
+func readChecksums(path string, algos []string) (
+	map[string]string, fs.FileInfo, error,
+) {
+	res, info, err := readFile(path, algos, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res.Checksums, info, nil
+}
+
+/*
+readFile computes checksums for a file with the algorithms, along with block checksums
+if `blockSize` is set - see lib.BlockChecksums. The file is read again if it changes
+while being read, and reads failing with I/O errors are retried. Returns the file info
+for the version of the file the checksums belong to
+
+Without block checksums, checksums are taken from hashCache if enabled - checksums that
+are computed are added to it. Large files are hashed in parallel, see checksumFile
+*/
+func readFile(path string, algos []string, blockSize int64) (
+	*hashResult, fs.FileInfo, error,
+) {
+	file, err := openPath(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	if blockSize <= 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if sums, ok := cacheGet(path, info, algos); ok {
+			return &hashResult{Path: path, Size: info.Size(), Checksums: sums}, info, nil
+		}
+	}
+
+	res := &hashResult{Path: path}
+	info, err := stableRead(file, lib.DefaultRetries, func(info fs.FileInfo) error {
+		res.Size = info.Size()
+		return lib.RetryIO(file, info.Size(), lib.DefaultIORetries, func() (err error) {
+			if blockSize > 0 {
+				res.BlockSize = blockSize
+				res.Checksums, res.Blocks, err = lib.BlockChecksums(
+					file, info.Size(), algos, blockSize,
+				)
+
+				return err
+			}
+
+			res.Checksums, err = checksumFile(file, info.Size(), algos)
+			return err
+		})
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cachePut(path, info, res.Checksums)
+	return res, info, nil
+}
+
+/*
+hashReader computes checksums for data read from the reader, along with block
+checksums if a block size is set
+*/
+func hashReader(path string, reader io.Reader) (*hashResult, error) {
+	if hashFlags.blockSize <= 0 {
+		sums, size, err := lib.Checksum(reader, hashFlags.algos)
+		if err != nil {
+			return nil, err
+		}
+
+		return &hashResult{Path: path, Size: size, Checksums: sums}, nil
+	}
+
+	blocks := lib.NewBlockHash(hashFlags.blockSize)
+	sums, size, err := lib.Checksum(reader, hashFlags.algos, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hashResult{
+		Path:      path,
+		Size:      size,
+		Checksums: sums,
+		BlockSize: blocks.Size(),
+		Blocks:    blocks.Sums(),
+	}, nil
+}
+
+/*
+openCache loads the cache into hashCache, returns a function that prunes stale entries
+for files looked up in this run (see lib.Cache.Prune), saves changes to the cache, and
+disables it again. Failing to load, or save the cache is not an error - the cache is
+only an optimization
+*/
+func openCache() func() {
+	path, err := lib.CachePath()
+	if err != nil {
+		logger.Warnf("(%s/openCache): cache disabled: %v", pkgName, err)
+		return func() {}
+	}
+
+	hashCache = lib.OpenCache(path)
+	return func() {
+		hashCache.Prune()
+		if err := hashCache.Save(); err != nil {
+			logger.Warnf("(%s/openCache): failed to save cache: %v", pkgName, err)
+		}
+
+		hashCache = nil
+	}
+}
+
+/*
+cachedChecksums returns checksums for a regular file from its extended attributes, or
+from hashCache if enabled. The file is only read if a checksum is missing from both,
+in which case the returned flag is set
+*/
+func cachedChecksums(file *os.File, info os.FileInfo) (map[string]string, bool, error) {
+	if hashFlags.fromXattr {
+		if sums, ok := lib.TrustedXattrs(file.Name(), info, hashFlags.algos); ok {
+			return sums, false, nil
+		}
+	}
+
+	if sums, ok := cacheGet(file.Name(), info, hashFlags.algos); ok {
+		return sums, false, nil
+	}
+
+	sums, err := checksumFile(file, info.Size(), hashFlags.algos)
+	return sums, err == nil, err
+}
+
+/*
+cacheGet returns checksums cached for the file at the path with each algorithm, from
+hashCache if enabled. Returns false unless a checksum is cached for every algorithm
+*/
+func cacheGet(
+	path string, info fs.FileInfo, algos []string,
+) (map[string]string, bool) {
+	if hashCache == nil {
+		return nil, false
+	}
+
+	hashCache.Touch(path, info)
+	key, ok := lib.FileKey(info)
+	if !ok {
+		return nil, false
+	}
+
+	sums := make(map[string]string, len(algos))
+	for _, algo := range algos {
+		algo = strings.ToLower(strings.TrimSpace(algo))
+		if sums[algo], ok = hashCache.Get(key, algo); !ok {
+			return nil, false
+		}
+	}
+
+	return sums, true
+}
+
+/*
+cachePut adds checksums computed for the file at the path to hashCache, if enabled
+*/
+func cachePut(path string, info fs.FileInfo, sums map[string]string) {
+	key, ok := lib.FileKey(info)
+	if hashCache == nil || !ok {
+		return
+	}
+
+	for algo, sum := range sums {
+		hashCache.Put(path, key, algo, sum)
+	}
+}
+
+/*
+checksumFile computes checksums for a regular file. Large files are hashed on all cores
+where checksums can be combined, others are read with the selected read strategy
+*/
+func checksumFile(
+	file *os.File, size int64, algos []string,
+) (map[string]string, error) {
+	if size >= lib.ParallelThreshold && lib.Combinable(algos) {
+		return lib.ChecksumAt(file, size, algos, numCPU())
+	}
+
+	sums, _, err := lib.ChecksumFile(file, size, algos, readOpts)
+	return sums, err
+}
+
+/*
+verifyOptions returns the options to read files being verified with, hashing large
+files on all cores - see lib.VerifyFile
+*/
+func verifyOptions() lib.ReadOptions {
+	opts := readOpts
+	opts.Workers = numCPU()
+
+	return opts
+}
+
+/*
+hashArchive prints checksums for each file inside an archive, files are represented as
+being nested under the path to the archive
+*/
+func hashArchive(out io.Writer, path string) error {
+	return lib.WalkArchive(path, hashFlags.algos, func(entry *lib.ArchiveEntry) error {
+		return printResult(out, &hashResult{
+			Path:      filepath.Join(path, filepath.FromSlash(entry.Name)),
+			Size:      entry.Size,
+			Checksums: entry.Checksums,
+		})
+	})
+}
+
+/*
+printResult writes the checksums for a path in the selected output format
+*/
+func printResult(out io.Writer, res *hashResult) error {
+	var err error
+
+	// Only json can describe special files without checksums
+	if res.Checksums == nil && hashFlags.format != formatJSON {
+		logger.Infof(`(%s/hash): skipping %s "%s"`, pkgName, res.Type, res.Path)
+		return nil
+	}
+
+	switch hashFlags.format {
+	case formatSFV:
+		_, err = fmt.Fprintf(out, "%s %s\n", res.Path, strings.ToUpper(onlySum(res)))
+
+	case formatSum:
+		_, err = fmt.Fprintf(out, "%s  %s\n", onlySum(res), res.Path)
+
+	case formatJSON:
+		err = json.NewEncoder(out).Encode(res)
+
+	default:
+		algos := make([]string, 0, len(res.Checksums))
+		for algo := range res.Checksums {
+			algos = append(algos, algo)
+		}
+
+		sort.Strings(algos)
+		for i, algo := range algos {
+			algos[i] = algo + ":" + res.Checksums[algo]
+		}
+
+		_, err = fmt.Fprintf(out, "%s  %s\n", strings.Join(algos, " "), res.Path)
+	}
+
+	return errors.Wrapf(err, "(%s/printResult)", pkgName)
+}
+
+/*
+onlySum returns the checksum for a result with a single algorithm
+*/
+func onlySum(res *hashResult) string {
+	for _, sum := range res.Checksums {
+		return sum
+	}
+
+	return ""
+}
@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func resetHash() {
+	openPath = lib.OpenFile
+	numCPU = runtime.NumCPU
+	stableRead = lib.StableRead
+	errPolicy = lib.OnErrorAbort
+	readOpts = lib.ReadOptions{}
+
+	// Flags retain values across runs of the command, redefine them
+	hashCmd.ResetFlags()
+	setupHashFlags()
+}
+
+// hashArgs forms arguments to run the `hash` command with
+func hashArgs(flags string, paths ...string) []string {
+	return append(append([]string{"hash"}, strings.Fields(flags)...), paths...)
+}
+
+// hashFile creates a temporary file with the standard check input
+func hashFile(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte("123456789"), 0o600))
+
+	return path
+}
+
+func TestHashCmd_Formats(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := hashFile(t)
+	for args, expected := range map[string]string{
+		"":                        "crc32:cbf43926  " + path,
+		"-a crc32c,crc64-ecma":    "crc32c:e3069283 crc64-ecma:995dc9bbdf1939fa  " + path,
+		"-f sfv":                  path + " CBF43926",
+		"-f sum -a crc64-iso":     "b90956c775a41001  " + path,
+		"--format=sum --algo=md5": "25f9e794323b453885f5181f1b624d0b  " + path,
+	} {
+		resetHash()
+
+		out, err := execute(t, hashArgs(args, path)...)
+		assert.NoErrorf(t, err, `failed for "%s"`, args)
+		assert.Equalf(t, expected+"\n", out, `failed for "%s"`, args)
+	}
+
+	resetHash()
+	out, err := execute(t, "hash", "-f", "json", path)
+	require.NoError(t, err)
+
+	var res hashResult
+	require.NoError(t, json.Unmarshal([]byte(out), &res))
+	assert.Equal(t, hashResult{
+		Path: path, Size: 9, Checksums: map[string]string{"crc32": "cbf43926"},
+	}, res)
+}
+
+func TestHashCmd_Stdin(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	for _, args := range [][]string{hashArgs("-a crc32c"), hashArgs("-a crc32c", "-")} {
+		resetHash()
+
+		Root.SetIn(strings.NewReader("123456789"))
+		out, err := execute(t, args...)
+		assert.NoError(t, err)
+		assert.Equal(t, "crc32c:e3069283  -\n", out)
+	}
+
+	Root.SetIn(nil)
+}
+
+func TestHashCmd_InvalidArgs(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := hashFile(t)
+	for _, args := range []string{
+		"-a crc16",
+		"-f xml",
+		"-f sfv -a sha1",
+		"-f sfv -a crc32,sha1",
+		"-f sum -a crc32,sha1",
+	} {
+		resetHash()
+
+		_, err := execute(t, hashArgs(args, path)...)
+		assert.Equalf(t, ExitInvalidArgs, ExitCode(err), `failed for "%s"`, args)
+	}
+}
+
+func TestHashCmd_OnError(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := hashFile(t)
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+
+	// Abort on the first missing file
+	resetHash()
+	out, err := execute(t, "hash", missing, path)
+	assert.Equal(t, ExitIOError, ExitCode(err))
+	assert.Empty(t, out)
+
+	// Continue past missing files, and report a partial run
+	resetHash()
+	out, err = execute(t, "hash", "--on-error", "continue", missing, path)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+	assert.Equal(t, "crc32:cbf43926  "+path+"\n", out)
+}
+
+func TestHashCmd_Completion(t *testing.T) {
+	reset()
+	resetEnv()
+
+	out, err := execute(t, "__complete", "hash", "--algo", "")
+	require.NoError(t, err)
+
+	for _, algo := range lib.Algorithms() {
+		assert.Contains(t, out, algo)
+	}
+}
+
+func TestHashCmd_IntoArchives(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := filepath.Join(t.TempDir(), "test.zip")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+
+	archive := zip.NewWriter(file)
+	w, err := archive.Create("dir/check.txt")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("123456789"))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	require.NoError(t, file.Close())
+
+	resetHash()
+	out, err := execute(t, "hash", path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(out, "\n"), "archive walked without flag")
+
+	resetHash()
+	out, err = execute(t, "hash", "--into-archives", "-f", "sum", path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], "  "+path))
+	assert.Equal(t, "cbf43926  "+path+"/dir/check.txt", lines[1])
+}
+
+func TestHashCmd_BlockSize(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	resetHash()
+	out, err := execute(t, "hash", "-f", "json", "--block-size", "4", hashFile(t))
+	require.NoError(t, err)
+
+	var res hashResult
+	require.NoError(t, json.Unmarshal([]byte(out), &res))
+	assert.Equal(t, int64(4), res.BlockSize)
+	assert.Len(t, res.Blocks, 3) // 9 bytes split into blocks of 4
+	assert.Equal(t, "cbf43926", res.Checksums["crc32"])
+
+	// Only json can represent block checksums
+	for _, flags := range []string{"-f plain --block-size 4", "-f json --block-size -1"} {
+		resetHash()
+		_, err = execute(t, hashArgs(flags, hashFile(t))...)
+		assert.Equalf(t, ExitInvalidArgs, ExitCode(err), `failed for "%s"`, flags)
+	}
+}
+
+func TestHashCmd_Metadata(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := hashFile(t)
+
+	resetHash()
+	out, err := execute(t, "hash", "-f", "json", "--metadata", path)
+	require.NoError(t, err)
+
+	var res hashResult
+	require.NoError(t, json.Unmarshal([]byte(out), &res))
+	require.NotNil(t, res.Metadata)
+	assert.Equal(t, os.FileMode(0o600), res.Metadata.Mode)
+
+	// Not captured without the flag
+	resetHash()
+	out, err = execute(t, "hash", "-f", "json", path)
+	require.NoError(t, err)
+	assert.NotContains(t, out, `"Metadata":`)
+}
+
+func TestHashCmd_Parallel(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+	defer func(threshold int64) { lib.ParallelThreshold = threshold }(
+		lib.ParallelThreshold,
+	)
+
+	path := hashFile(t)
+	lib.ParallelThreshold = 1
+
+	// Checksums combined from segments should match a serial pass
+	for _, workers := range []int{1, 2, 4} {
+		resetHash()
+		numCPU = func() int { return workers }
+
+		out, err := execute(t, "hash", "-a", "crc32,crc64-ecma", path)
+		require.NoError(t, err)
+		assert.Equal(t, "crc32:cbf43926 crc64-ecma:995dc9bbdf1939fa  "+path+"\n", out)
+	}
+}
+
+func TestHashCmd_Quick(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := hashFile(t)
+
+	resetHash()
+	out, err := execute(t, "hash", "--quick", path)
+	require.NoError(t, err)
+	assert.Regexp(t, "^quick:[0-9a-f]{16}  "+path+"\n$", out)
+
+	for _, args := range []string{
+		"--quick -f sfv",
+		"--quick -f sum",
+		"--quick --algo sha256",
+		"--quick --into-archives",
+		"--quick --block-size 4",
+		"--quick --quick-size 0",
+	} {
+		resetHash()
+
+		_, err := execute(t, hashArgs(args, path)...)
+		assert.Equalf(t, ExitInvalidArgs, ExitCode(err), `failed for "%s"`, args)
+	}
+
+	resetHash()
+	_, err = execute(t, "hash", "--quick", "-")
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+
+	// An algorithm from the config file should not conflict with --quick
+	resetHash()
+	defer resetConfig()
+
+	configPath = writeConfig(t, configName, "algo: crc32")
+	out, err = execute(t, "hash", "--quick", path)
+	require.NoError(t, err)
+	assert.Regexp(t, "^quick:[0-9a-f]{16}  "+path+"\n$", out)
+}
+
+func TestHashCmd_Strategy(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	path := hashFile(t)
+	for _, strategy := range []string{"auto", "buffered", "mmap", "direct"} {
+		resetHash()
+
+		out, err := execute(t, "hash", "--strategy", strategy, "--buffer-size", "2", path)
+		require.NoErrorf(t, err, "failed for %s", strategy)
+		assert.Equal(t, "crc32:cbf43926  "+path+"\n", out)
+	}
+
+	resetHash()
+	_, err := execute(t, "hash", "--strategy", "aio", path)
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestHashCmd_Cache(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path := hashFile(t)
+
+	// Without the flag, the cache is not used
+	resetHash()
+	_, err := execute(t, "hash", path)
+	require.NoError(t, err)
+
+	cachePath, err := lib.CachePath()
+	require.NoError(t, err)
+	assert.NoFileExists(t, cachePath)
+
+	resetHash()
+	out, err := execute(t, "hash", "--cache", path)
+	require.NoError(t, err)
+	assert.Equal(t, "crc32:cbf43926  "+path+"\n", out)
+	assert.FileExists(t, cachePath)
+
+	// Replace the cached checksum, to ensure it is used without reading the file
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	key, ok := lib.FileKey(info)
+	require.True(t, ok)
+
+	cache := lib.OpenCache(cachePath)
+	cache.Put(path, key, lib.AlgoCRC32, "00000000")
+	require.NoError(t, cache.Save())
+
+	resetHash()
+	out, err = execute(t, "hash", "--cache", path)
+	require.NoError(t, err)
+	assert.Equal(t, "crc32:00000000  "+path+"\n", out)
+
+	// Algorithms missing from the cache are computed
+	resetHash()
+	out, err = execute(t, "hash", "--cache", "-a", "crc32,crc32c", path)
+	require.NoError(t, err)
+	assert.Equal(t, "crc32:cbf43926 crc32c:e3069283  "+path+"\n", out)
+}
+
+func TestHashCmd_Unstable(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path := hashFile(t)
+
+	// Touches the file after each of the first `changes` reads
+	unstable := func(changes int) {
+		modTime := time.Unix(1_600_000_000, 0)
+		stableRead = func(
+			file *os.File, retries int, read func(os.FileInfo) error,
+		) (os.FileInfo, error) {
+			return lib.StableRead(file, retries, func(info os.FileInfo) error {
+				err := read(info)
+				if changes--; changes >= 0 {
+					modTime = modTime.Add(time.Second)
+					require.NoError(t, os.Chtimes(path, modTime, modTime))
+				}
+
+				return err
+			})
+		}
+	}
+
+	resetHash()
+	unstable(2)
+	out, err := execute(t, "hash", "--retries", "2", "--cache", path)
+	require.NoError(t, err)
+	assert.Equal(t, "crc32:cbf43926  "+path+"\n", out)
+
+	resetHash()
+	unstable(3)
+	out, err = execute(t, hashArgs("--retries 2 --block-size 4 -f json", path)...)
+	assert.Equal(t, ExitIOError, ExitCode(err))
+	assert.Empty(t, out)
+
+	// Checksums from torn reads are never cached
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	key, ok := lib.FileKey(info)
+	require.True(t, ok)
+
+	resetHash()
+	unstable(1)
+	_, err = execute(t, hashArgs("--retries 0 --on-error continue --cache", path)...)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+
+	cachePath, err := lib.CachePath()
+	require.NoError(t, err)
+
+	_, ok = lib.OpenCache(cachePath).Get(key, lib.AlgoCRC32)
+	assert.False(t, ok)
+}
+
+func TestHashCmd_Special(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetHash()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("device numbers are only known on linux")
+	}
+
+	path := hashFile(t)
+
+	// Special files are skipped without being read
+	resetHash()
+	out, err := execute(t, "hash", "/dev/null", path)
+	require.NoError(t, err)
+	assert.Equal(t, "crc32:cbf43926  "+path+"\n", out)
+
+	// Character devices are never read, even with --hash-devices
+	resetHash()
+	out, err = execute(t, hashArgs("--format json --hash-devices", "/dev/null")...)
+	require.NoError(t, err)
+
+	var res hashResult
+	require.NoError(t, json.Unmarshal([]byte(out), &res))
+	assert.Equal(t, hashResult{
+		Path: "/dev/null", Type: lib.TypeCharDevice,
+		Device: &writer.Device{Major: 1, Minor: 3},
+	}, res)
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+var now = time.Now // maps to time.Now
+
+// byteUnits maps suffixes for sizes to their multiplier, decimal and binary units
+var byteUnits = map[string]int64{
+	"b":  1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// scrubFlags contains values for flags of the `scrub` command
+var scrubFlags = struct {
+	budget string
+}{}
+
+var scrubCmd = &cobra.Command{
+	Use:   "scrub <output-file>",
+	Short: "Verify the least recently verified files, within a budget",
+	Long: `
+Verify files in an output file, starting with files verified least recently (or never),
+till the budget runs out. The budget is either a duration (2h, 45m), or an amount of
+data to read (500GB, 1.5TiB)
+
+The time each file was verified at, and the number of times it was verified, are saved
+back to the output file - running scrub regularly cycles through all files, without a
+single run reading everything at once. Files that fail verification are not marked as
+verified, and are checked first on the next run
+`,
+	Example: `  crcgen scrub --budget 2h /mnt/archive/checksums.json`,
+	Args:    checkArgs(cobra.ExactArgs(1)),
+	RunE:    runScrub,
+}
+
+func init() {
+	setupScrubFlags()
+	Root.AddCommand(scrubCmd)
+}
+
+/*
+setupScrubFlags defines flags for the `scrub` command
+*/
+func setupScrubFlags() {
+	scrubCmd.Flags().StringVar(
+		&scrubFlags.budget, "budget", "",
+		"time (e.g. 2h), or data (e.g. 500GB) to spend verifying files (required)",
+	)
+
+	setupReadFlags(scrubCmd)
+}
+
+/*
+budget limits the time spent, or the data read by a command - only one of the limits
+is set
+*/
+type budget struct {
+	duration time.Duration
+	bytes    int64
+}
+
+/*
+parseBudget parses a budget, either as a duration (`2h30m`), or as a size with a unit
+(`500GB`, `1.5TiB`)
+*/
+func parseBudget(val string) (budget, error) {
+	val = strings.ToLower(strings.TrimSpace(val))
+	if val == "" {
+		return budget{}, errors.Wrapf(errInvalidArgs, "budget not specified")
+	}
+
+	if duration, err := time.ParseDuration(val); err == nil && duration > 0 {
+		return budget{duration: duration}, nil
+	}
+
+	num := strings.TrimRightFunc(val, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	unit, ok := byteUnits[strings.TrimSpace(val[len(num):])]
+
+	size, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if !ok || err != nil || size <= 0 {
+		return budget{}, errors.Wrapf(errInvalidArgs, "invalid budget: %s", val)
+	}
+
+	return budget{bytes: int64(size * float64(unit))}, nil
+}
+
+/*
+spent checks if the budget has been used up, given the time the run started at and the
+number of bytes read so far
+*/
+func (b budget) spent(start time.Time, read int64) bool {
+	if b.duration > 0 {
+		return now().Sub(start) >= b.duration
+	}
+
+	return read >= b.bytes
+}
+
+/*
+verifiable returns files in an output file that can be verified, i.e. files with a
+checksum, and special files (verified by their type), in the order they are listed.
+Files within archives are verified along with the archive, and are skipped
+*/
+func verifiable(root *writer.DirInfo) []*writer.FileInfo {
+	inArchive := map[*writer.FileInfo]bool{}
+	for _, archive := range root.Archives() {
+		for _, file := range archive.AllFiles() {
+			inArchive[file] = true
+		}
+	}
+
+	var files []*writer.FileInfo
+	for _, file := range root.AllFiles() {
+		if (file.Checksums.CRC32 != "" || file.Type != "") && !inArchive[file] {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+/*
+scrubOrder returns files that can be verified, ordered with files verified least
+recently first. Files never verified come first, ties are broken by path to keep the
+order stable
+*/
+func scrubOrder(root *writer.DirInfo) []*writer.FileInfo {
+	files := verifiable(root)
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].LastVerified != files[j].LastVerified {
+			return files[i].LastVerified < files[j].LastVerified
+		}
+
+		return files[i].Path < files[j].Path
+	})
+
+	return files
+}
+
+func runScrub(cmd *cobra.Command, args []string) error {
+	limit, err := parseBudget(scrubFlags.budget)
+	if err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	root, err := writer.ReadManifest(args[0])
+	if err != nil {
+		return errors.Wrapf(err, "(%s/scrub)", pkgName)
+	}
+
+	var (
+		start   = now()
+		read    int64
+		summary report.Summary
+	)
+
+	for _, file := range scrubOrder(&root) {
+		if cmd.Context().Err() != nil || limit.spent(start, read) {
+			break // progress made so far is still saved
+		}
+
+		res := lib.VerifyFile(file, verifyOptions())
+		if err = printStatus(cmd.OutOrStdout(), &res); err != nil {
+			break
+		}
+
+		summary.Results = append(summary.Results, res)
+		switch {
+		case res.Status == report.StatusOK:
+			file.LastVerified = now().Unix()
+			file.VerifyCount++
+			read += file.Size
+
+		case res.Status == report.StatusMismatch, res.Status == report.StatusModified:
+			read += file.Size
+
+		case res.Status == report.StatusError && errPolicy == lib.OnErrorAbort:
+			err = &fs.PathError{Op: "verify", Path: res.Path, Err: errors.New(res.Error)}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	// Save progress irrespective of errors, files verified need not be verified again
+	if e := writer.WriteManifest(args[0], &root); e != nil {
+		return errors.Wrapf(e, "(%s/scrub)", pkgName)
+	} else if err != nil {
+		return errors.Wrapf(err, "(%s/scrub)", pkgName)
+	}
+
+	summary = report.Summarize(summary.Results)
+	logger.Infof(
+		"(%s/scrub): %d verified, %d mismatch, %d modified, %d missing, %d failed",
+		pkgName, summary.Total, summary.Mismatch, summary.Modified, summary.Missing,
+		summary.Errors,
+	)
+
+	return summaryErr(&summary, "scrub")
+}
+
+/*
+printStatus writes the outcome of verifying a single file, followed by separate lines
+for the byte ranges that differ, and for drift in its metadata, if any
+*/
+func printStatus(out io.Writer, res *report.Result) error {
+	_, err := fmt.Fprintf(out, "%s  %s\n", res.Status, res.Path)
+	if err == nil && len(res.Ranges) > 0 {
+		_, err = fmt.Fprintf(
+			out, "bytes  %s  (%s)\n", res.Path, strings.Join(res.Ranges, ", "),
+		)
+	}
+	if err == nil && len(res.Drift) > 0 {
+		_, err = fmt.Fprintf(
+			out, "drift  %s  (%s)\n", res.Path, strings.Join(res.Drift, ", "),
+		)
+	}
+
+	return err
+}
+
+/*
+summaryErr converts the counts in a summary into the error returned by a command, if
+any. Mismatches (probable corruption) take precedence over missing files, followed by
+modified files, unexpected entries (empty directories, or files added to archives),
+drift in metadata, and files that couldn't be verified
+*/
+func summaryErr(summary *report.Summary, command string) error {
+	logTag := "(" + pkgName + "/" + command + ")"
+
+	switch {
+	case summary.Mismatch > 0:
+		return errors.Wrapf(errMismatch, "%s: %d mismatch", logTag, summary.Mismatch)
+
+	case summary.Missing > 0:
+		return errors.Wrapf(errMissing, "%s: %d missing", logTag, summary.Missing)
+
+	case summary.Modified > 0:
+		return errors.Wrapf(errModified, "%s: %d modified", logTag, summary.Modified)
+
+	case summary.Unexpected > 0:
+		return errors.Wrapf(
+			errUnexpected, "%s: %d unexpected", logTag, summary.Unexpected,
+		)
+
+	case summary.Drifted > 0:
+		return errors.Wrapf(errDrift, "%s: %d drifted", logTag, summary.Drifted)
+
+	case summary.Errors > 0:
+		return errors.Wrapf(errPartial, "%s: %d failed", logTag, summary.Errors)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func resetScrub() {
+	now = time.Now
+	openPath = lib.OpenFile
+	errPolicy = lib.OnErrorAbort
+	readOpts = lib.ReadOptions{}
+
+	// Flags retain values across runs of the command, redefine them
+	scrubCmd.ResetFlags()
+	setupScrubFlags()
+}
+
+// writeManifest creates files with the standard check input, and an output file listing
+// them. Returns the path to the output file, and to each file
+func writeManifest(t *testing.T, names ...string) (string, []string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	root := writer.DirInfo{Path: dir}
+	paths := make([]string, 0, len(names))
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("123456789"), 0o600))
+
+		paths = append(paths, path)
+		root.Files = append(root.Files, writer.FileInfo{
+			Path: path, Checksums: writer.Checksums{CRC32: "cbf43926"}, Size: 9,
+		})
+	}
+
+	manifest := filepath.Join(t.TempDir(), "output.json")
+	require.NoError(t, writer.WriteManifest(manifest, &root))
+
+	return manifest, paths
+}
+
+func TestParseBudget(t *testing.T) {
+	for val, expected := range map[string]budget{
+		"2h":      {duration: 2 * time.Hour},
+		"1h30m":   {duration: 90 * time.Minute},
+		"500GB":   {bytes: 500e9},
+		"1.5 TiB": {bytes: 3 << 39},
+		"10b":     {bytes: 10},
+	} {
+		res, err := parseBudget(val)
+		assert.NoErrorf(t, err, `failed for "%s"`, val)
+		assert.Equalf(t, expected, res, `failed for "%s"`, val)
+	}
+
+	for _, val := range []string{"", "500", "-2h", "0GB", "10XB", "GB"} {
+		_, err := parseBudget(val)
+		assert.Equalf(t, ExitInvalidArgs, ExitCode(err), `failed for "%s"`, val)
+	}
+}
+
+func TestScrubOrder(t *testing.T) {
+	root := writer.DirInfo{
+		Files: []writer.FileInfo{
+			{Path: "/c", Checksums: writer.Checksums{CRC32: "1"}, LastVerified: 20},
+			{Path: "/b", Checksums: writer.Checksums{CRC32: "1"}, LastVerified: 10},
+			{Path: "/failed", Error: "permission denied"},
+		},
+		Dirs: []writer.DirInfo{{Files: []writer.FileInfo{
+			{Path: "/d/y", Checksums: writer.Checksums{CRC32: "1"}},
+			{Path: "/d/x", Checksums: writer.Checksums{CRC32: "1"}},
+		}}},
+	}
+
+	var paths []string
+	for _, file := range scrubOrder(&root) {
+		paths = append(paths, file.Path)
+	}
+
+	assert.Equal(t, []string{"/d/x", "/d/y", "/b", "/c"}, paths)
+}
+
+func TestScrubCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetScrub()
+
+	manifest, paths := writeManifest(t, "a.txt", "b.txt", "c.txt")
+
+	clock := time.Unix(1000, 0)
+	scrub := func(budget string) (string, error) {
+		resetScrub()
+		now = func() time.Time {
+			clock = clock.Add(time.Minute)
+			return clock
+		}
+
+		return execute(t, "scrub", "--budget", budget, manifest)
+	}
+
+	// Each file is 9 bytes, a budget of 10 bytes verifies two files per run
+	out, err := scrub("10B")
+	require.NoError(t, err)
+	assert.Equal(t, "ok  "+paths[0]+"\nok  "+paths[1]+"\n", out)
+
+	out, err = scrub("10B")
+	require.NoError(t, err)
+	assert.Equal(t, "ok  "+paths[2]+"\nok  "+paths[0]+"\n", out)
+
+	root, err := writer.ReadManifest(manifest)
+	require.NoError(t, err)
+
+	counts := map[string]int{}
+	for _, file := range root.Files {
+		counts[filepath.Base(file.Path)] = file.VerifyCount
+		assert.Greater(t, file.LastVerified, int64(1000))
+	}
+
+	assert.Equal(t, map[string]int{"a.txt": 2, "b.txt": 1, "c.txt": 1}, counts)
+
+	// Time budgets stop once the duration passes, each call to `now` takes a minute
+	out, err = scrub("150s")
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+}
+
+func TestScrubCmd_Fail(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetScrub()
+
+	manifest, paths := writeManifest(t, "a.txt", "b.txt", "c.txt")
+	require.NoError(t, os.WriteFile(paths[0], []byte("987654321"), 0o600))
+	require.NoError(t, os.Remove(paths[1]))
+
+	resetScrub()
+	out, err := execute(t, "scrub", "--budget", "1h", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Equal(
+		t, "mismatch  "+paths[0]+"\nmissing  "+paths[1]+"\nok  "+paths[2]+"\n", out,
+	)
+
+	// Failed files are not marked as verified
+	root, err := writer.ReadManifest(manifest)
+	require.NoError(t, err)
+	assert.Zero(t, root.Files[0].VerifyCount)
+	assert.Zero(t, root.Files[1].VerifyCount)
+	assert.Equal(t, 1, root.Files[2].VerifyCount)
+
+	for _, args := range [][]string{
+		{"scrub", manifest},
+		{"scrub", "--budget", "lots", manifest},
+	} {
+		resetScrub()
+		_, err = execute(t, args...)
+		assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+	}
+
+	resetScrub()
+	_, err = execute(t, "scrub", "--budget", "1h", filepath.Join(t.TempDir(), "x.json"))
+	assert.Equal(t, ExitIOError, ExitCode(err))
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+// errInterrupted indicates that the run was stopped by a signal before it completed
+var errInterrupted = fmt.Errorf("(%s): interrupted by signal", pkgName)
+
+var (
+	notifySignal = signal.Notify // maps to signal.Notify
+	stopSignal   = signal.Stop   // maps to signal.Stop
+)
+
+/*
+IsInterruptedErr checks if an error was returned because the run was stopped by a
+SIGINT or SIGTERM
+*/
+func IsInterruptedErr(err error) bool {
+	return errors.Is(err, errInterrupted)
+}
+
+/*
+handleSignals listens for SIGINT and SIGTERM. The context returned is cancelled on the
+first signal - subcommands are expected to stop dispatching new files, let in-flight
+work complete and write partial results. A second signal closes the resources and exits
+immediately
+
+The function returned stops listening for signals, and should be run once the command
+completes
+*/
+func handleSignals() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	notifySignal(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Warnf(
+				"(%s/handleSignals): received %v, finishing in-flight work", pkgName, sig,
+			)
+			cancel()
+
+		case <-done:
+			return
+		}
+
+		select {
+		case sig := <-signals:
+			logger.Errorf("(%s/handleSignals): received %v, aborting", pkgName, sig)
+			closeRes()
+			exit(ExitInterrupted)
+
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		stopSignal(signals)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsInterruptedErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                     false,
+		errInterrupted:                          true,
+		errors.Wrap(errInterrupted, "test"):     true,
+		fmt.Errorf("(%s): test error", pkgName): false,
+	} {
+		assert.Equalf(t, expected, IsInterruptedErr(err), `failed for "%v"`, err)
+	}
+}
+
+// mockSignals replaces signal notifications with a channel the test can write to
+func mockSignals() chan<- os.Signal {
+	sigs := make(chan os.Signal, 2)
+	notifySignal = func(c chan<- os.Signal, _ ...os.Signal) {
+		go func() {
+			for sig := range sigs {
+				c <- sig
+			}
+		}()
+	}
+
+	stopSignal = func(chan<- os.Signal) {}
+	return sigs
+}
+
+func TestHandleSignals(t *testing.T) {
+	reset()
+
+	sigs := mockSignals()
+	defer close(sigs)
+
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	closeLogger = func() error { return nil }
+
+	ctx, stop := handleSignals()
+	defer stop()
+
+	// First signal should only cancel the context
+	sigs <- os.Interrupt
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "context not cancelled on first signal")
+	}
+
+	assert.Empty(t, exited, "exit called on first signal")
+
+	// Second signal should exit immediately
+	sigs <- syscall.SIGTERM
+	select {
+	case code := <-exited:
+		assert.Equal(t, ExitInterrupted, code)
+	case <-time.After(time.Second):
+		assert.Fail(t, "exit not called on second signal")
+	}
+}
+
+func TestHandleSignals_Stop(t *testing.T) {
+	reset()
+
+	sigs := mockSignals()
+	defer close(sigs)
+
+	ctx, stop := handleSignals()
+	stop()
+
+	assert.Error(t, ctx.Err(), "context should be cancelled once stopped")
+}
+
+func TestRun_Interrupted(t *testing.T) {
+	reset()
+	resetEnv()
+
+	sigs := mockSignals()
+	defer close(sigs)
+
+	// Mock a command that waits to be interrupted, and completes without an error
+	execCmd = func(ctx context.Context) error {
+		sigs <- os.Interrupt
+		<-ctx.Done()
+		return nil
+	}
+
+	assert.True(t, IsInterruptedErr(Run()))
+}
@@ -0,0 +1,475 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+/*
+Ways of weighting files picked for a sample by the `verify` command
+*/
+const (
+	sampleBySize    = "size"    // files are picked in proportion to their size
+	sampleUniformly = "uniform" // each file is equally likely to be picked
+)
+
+// verifyFlags contains values for flags of the `verify` command
+var verifyFlags = struct {
+	sample      string
+	sampleCount int
+	sampleBy    string
+	seed        int64
+	confidence  float64
+	report      string
+	metadata    bool
+	metaXattrs  []string
+	deep        bool
+	trustCache  bool
+}{}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <output-file>",
+	Short: "Verify files against the checksums in an output file",
+	Long: `
+Verify files against the checksums in an output file, printing the outcome for each
+file - one of ok, mismatch, modified, missing, unexpected, or error
+
+Files that no longer match are split by their size and mtime. Files with an unchanged
+size and mtime are reported as a mismatch - the contents changed without a write
+updating either, which is probable silent corruption. Files with a changed size or
+mtime are reported as modified
+
+Files within archives (see generate --into-archives) are verified against the current
+contents of the archive, a repacked archive passes as long as its files are identical
+
+Directories recorded in the output file are checked too - missing directories are
+reported as missing, and empty directories that were not recorded as unexpected (exit
+code 10). Only output files recording every directory (including empty ones, as written
+by generate) report the latter
+
+With --trust-cache, files whose checksum in the cache (see hash --cache) matches the
+output file pass without being read - as long as the file is unchanged since it was
+cached (same device, inode, size, and mtime). Other files are read as usual
+
+With --deep, checksums embedded in zip, gzip, and PNG files that pass verification are
+validated as well (see check-embedded). Files whose embedded checksums do not match were
+already corrupt when the output file was written, and are reported as a mismatch
+
+With --metadata, permissions, ownership, and extended attributes recorded in the output
+file are compared as well. Changes are reported on a separate line starting with drift,
+independent of changes to the contents. Extended attributes named by --metadata-xattrs
+that files gained since are reported as added
+
+With --sample or --sample-count, only a random subset of files is verified (skipping
+directories, and files within archives), followed by an upper bound on the rate of
+failures across all files. The subset is reproducible with --seed - by default, a new
+seed is picked for each run, and printed along with the outcome. Each file is equally
+likely to be picked unless --sample-by is size, picking files in proportion to their
+size - the bound only holds for uniform samples, and is not printed for the latter
+
+With --report, the report is written even if the run stops early - on the first file
+that can't be read (see --on-error), or when interrupted - covering the files verified
+until then
+`,
+	Example: `  crcgen verify /mnt/archive/checksums.json
+  crcgen verify --sample 2% --report report.xml /mnt/archive/checksums.json
+  crcgen verify --sample-count 100 --seed 42 /mnt/archive/checksums.json`,
+	Args: checkArgs(cobra.ExactArgs(1)),
+	RunE: runVerify,
+}
+
+func init() {
+	setupVerifyFlags()
+	Root.AddCommand(verifyCmd)
+}
+
+/*
+setupVerifyFlags defines flags for the `verify` command
+*/
+func setupVerifyFlags() {
+	verifyCmd.Flags().StringVar(
+		&verifyFlags.sample, "sample", "",
+		"percentage of files to verify, picked at random (e.g. 2%)",
+	)
+
+	verifyCmd.Flags().IntVar(
+		&verifyFlags.sampleCount, "sample-count", 0,
+		"number of files to verify, picked at random",
+	)
+
+	verifyCmd.Flags().StringVar(
+		&verifyFlags.sampleBy, "sample-by", sampleUniformly,
+		"how files are picked for a sample: uniform, size",
+	)
+
+	verifyCmd.Flags().Int64Var(
+		&verifyFlags.seed, "seed", 0,
+		"seed picking files for a sample, a random seed is used if not set",
+	)
+
+	verifyCmd.Flags().Float64Var(
+		&verifyFlags.confidence, "confidence", 0.95,
+		"confidence of the bound on failures printed for a sample, between 0 and 1",
+	)
+
+	verifyCmd.Flags().BoolVar(
+		&verifyFlags.trustCache, "trust-cache", false,
+		"pass unchanged files matching their cached checksum without reading them",
+	)
+
+	verifyCmd.Flags().BoolVar(
+		&verifyFlags.deep, "deep", false,
+		"also validate checksums embedded in zip, gzip, and PNG files",
+	)
+
+	verifyCmd.Flags().BoolVar(
+		&verifyFlags.metadata, "metadata", false,
+		"also report changes to permissions, ownership, and extended attributes",
+	)
+
+	verifyCmd.Flags().StringSliceVar(
+		&verifyFlags.metaXattrs, "metadata-xattrs", lib.ACLXattrs,
+		"extended attributes compared with --metadata, along with those recorded",
+	)
+
+	verifyCmd.Flags().StringVar(
+		&verifyFlags.report, "report", "",
+		"also write a report to this file: .xml (JUnit), or .json",
+	)
+
+	setupReadFlags(verifyCmd)
+}
+
+/*
+parseSample parses the percentage of files to sample, with or without a trailing `%`.
+Returns the fraction of files to sample
+*/
+func parseSample(val string) (float64, error) {
+	num := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), "%"))
+
+	percent, err := strconv.ParseFloat(num, 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, errors.Wrapf(errInvalidArgs, "invalid sample: %s", val)
+	}
+
+	return percent / 100, nil
+}
+
+/*
+validateVerify checks the flags of the `verify` command. Returns the fraction of files
+to sample with --sample, zero if the flag is not used
+*/
+func validateVerify(cmd *cobra.Command) (float64, error) {
+	var (
+		flags     = cmd.Flags()
+		hasSample = flags.Changed("sample")
+		hasCount  = flags.Changed("sample-count")
+	)
+
+	// A sample from the config file gives way to one passed on the command line
+	if !hasSample && !hasCount {
+		hasSample = isConfigured(cmd, "sample")
+		hasCount = isConfigured(cmd, "sample-count")
+	}
+
+	switch {
+	case hasSample && hasCount:
+		return 0, errors.Wrapf(
+			errInvalidArgs, "--sample and --sample-count can't be used together",
+		)
+
+	case hasCount && verifyFlags.sampleCount < 1:
+		return 0, errors.Wrapf(
+			errInvalidArgs, "invalid sample count: %d", verifyFlags.sampleCount,
+		)
+
+	case verifyFlags.sampleBy != sampleBySize && verifyFlags.sampleBy != sampleUniformly:
+		return 0, errors.Wrapf(
+			errInvalidArgs, "invalid --sample-by: %s", verifyFlags.sampleBy,
+		)
+
+	case verifyFlags.confidence <= 0 || verifyFlags.confidence >= 1:
+		return 0, errors.Wrapf(
+			errInvalidArgs, "invalid confidence: %v", verifyFlags.confidence,
+		)
+
+	case verifyFlags.report != "" && report.CheckFormat(verifyFlags.report) != nil:
+		return 0, errors.Wrapf(
+			errInvalidArgs, "unknown report format: %s", verifyFlags.report,
+		)
+
+	case hasSample:
+		return parseSample(verifyFlags.sample)
+	}
+
+	return 0, nil
+}
+
+/*
+sampleSize returns the number of files to sample out of `total` files, either the
+count from --sample-count, or the fraction from --sample. Returns zero if all files
+are to be verified
+*/
+func sampleSize(fraction float64, total int) int {
+	if fraction > 0 {
+		// At least one file is sampled, however small the fraction
+		return int(math.Max(1, math.Ceil(fraction*float64(total))))
+	}
+
+	return verifyFlags.sampleCount
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	fraction, err := validateVerify(cmd)
+	if err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if verifyFlags.trustCache {
+		closeCache := openCache()
+		defer closeCache()
+	}
+
+	root, err := writer.ReadManifest(args[0])
+	if err != nil {
+		return errors.Wrapf(err, "(%s/verify)", pkgName)
+	}
+
+	files := verifiable(&root)
+	total := len(files)
+
+	count := sampleSize(fraction, total)
+	if count > 0 {
+		if !cmd.Flags().Changed("seed") && !isConfigured(cmd, "seed") {
+			verifyFlags.seed = now().UnixNano()
+		}
+
+		bySize := verifyFlags.sampleBy == sampleBySize
+		files = lib.SampleFiles(files, count, verifyFlags.seed, bySize)
+	}
+
+	summary, err := verifyFiles(cmd, files)
+	if err == nil && count == 0 {
+		summary, err = verifyDirs(cmd, &root, summary)
+	}
+
+	if err == nil && count == 0 {
+		summary, err = verifyArchives(cmd, &root, summary)
+	}
+
+	// Runs stopping early still report the files verified until then
+	if verifyFlags.report != "" {
+		e := report.Write(verifyFlags.report, args[0], summary.Results)
+		if err == nil {
+			err = e
+		}
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "(%s/verify)", pkgName)
+	}
+
+	if count > 0 {
+		if err = printBound(cmd, &summary, total); err != nil {
+			return errors.Wrapf(err, "(%s/verify)", pkgName)
+		}
+	}
+
+	logger.Infof(
+		"(%s/verify): %d verified, %d mismatch, %d modified, %d missing, %d failed, "+
+			"%d drifted, %d unexpected",
+		pkgName, summary.Total, summary.Mismatch, summary.Modified, summary.Missing,
+		summary.Errors, summary.Drifted, summary.Unexpected,
+	)
+
+	return summaryErr(&summary, "verify")
+}
+
+/*
+verifyFiles verifies each file, printing the outcome as it goes. Stops early if
+interrupted, or on the first file that can't be verified with the abort policy - the
+summary then covers the files verified until then
+*/
+func verifyFiles(cmd *cobra.Command, files []*writer.FileInfo) (report.Summary, error) {
+	results := make([]report.Result, 0, len(files))
+	for _, file := range files {
+		if err := cmd.Context().Err(); err != nil {
+			return report.Summarize(results), err
+		}
+
+		res, ok := verifyCached(file)
+		if !ok {
+			res = lib.VerifyFile(file, verifyOptions())
+		}
+
+		if verifyFlags.deep && file.Type == "" && res.Status == report.StatusOK {
+			verifyEmbedded(&res)
+		}
+
+		if verifyFlags.metadata && res.Status != report.StatusMissing {
+			verifyMetadata(file, &res)
+		}
+
+		results = append(results, res)
+		if err := printStatus(cmd.OutOrStdout(), &res); err != nil {
+			return report.Summarize(results), err
+		}
+
+		if res.Status == report.StatusError && errPolicy == lib.OnErrorAbort {
+			return report.Summarize(results), &fs.PathError{
+				Op: "verify", Path: res.Path, Err: errors.New(res.Error),
+			}
+		}
+	}
+
+	return report.Summarize(results), nil
+}
+
+/*
+verifyDirs checks the directories recorded in the output file, printing directories
+that are missing, or unexpected. Results are added to the summary
+*/
+func verifyDirs(
+	cmd *cobra.Command, root *writer.DirInfo, summary report.Summary,
+) (report.Summary, error) {
+	results := summary.Results
+	for _, res := range lib.VerifyDirs(root, verifyFlags.metadata) {
+		results = append(results, res)
+		if err := printStatus(cmd.OutOrStdout(), &res); err != nil {
+			return report.Summarize(results), err
+		}
+	}
+
+	return report.Summarize(results), nil
+}
+
+/*
+verifyArchives verifies files within archives recorded in the output file, printing the
+outcome for each file. Results are added to the summary
+*/
+func verifyArchives(
+	cmd *cobra.Command, root *writer.DirInfo, summary report.Summary,
+) (report.Summary, error) {
+	results := summary.Results
+	for _, archive := range root.Archives() {
+		if err := cmd.Context().Err(); err != nil {
+			return report.Summarize(results), err
+		}
+
+		for _, res := range lib.VerifyArchive(archive) {
+			results = append(results, res)
+			if err := printStatus(cmd.OutOrStdout(), &res); err != nil {
+				return report.Summarize(results), err
+			}
+		}
+	}
+
+	return report.Summarize(results), nil
+}
+
+/*
+verifyCached verifies a file against the crc32 cached for its current version, without
+reading the file - the cache is only enabled with --trust-cache. Returns false if the
+file has to be read, i.e. no checksum is cached for it, or the checksum does not match
+*/
+func verifyCached(file *writer.FileInfo) (report.Result, bool) {
+	if hashCache == nil || file.Type != "" {
+		return report.Result{}, false
+	}
+
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			hashCache.Touch(file.Path, nil) // cached entries are stale
+		}
+
+		return report.Result{}, false
+	}
+
+	sums, ok := cacheGet(file.Path, info, []string{lib.AlgoCRC32})
+	if !ok || sums[lib.AlgoCRC32] != file.Checksums.CRC32 || info.Size() != file.Size {
+		return report.Result{}, false
+	}
+
+	return report.Result{
+		Path:     file.Path,
+		Status:   report.StatusOK,
+		Expected: file.Checksums.CRC32,
+		Actual:   sums[lib.AlgoCRC32],
+	}, true
+}
+
+/*
+verifyEmbedded validates checksums embedded in a file that passed verification, see
+lib.CheckEmbedded. Files whose embedded checksums do not match are marked as a mismatch,
+files in other formats are left as is
+*/
+func verifyEmbedded(res *report.Result) {
+	err := lib.CheckEmbedded(res.Path)
+	switch {
+	case err == nil || lib.IsNoEmbeddedErr(err):
+		return
+
+	case lib.IsEmbeddedCRCErr(err):
+		logger.Warnf("(%s/verifyEmbedded): %v", pkgName, err)
+		res.Status, res.Error = report.StatusMismatch, "embedded checksum mismatch"
+
+	default:
+		res.Status, res.Error = report.StatusError, lib.ErrorKind(err)
+	}
+}
+
+/*
+verifyMetadata compares the metadata recorded for a file against its current metadata,
+adding changes to the result. Files whose metadata can't be read are marked as errors,
+unless their contents already failed verification
+*/
+func verifyMetadata(file *writer.FileInfo, res *report.Result) {
+	drift, err := lib.VerifyMetadata(file, verifyFlags.metaXattrs)
+	if err == nil {
+		res.Drift = drift
+	} else if res.Status == report.StatusOK {
+		res.Status, res.Error = report.StatusError, lib.ErrorKind(err)
+	}
+}
+
+/*
+printBound prints the outcome of a sample of `total` files, along with the upper bound
+on the rate of failures estimated from the files verified. Every outcome other than ok
+counts as a failure
+
+The bound assumes each file was equally likely to be picked, and is left out for
+samples picked in proportion to file size
+*/
+func printBound(cmd *cobra.Command, summary *report.Summary, total int) error {
+	failed := summary.Total - summary.Passed
+	line := fmt.Sprintf(
+		"sampled %d of %d files (seed %d), %d failed",
+		summary.Total, total, verifyFlags.seed, failed,
+	)
+
+	if verifyFlags.sampleBy == sampleUniformly {
+		bound := lib.FailureBound(summary.Total, failed, verifyFlags.confidence)
+		line += fmt.Sprintf(
+			": failure rate below %.2f%% with %g%% confidence",
+			bound*100, verifyFlags.confidence*100,
+		)
+	}
+
+	_, err := fmt.Fprintln(cmd.OutOrStdout(), line)
+	return err
+}
@@ -0,0 +1,444 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func resetVerify() {
+	resetScrub()
+
+	// Flags retain values across runs of the command, redefine them
+	verifyCmd.ResetFlags()
+	setupVerifyFlags()
+}
+
+func TestParseSample(t *testing.T) {
+	for val, expected := range map[string]float64{
+		"2%": 0.02, "2": 0.02, " 0.5 % ": 0.005, "100%": 1,
+	} {
+		res, err := parseSample(val)
+		assert.NoErrorf(t, err, `failed for "%s"`, val)
+		assert.InDeltaf(t, expected, res, 1e-12, `failed for "%s"`, val)
+	}
+
+	for _, val := range []string{"", "%", "0%", "-2%", "101%", "two"} {
+		_, err := parseSample(val)
+		assert.Equalf(t, ExitInvalidArgs, ExitCode(err), `failed for "%s"`, val)
+	}
+}
+
+func TestSampleSize(t *testing.T) {
+	defer resetVerify()
+
+	assert.Equal(t, 20, sampleSize(0.02, 1000))
+	assert.Equal(t, 21, sampleSize(0.02, 1001))
+	assert.Equal(t, 1, sampleSize(0.001, 10))
+
+	verifyFlags.sampleCount = 7
+	assert.Equal(t, 7, sampleSize(0, 1000))
+}
+
+func TestVerifyCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetVerify()
+
+	manifest, paths := writeManifest(t, "a.txt", "b.txt", "c.txt", "d.txt")
+
+	resetVerify()
+	out, err := execute(t, "verify", manifest)
+	require.NoError(t, err)
+	assert.Equal(t, "ok  "+strings.Join(paths, "\nok  ")+"\n", out)
+
+	// Samples are reproducible with the same seed
+	sample := func(args ...string) string {
+		resetVerify()
+
+		out, err := execute(t, append(append([]string{"verify"}, args...), manifest)...)
+		require.NoError(t, err)
+
+		return out
+	}
+
+	out = sample("--sample-count", "2", "--seed", "42")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(
+		t, "sampled 2 of 4 files (seed 42), 0 failed: failure rate below 77.64% with "+
+			"95% confidence", lines[2],
+	)
+
+	assert.Equal(t, out, sample("--sample-count", "2", "--seed", "42"))
+	assert.Contains(t, sample("--sample", "1%"), "sampled 1 of 4 files")
+
+	// The bound is left out for samples picked by size
+	out = sample("--sample", "50%", "--sample-by", "size", "--seed", "42")
+	assert.True(t, strings.HasSuffix(out, "sampled 2 of 4 files (seed 42), 0 failed\n"))
+
+	// Reports are written along with the output
+	path := filepath.Join(t.TempDir(), "report.json")
+	sample("--report", path)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var summary report.Summary
+	require.NoError(t, json.Unmarshal(data, &summary))
+	assert.Equal(t, 4, summary.Passed)
+}
+
+func TestVerifyCmd_Fail(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetVerify()
+
+	manifest, paths := writeManifest(t, "a.txt", "b.txt", "c.txt")
+	require.NoError(t, os.WriteFile(paths[0], []byte("987654321"), 0o600))
+	require.NoError(t, os.Remove(paths[1]))
+
+	resetVerify()
+	out, err := execute(t, "verify", "--on-error", "continue", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Equal(
+		t, "mismatch  "+paths[0]+"\nmissing  "+paths[1]+"\nok  "+paths[2]+"\n", out,
+	)
+
+	// Failures are counted for the bound
+	resetVerify()
+	out, err = execute(t, "verify", "--sample", "100%", "--seed", "1", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Contains(t, out, "sampled 3 of 3 files (seed 1), 2 failed")
+
+	for _, args := range [][]string{
+		{"--sample", "2%", "--sample-count", "2"},
+		{"--sample", "lots"},
+		{"--sample-count", "0"},
+		{"--sample-by", "name"},
+		{"--confidence", "1"},
+		{"--report", filepath.Join(t.TempDir(), "report.txt")},
+	} {
+		resetVerify()
+		out, err := execute(t, append(append([]string{"verify"}, args...), manifest)...)
+		assert.Equalf(t, ExitInvalidArgs, ExitCode(err), "failed for %v", args)
+		assert.NotContainsf(t, out, paths[2], "files verified for %v", args)
+	}
+
+	resetVerify()
+	_, err = execute(t, "verify", filepath.Join(t.TempDir(), "x.json"))
+	assert.Equal(t, ExitIOError, ExitCode(err))
+
+	// Runs aborted on a file that can't be read still write the report
+	require.NoError(t, os.Remove(paths[2]))
+	require.NoError(t, os.Mkdir(paths[2], 0o700))
+
+	path := filepath.Join(t.TempDir(), "report.json")
+
+	resetVerify()
+	_, err = execute(t, "verify", "--report", path, manifest)
+	assert.Equal(t, ExitIOError, ExitCode(err))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var summary report.Summary
+	require.NoError(t, json.Unmarshal(data, &summary))
+	assert.Equal(t, 3, summary.Total)
+	assert.Equal(t, 1, summary.Errors)
+
+	require.NoError(t, os.Remove(paths[2]))
+	require.NoError(t, os.WriteFile(paths[2], []byte("123456789"), 0o600))
+
+	// Files that can't be read abort the run by default
+	if os.Geteuid() == 0 {
+		return // permissions are not enforced for root
+	}
+
+	require.NoError(t, os.Chmod(paths[2], 0))
+	defer func() { _ = os.Chmod(paths[2], 0o600) }()
+
+	resetVerify()
+	_, err = execute(t, "verify", manifest)
+	assert.Equal(t, ExitIOError, ExitCode(err))
+}
+
+func TestVerifyCmd_Interrupted(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetVerify()
+
+	manifest, _ := writeManifest(t, "a.txt", "b.txt")
+	path := filepath.Join(t.TempDir(), "report.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resetVerify()
+	verifyFlags.report = path
+
+	// Subcommands keep the context of their first run, run afresh
+	cmd := &cobra.Command{RunE: runVerify, SilenceErrors: true, SilenceUsage: true}
+	cmd.SetArgs([]string{manifest})
+
+	err := cmd.ExecuteContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The report is written, even though no file was verified
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var summary report.Summary
+	require.NoError(t, json.Unmarshal(data, &summary))
+	assert.Zero(t, summary.Total)
+}
+
+func TestVerifyCmd_Classify(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetVerify()
+
+	manifest, paths := writeManifest(t, "a.txt", "b.txt", "c.txt")
+
+	// Record the mtime of each file in the output file
+	root, err := writer.ReadManifest(manifest)
+	require.NoError(t, err)
+
+	modTime := time.Unix(1_600_000_000, 0)
+	for i := range root.Files {
+		require.NoError(t, os.Chtimes(root.Files[i].Path, modTime, modTime))
+		root.Files[i].LastMod = modTime.Unix()
+	}
+
+	require.NoError(t, writer.WriteManifest(manifest, &root))
+
+	// Edits update the mtime, while corruption leaves it unchanged
+	require.NoError(t, os.WriteFile(paths[1], []byte("edited..."), 0o600))
+	require.NoError(t, os.WriteFile(paths[2], []byte("12345678X"), 0o600))
+	require.NoError(t, os.Chtimes(paths[2], modTime, modTime))
+
+	resetVerify()
+	out, err := execute(t, "verify", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Equal(
+		t, "ok  "+paths[0]+"\nmodified  "+paths[1]+"\nmismatch  "+paths[2]+"\n", out,
+	)
+
+	// Modifications alone have an exit code of their own
+	require.NoError(t, os.WriteFile(paths[2], []byte("123456789"), 0o600))
+	require.NoError(t, os.Chtimes(paths[2], modTime, modTime))
+
+	resetVerify()
+	_, err = execute(t, "verify", manifest)
+	assert.Equal(t, ExitModified, ExitCode(err))
+}
+
+func TestVerifyCmd_Deep(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer resetVerify()
+
+	// Files corrupt before the output file was written still match their checksums
+	dir := generateTree(t, "plain.txt")
+	corrupt := writeGzip(t, dir, "corrupt.gz", true)
+	valid := writeGzip(t, dir, "valid.gz", false)
+	manifest := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetGenerate()
+	_, err := execute(t, "generate", dir, manifest)
+	require.NoError(t, err)
+
+	resetVerify()
+	_, err = execute(t, "verify", manifest)
+	require.NoError(t, err)
+
+	resetVerify()
+	out, err := execute(t, "verify", "--deep", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+
+	plain := filepath.Join(dir, "plain.txt")
+	assert.Equal(
+		t, "mismatch  "+corrupt+"\nok  "+plain+"\nok  "+valid+"\n", out,
+	)
+}
+
+func TestVerifyCmd_Blocks(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer resetVerify()
+
+	dir := generateTree(t, "check.txt")
+	path := filepath.Join(dir, "check.txt")
+	manifest := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetGenerate()
+	_, err := execute(t, "generate", "--block-size", "4", dir, manifest)
+	require.NoError(t, err)
+
+	root, err := writer.ReadManifest(manifest)
+	require.NoError(t, err)
+	require.Len(t, root.Files, 1)
+	assert.Equal(t, int64(4), root.Files[0].BlockSize)
+	assert.Len(t, root.Files[0].Blocks, 3)
+
+	// Corruption within the second block is narrowed down to its byte range
+	modTime := time.Unix(root.Files[0].LastMod, 0)
+	require.NoError(t, os.WriteFile(path, []byte("12345X789"), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	resetVerify()
+	out, err := execute(t, "verify", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Equal(t, "mismatch  "+path+"\nbytes  "+path+"  (4-8)\n", out)
+
+	resetGenerate()
+	_, err = execute(t, "generate", "--block-size", "-1", dir, manifest)
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+}
+
+func TestVerifyCmd_TrustCache(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+	defer resetVerify()
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := generateTree(t, "check.txt")
+	path := filepath.Join(dir, "check.txt")
+	manifest := filepath.Join(t.TempDir(), "checksums.json")
+
+	// Checksums computed by generate are cached
+	resetGenerate()
+	_, err := execute(t, "generate", "--cache", dir, manifest)
+	require.NoError(t, err)
+
+	// Corrupt the file, leaving its size and mtime (and thus, the cache key) as is
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("12345X789"), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+	resetVerify()
+	out, err := execute(t, "verify", "--trust-cache", manifest)
+	require.NoError(t, err)
+	assert.Equal(t, "ok  "+path+"\n", out)
+
+	resetVerify()
+	out, err = execute(t, "verify", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Equal(t, "mismatch  "+path+"\n", out)
+
+	// Entries for files that no longer exist are pruned
+	require.NoError(t, os.Remove(path))
+
+	resetVerify()
+	_, err = execute(t, "verify", "--trust-cache", manifest)
+	assert.Equal(t, ExitMissing, ExitCode(err))
+
+	cachePath, err := lib.CachePath()
+	require.NoError(t, err)
+	data, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), path)
+}
+
+func TestVerifyCmd_Metadata(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetVerify()
+
+	manifest, paths := writeManifest(t, "a.txt", "b.txt")
+
+	// Record the metadata of each file in the output file
+	root, err := writer.ReadManifest(manifest)
+	require.NoError(t, err)
+
+	for i := range root.Files {
+		info, err := os.Stat(root.Files[i].Path)
+		require.NoError(t, err)
+
+		root.Files[i].Metadata, err = lib.CaptureMetadata(paths[i], info, nil)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.WriteManifest(manifest, &root))
+	require.NoError(t, os.Chmod(paths[1], 0o644))
+
+	// Drift is only reported with the flag
+	resetVerify()
+	_, err = execute(t, "verify", manifest)
+	require.NoError(t, err)
+
+	resetVerify()
+	out, err := execute(t, "verify", "--metadata", manifest)
+	assert.Equal(t, ExitDrift, ExitCode(err))
+	assert.Equal(
+		t, "ok  "+paths[0]+"\nok  "+paths[1]+"\ndrift  "+paths[1]+
+			"  (mode -rw------- -> -rw-r--r--)\n", out,
+	)
+
+	// Changes to the contents take precedence
+	require.NoError(t, os.WriteFile(paths[0], []byte("987654321"), 0o600))
+
+	resetVerify()
+	_, err = execute(t, "verify", "--metadata", manifest)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+}
+
+func TestVerifyCmd_Dirs(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetVerify()
+
+	dir := generateTree(t, "a.txt")
+	paths := []string{filepath.Join(dir, "a.txt")}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "spool"), 0o755))
+
+	// Every directory is recorded in the output file, including empty directories
+	manifest := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetGenerate()
+	_, err := execute(t, "generate", dir, manifest)
+	require.NoError(t, err)
+
+	resetVerify()
+	_, err = execute(t, "verify", manifest)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "spool")))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "new"), 0o755))
+
+	resetVerify()
+	out, err := execute(t, "verify", manifest)
+	assert.Equal(t, ExitMissing, ExitCode(err))
+	assert.Equal(
+		t, "ok  "+paths[0]+"\nmissing  "+filepath.Join(dir, "spool")+
+			"\nunexpected  "+filepath.Join(dir, "new")+"\n", out,
+	)
+
+	// Unexpected directories alone have their own exit code
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "spool"), 0o755))
+
+	resetVerify()
+	_, err = execute(t, "verify", manifest)
+	assert.Equal(t, ExitUnexpected, ExitCode(err))
+
+	// Directories are not checked for samples
+	resetVerify()
+	_, err = execute(t, "verify", "--sample-count", "1", manifest)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+/*
+Outcomes of checking checksums stored in extended attributes
+*/
+const (
+	xattrOK       = "ok"       // checksums match
+	xattrMismatch = "mismatch" // checksums differ, while the mtime is unchanged
+	xattrStale    = "stale"    // file was modified after checksums were stored
+	xattrMissing  = "missing"  // no checksums stored
+)
+
+// xattrFlags contains values for flags of the `xattr` subcommands
+var xattrFlags = struct {
+	algos []string
+}{}
+
+var xattrCmd = &cobra.Command{
+	Use:   "xattr",
+	Short: "Store checksums in extended attributes of files",
+	Long: `
+Store checksums of files in their extended attributes (user.crcgen.*), along with the
+mtime they were computed at. Checksums stay attached to files that are moved, or copied
+with tools preserving extended attributes - independent of any output file
+`,
+}
+
+var xattrWriteCmd = &cobra.Command{
+	Use:   "write <paths...>",
+	Short: "Compute checksums, and store them in extended attributes",
+	Args:  checkArgs(cobra.MinimumNArgs(1)),
+	RunE:  runXattr(xattrWrite),
+}
+
+var xattrCheckCmd = &cobra.Command{
+	Use:   "check <paths...>",
+	Short: "Validate files against checksums stored in extended attributes",
+	Long: `
+Validate files against checksums stored in extended attributes, printing the outcome for
+each file - one of ok, mismatch, stale (modified after checksums were stored), or
+missing (no checksums stored). Only mismatches are treated as failures
+`,
+	Args: checkArgs(cobra.MinimumNArgs(1)),
+	RunE: runXattr(xattrCheck),
+}
+
+var xattrClearCmd = &cobra.Command{
+	Use:   "clear <paths...>",
+	Short: "Remove checksums stored in extended attributes",
+	Args:  checkArgs(cobra.MinimumNArgs(1)),
+	RunE:  runXattr(xattrClear),
+}
+
+func init() {
+	setupXattrFlags()
+
+	xattrCmd.AddCommand(xattrWriteCmd, xattrCheckCmd, xattrClearCmd)
+	Root.AddCommand(xattrCmd)
+}
+
+/*
+setupXattrFlags defines flags for the `xattr` subcommands
+*/
+func setupXattrFlags() {
+	xattrWriteCmd.Flags().StringSliceVarP(
+		&xattrFlags.algos, "algo", "a", []string{lib.AlgoCRC32},
+		"checksum algorithms: "+strings.Join(lib.Algorithms(), ", "),
+	)
+}
+
+/*
+xattrStats counts the outcome of processing files in the `xattr` subcommands
+*/
+type xattrStats struct {
+	done, mismatch, failed int
+}
+
+/*
+result converts the counts into the error returned by the command, if any
+*/
+func (stats *xattrStats) result() error {
+	const logTag = "(" + pkgName + "/xattr)"
+
+	switch {
+	case stats.mismatch > 0:
+		return errors.Wrapf(errMismatch, "%s: %d mismatched", logTag, stats.mismatch)
+	case stats.failed > 0:
+		return errors.Wrapf(errPartial, "%s: %d failed", logTag, stats.failed)
+	}
+
+	return nil
+}
+
+/*
+xattrAction processes a single regular file for an `xattr` subcommand
+*/
+type xattrAction func(
+	out io.Writer, path string, info fs.FileInfo, stats *xattrStats,
+) error
+
+/*
+runXattr forms the function running an `xattr` subcommand - walks through each path,
+running the action on regular files
+*/
+func runXattr(action xattrAction) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if _, err := lib.NewHashes(xattrFlags.algos); err != nil {
+			return errors.Wrapf(errInvalidArgs, "%v", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		var stats xattrStats
+		walkFunc := func(path string, info fs.FileInfo, err error) error {
+			switch {
+			case cmd.Context().Err() != nil:
+				return cmd.Context().Err() // interrupted, stop the walk
+			case err != nil:
+				stats.failed++
+				return err
+			case !info.Mode().IsRegular():
+				return nil
+			}
+
+			if err = action(cmd.OutOrStdout(), path, info, &stats); err != nil {
+				stats.failed++
+			}
+
+			return err
+		}
+
+		for _, path := range args {
+			// Partial walks are reflected in the counts, errors have already been logged
+			err := lib.WalkPath(path, errPolicy, walkFunc)
+			if err != nil && !lib.IsPartialErr(err) {
+				return errors.Wrapf(err, "(%s/xattr)", pkgName)
+			}
+		}
+
+		logger.Infof(
+			"(%s/xattr): %d done, %d mismatched, %d failed",
+			pkgName, stats.done, stats.mismatch, stats.failed,
+		)
+
+		return stats.result()
+	}
+}
+
+/*
+xattrWrite computes checksums for a file, and stores them in its extended attributes
+*/
+func xattrWrite(_ io.Writer, path string, _ fs.FileInfo, stats *xattrStats) error {
+	// The mtime is taken before reading, changes after reading leave checksums stale
+	sums, info, err := readChecksums(path, xattrFlags.algos)
+	if err == nil {
+		err = lib.WriteXattrs(path, lib.XattrSums{
+			MtimeNs: info.ModTime().UnixNano(), Checksums: sums,
+		})
+	}
+
+	if err == nil {
+		stats.done++
+	}
+
+	return err
+}
+
+/*
+xattrCheck validates a file against checksums stored in its extended attributes, and
+prints the outcome
+*/
+func xattrCheck(out io.Writer, path string, info fs.FileInfo, stats *xattrStats) error {
+	stored, err := lib.ReadXattrs(path)
+
+	status := xattrOK
+	switch {
+	case lib.IsNoXattrsErr(err):
+		status = xattrMissing
+	case err != nil:
+		return err
+	case !stored.Fresh(info):
+		status = xattrStale
+	default:
+		algos := make([]string, 0, len(stored.Checksums))
+		for algo := range stored.Checksums {
+			algos = append(algos, algo)
+		}
+
+		sums, _, err := readChecksums(path, algos)
+		if err != nil {
+			return err
+		}
+
+		for algo, sum := range stored.Checksums {
+			if sums[algo] != sum {
+				logger.Warnf(
+					`(%s/xattrCheck): "%s": %s mismatch, stored %s, computed %s`,
+					pkgName, path, algo, sum, sums[algo],
+				)
+
+				stats.mismatch++
+				status = xattrMismatch
+				break
+			}
+		}
+	}
+
+	stats.done++
+	_, err = fmt.Fprintf(out, "%s  %s\n", status, path)
+	return errors.Wrapf(err, "(%s/xattrCheck)", pkgName)
+}
+
+/*
+xattrClear removes checksums stored in the extended attributes of a file
+*/
+func xattrClear(_ io.Writer, path string, _ fs.FileInfo, stats *xattrStats) error {
+	if err := lib.ClearXattrs(path); err != nil {
+		return err
+	}
+
+	stats.done++
+	return nil
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/lib"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func resetXattr() {
+	openPath = lib.OpenFile
+	errPolicy = lib.OnErrorAbort
+
+	// Flags retain values across runs of the command, redefine them
+	xattrWriteCmd.ResetFlags()
+	setupXattrFlags()
+}
+
+// xattrDir creates a directory with a file to store checksums on, skipping the test if
+// extended attributes are not supported
+func xattrDir(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte("123456789"), 0o600))
+
+	if err := lib.ClearXattrs(path); lib.IsXattrUnsupportedErr(err) {
+		t.Skip("extended attributes not supported")
+	}
+
+	return dir, path
+}
+
+func TestXattrCmd(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetXattr()
+
+	dir, path := xattrDir(t)
+
+	resetXattr()
+	out, err := execute(t, "xattr", "check", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "missing  "+path+"\n", out)
+
+	resetXattr()
+	_, err = execute(t, "xattr", "write", "-a", "crc32,sha1", dir)
+	require.NoError(t, err)
+
+	sums, err := lib.ReadXattrs(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"crc32": "cbf43926", "sha1": "f7c3bc1d808e04732adf679965ccc34ca7ae3441",
+	}, sums.Checksums)
+
+	resetXattr()
+	out, err = execute(t, "xattr", "check", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "ok  "+path+"\n", out)
+
+	// Checksums trusted by `hash`, as long as the file is unchanged
+	resetHash()
+	out, err = execute(t, "hash", "--from-xattr", "-a", "sha1", path)
+	require.NoError(t, err)
+	assert.Equal(t, "sha1:f7c3bc1d808e04732adf679965ccc34ca7ae3441  "+path+"\n", out)
+
+	// Corrupt the file, keeping its mtime
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("123456780"), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+	resetXattr()
+	out, err = execute(t, "xattr", "check", dir)
+	assert.Equal(t, ExitMismatch, ExitCode(err))
+	assert.Equal(t, "mismatch  "+path+"\n", out)
+
+	// Modified files are stale, not mismatched
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	resetXattr()
+	out, err = execute(t, "xattr", "check", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "stale  "+path+"\n", out)
+
+	resetXattr()
+	_, err = execute(t, "xattr", "clear", dir)
+	require.NoError(t, err)
+
+	_, err = lib.ReadXattrs(path)
+	assert.True(t, lib.IsNoXattrsErr(err))
+}
+
+func TestXattrCmd_Fail(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetXattr()
+
+	dir, _ := xattrDir(t)
+
+	resetXattr()
+	_, err := execute(t, "xattr", "write", "-a", "crc16", dir)
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+
+	resetXattr()
+	_, err = execute(t, "xattr", "check")
+	assert.Equal(t, ExitInvalidArgs, ExitCode(err))
+
+	// Files that can't be read fail the run, or are skipped over with `continue`
+	denied := func(path string, _ bool) (*os.File, error) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrPermission}
+	}
+
+	openPath = denied
+	_, err = execute(t, "xattr", "write", dir)
+	assert.Equal(t, ExitIOError, ExitCode(err))
+
+	resetXattr()
+	openPath = denied
+	_, err = execute(t, "xattr", "write", "--on-error", "continue", dir)
+	assert.Equal(t, ExitPartial, ExitCode(err))
+}
+
+func TestGenerateCmd_FromXattr(t *testing.T) {
+	reset()
+	resetEnv()
+	defer resetGenerate()
+
+	dir, path := xattrDir(t)
+	output := filepath.Join(t.TempDir(), "checksums.json")
+
+	resetXattr()
+	_, err := execute(t, "xattr", "write", dir)
+	require.NoError(t, err)
+
+	// Corrupt the file keeping its mtime, the stored crc32 is recorded as is
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("123456780"), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+	crc := func(args ...string) string {
+		t.Helper()
+
+		resetGenerate()
+		_, err := execute(t, append(append([]string{"generate"}, args...), dir, output)...)
+		require.NoError(t, err)
+
+		root, err := writer.ReadManifest(output)
+		require.NoError(t, err)
+		require.Len(t, root.Files, 1)
+
+		return root.Files[0].Checksums.CRC32
+	}
+
+	assert.Equal(t, "cbf43926", crc("--from-xattr"))
+	assert.NotEqual(t, "cbf43926", crc())
+
+	// Block checksums can't be taken from extended attributes
+	assert.NotEqual(t, "cbf43926", crc("--from-xattr", "--block-size", "4"))
+
+	// Stored checksums of modified files are stale
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	assert.NotEqual(t, "cbf43926", crc("--from-xattr"))
+}
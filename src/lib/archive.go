@@ -0,0 +1,333 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// errNotArchive indicates that a file is not a supported archive
+var errNotArchive = fmt.Errorf("(%s): not a supported archive", pkgName)
+
+var openPath = openRegular // maps to openRegular
+
+/*
+IsNotArchiveErr checks if an error was caused because a file is not a supported archive
+*/
+func IsNotArchiveErr(err error) bool {
+	return errors.Is(err, errNotArchive)
+}
+
+/*
+zipArchive is a zip archive opened for reading, along with the file it is read from
+*/
+type zipArchive struct {
+	*zip.Reader
+	file *os.File
+}
+
+// Close closes the file the archive is read from
+func (archive *zipArchive) Close() error {
+	return archive.file.Close()
+}
+
+/*
+openZip opens a zip archive through openPath - unlike zip.OpenReader, special files are
+refused rather than opened, which blocks for FIFOs
+*/
+func openZip(path string) (*zipArchive, error) {
+	file, err := openPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		var reader *zip.Reader
+		if reader, err = zip.NewReader(file, info.Size()); err == nil {
+			return &zipArchive{Reader: reader, file: file}, nil
+		}
+	}
+
+	_ = file.Close()
+	return nil, err
+}
+
+/*
+ArchiveEntry contains checksums computed for a single file inside an archive
+*/
+type ArchiveEntry struct {
+	// Name contains the path to the file, relative to the root of the archive. Cleaned
+	// to a slash-separated path that can't point outside the archive, see entryPath
+	Name string
+
+	// Size contains the uncompressed size of the file, in bytes
+	Size int64
+
+	// LastMod contains the modification time of the file as epoch time
+	LastMod int64
+
+	// Checksums maps each algorithm to the checksum of the file
+	Checksums map[string]string
+}
+
+/*
+IsArchive checks if a file is an archive that can be walked through, based on the file
+extension. Supported archives are `.zip`, `.tar`, `.tar.gz` and `.tgz`
+*/
+func IsArchive(path string) bool {
+	return archiveKind(path) != ""
+}
+
+/*
+archiveKind returns the kind of archive (`zip`, `tar` or `tgz`) based on the extension
+of the file, or an empty string if the file is not a supported archive
+*/
+func archiveKind(path string) string {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz"
+	}
+
+	return ""
+}
+
+/*
+WalkArchive reads each regular file in an archive, computing its checksums with the
+algorithms, and runs `walkFunc` on the result. Directories, links and other special
+entries are skipped
+
+Returns an error that can be checked with IsNotArchiveErr if the file is not a supported
+archive. An error returned by `walkFunc` stops the walk, and is returned as is
+*/
+func WalkArchive(
+	path string, algos []string, walkFunc func(*ArchiveEntry) error,
+) error {
+	var err error
+
+	switch archiveKind(path) {
+	case "zip":
+		err = walkZip(path, algos, walkFunc)
+	case "tar", "tgz":
+		err = walkTar(path, algos, walkFunc)
+	default:
+		err = errNotArchive
+	}
+
+	return errors.Wrapf(err, "(%s/WalkArchive)", pkgName)
+}
+
+func walkZip(path string, algos []string, walkFunc func(*ArchiveEntry) error) error {
+	reader, err := openZip(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		entry, err := zipEntry(file, algos)
+		if err != nil {
+			return errors.Wrapf(err, "%s", file.Name)
+		}
+
+		if err = walkFunc(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func zipEntry(file *zip.File, algos []string) (*ArchiveEntry, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	// Reading a zip entry till the end also validates its stored CRC
+	sums, size, err := Checksum(reader, algos)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveEntry{
+		Name:      entryPath(file.Name),
+		Size:      size,
+		LastMod:   file.Modified.Unix(),
+		Checksums: sums,
+	}, nil
+}
+
+func walkTar(path string, algos []string, walkFunc func(*ArchiveEntry) error) error {
+	file, err := openPath(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var stream io.Reader = file
+	if archiveKind(path) == "tgz" {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = gz.Close() }()
+		stream = gz
+	}
+
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		sums, size, err := Checksum(reader, algos)
+		if err != nil {
+			return errors.Wrapf(err, "%s", header.Name)
+		}
+
+		err = walkFunc(&ArchiveEntry{
+			Name:      entryPath(header.Name),
+			Size:      size,
+			LastMod:   header.ModTime.Unix(),
+			Checksums: sums,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+ArchiveDir represents the contents of an archive as a virtual directory - files in the
+archive are nested under the path to the archive itself, as though the archive was a
+directory. Checksums are computed with the crc32 algorithm
+*/
+func ArchiveDir(archive string) (writer.DirInfo, error) {
+	root := writer.DirInfo{Path: archive, Archive: true}
+
+	err := WalkArchive(archive, []string{AlgoCRC32}, func(entry *ArchiveEntry) error {
+		root.AddFile(writer.FileInfo{
+			Path:      filepath.Join(archive, filepath.FromSlash(entry.Name)),
+			Checksums: writer.Checksums{CRC32: entry.Checksums[AlgoCRC32]},
+			Size:      entry.Size,
+			LastMod:   entry.LastMod,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return writer.DirInfo{}, errors.Wrapf(err, "(%s/ArchiveDir)", pkgName)
+	}
+
+	_ = root.CalcModTime()
+	_ = root.CalcDigest()
+	return root, nil
+}
+
+/*
+VerifyArchive verifies files recorded within an archive (see ArchiveDir) against the
+current contents of the archive, matching files by their path inside the archive. Only
+the files are compared - a repacked archive passes as long as its files are identical
+
+Returns a result for each file, files no longer in the archive are reported as missing,
+and files added to it as unexpected. Files that do not match are classified by their
+size and mtime, similar to Classify. If the archive can't be read, every recorded file
+is reported with the error
+*/
+func VerifyArchive(recorded *writer.DirInfo) []report.Result {
+	files := recorded.AllFiles()
+	results := make([]report.Result, 0, len(files))
+
+	current, err := ArchiveDir(recorded.Path)
+	if err != nil {
+		for _, file := range files {
+			res := report.Result{Path: file.Path, Expected: file.Checksums.CRC32}
+			results = append(results, failedResult(res, err))
+		}
+
+		return results
+	}
+
+	found := map[string]*writer.FileInfo{}
+	for _, file := range current.AllFiles() {
+		found[file.Path] = file
+	}
+
+	for _, file := range files {
+		res := report.Result{
+			Path: file.Path, Expected: file.Checksums.CRC32, Status: report.StatusMissing,
+		}
+
+		if actual, ok := found[file.Path]; ok {
+			delete(found, file.Path)
+
+			res.Actual = actual.Checksums.CRC32
+			switch {
+			case res.Actual == res.Expected && actual.Size == file.Size:
+				res.Status = report.StatusOK
+			case actual.Size != file.Size:
+				res.Status = report.StatusModified
+			case file.LastMod != 0 && actual.LastMod != file.LastMod:
+				res.Status = report.StatusModified
+			default:
+				res.Status = report.StatusMismatch
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	// Files left over were added to the archive, reported in the order of the archive
+	for _, file := range current.AllFiles() {
+		if _, ok := found[file.Path]; ok {
+			results = append(results, report.Result{
+				Path: file.Path, Actual: file.Checksums.CRC32, Status: report.StatusUnexpected,
+			})
+		}
+	}
+
+	return results
+}
+
+/*
+entryPath cleans the name of an archive entry - the result is a slash-separated path
+that can't point outside the archive (i.e. no leading `/` or `..`)
+*/
+func entryPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
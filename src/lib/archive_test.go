@@ -0,0 +1,306 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// archiveFiles contains files to be written into test archives, mapped to contents
+var archiveFiles = map[string]string{
+	"check.txt":        checkInput,
+	"dir/nested.txt":   checkInput,
+	"dir/sub/deep.txt": "",
+}
+
+// archiveTime is the modification time for files in test archives
+var archiveTime = time.Unix(1600000000, 0)
+
+// sortedNames returns names of files in archiveFiles, sorted
+func sortedNames() []string {
+	names := make([]string, 0, len(archiveFiles))
+	for name := range archiveFiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func writeZip(t *testing.T, path string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, file.Close()) }()
+
+	archive := zip.NewWriter(file)
+	_, err = archive.Create("dir/") // directories should be skipped
+	require.NoError(t, err)
+
+	for _, name := range sortedNames() {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Modified: archiveTime})
+		require.NoError(t, err)
+
+		_, err = io.WriteString(w, archiveFiles[name])
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, archive.Close())
+}
+
+func writeTar(t *testing.T, path string, compress bool) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, file.Close()) }()
+
+	var out io.Writer = file
+	if compress {
+		gz := gzip.NewWriter(file)
+		defer func() { require.NoError(t, gz.Close()) }()
+		out = gz
+	}
+
+	archive := tar.NewWriter(out)
+	defer func() { require.NoError(t, archive.Close()) }()
+
+	// Directories, and links should be skipped
+	require.NoError(t, archive.WriteHeader(&tar.Header{
+		Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755,
+	}))
+	require.NoError(t, archive.WriteHeader(&tar.Header{
+		Name: "link", Typeflag: tar.TypeSymlink, Linkname: "check.txt",
+	}))
+
+	for _, name := range sortedNames() {
+		require.NoError(t, archive.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(archiveFiles[name])),
+			ModTime:  archiveTime,
+		}))
+
+		_, err = io.WriteString(archive, archiveFiles[name])
+		require.NoError(t, err)
+	}
+}
+
+// testArchives creates an archive of each supported kind in a temporary directory
+func testArchives(t *testing.T) []string {
+	t.Helper()
+
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "test.zip"),
+		filepath.Join(dir, "test.tar"),
+		filepath.Join(dir, "test.tar.gz"),
+		filepath.Join(dir, "test.TGZ"),
+	}
+
+	writeZip(t, paths[0])
+	writeTar(t, paths[1], false)
+	writeTar(t, paths[2], true)
+	writeTar(t, paths[3], true)
+
+	return paths
+}
+
+func TestIsArchive(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"a.zip":        true,
+		"/b/c.ZIP":     true,
+		"d.tar":        true,
+		"e.tar.gz":     true,
+		"f.tgz":        true,
+		"g.gz":         false,
+		"h.txt":        false,
+		"zip":          false,
+		"archive.7z":   false,
+		"archive.rar":  false,
+		"dir.tar/file": false,
+	} {
+		assert.Equalf(t, expected, IsArchive(path), `failed for "%s"`, path)
+	}
+}
+
+func TestWalkArchive(t *testing.T) {
+	for _, path := range testArchives(t) {
+		var names []string
+		err := WalkArchive(path, []string{AlgoCRC32}, func(entry *ArchiveEntry) error {
+			names = append(names, entry.Name)
+
+			contents := archiveFiles[entry.Name]
+			sums, _, _ := Checksum(strings.NewReader(contents), []string{AlgoCRC32})
+
+			assert.Equal(t, int64(len(contents)), entry.Size)
+			assert.Equal(t, archiveTime.Unix(), entry.LastMod)
+			assert.Equal(t, sums, entry.Checksums)
+			return nil
+		})
+
+		assert.NoErrorf(t, err, `failed for "%s"`, path)
+		assert.Equalf(t, sortedNames(), names, `failed for "%s"`, path)
+	}
+}
+
+func TestWalkArchive_Fail(t *testing.T) {
+	err := WalkArchive("file.txt", nil, nil)
+	assert.True(t, IsNotArchiveErr(err))
+
+	// Errors from `walkFunc` should stop the walk
+	for _, path := range testArchives(t) {
+		calls := 0
+		err = WalkArchive(path, []string{AlgoCRC32}, func(*ArchiveEntry) error {
+			calls++
+			return fmt.Errorf("(%s/TestWalkArchive_Fail): test error", pkgName)
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+
+		// Unknown algorithms should fail
+		assert.Error(t, WalkArchive(path, []string{"crc16"}, nil))
+	}
+
+	// Corrupt, or missing archives should fail
+	dir := t.TempDir()
+	for _, name := range []string{"bad.zip", "bad.tar.gz", "missing.tar"} {
+		if name != "missing.tar" {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("bad"), 0o600))
+		}
+
+		err = WalkArchive(filepath.Join(dir, name), nil, nil)
+		assert.Errorf(t, err, `failed for "%s"`, name)
+		assert.False(t, IsNotArchiveErr(err))
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	path := testArchives(t)[0]
+
+	dir, err := ArchiveDir(path)
+	require.NoError(t, err)
+
+	crc := "cbf43926" // crc32 for checkInput
+	expected := writer.DirInfo{
+		Path:    path,
+		Archive: true,
+		LastMod: archiveTime.Unix(),
+		Files: []writer.FileInfo{{
+			Path:      filepath.Join(path, "check.txt"),
+			Checksums: writer.Checksums{CRC32: crc},
+			Size:      9,
+			LastMod:   archiveTime.Unix(),
+		}},
+		Dirs: []writer.DirInfo{{
+			Path:    filepath.Join(path, "dir"),
+			LastMod: archiveTime.Unix(),
+			Files: []writer.FileInfo{{
+				Path:      filepath.Join(path, "dir", "nested.txt"),
+				Checksums: writer.Checksums{CRC32: crc},
+				Size:      9,
+				LastMod:   archiveTime.Unix(),
+			}},
+			Dirs: []writer.DirInfo{{
+				Path:    filepath.Join(path, "dir", "sub"),
+				LastMod: archiveTime.Unix(),
+				Files: []writer.FileInfo{{
+					Path:      filepath.Join(path, "dir", "sub", "deep.txt"),
+					Checksums: writer.Checksums{CRC32: "00000000"},
+					LastMod:   archiveTime.Unix(),
+				}},
+			}},
+		}},
+	}
+
+	_ = expected.CalcDigest() // digests are computed for the whole tree
+	assert.Equal(t, expected, dir)
+
+	_, err = ArchiveDir("file.txt")
+	assert.True(t, IsNotArchiveErr(err))
+}
+
+func TestVerifyArchive(t *testing.T) {
+	path := testArchives(t)[0]
+	recorded, err := ArchiveDir(path)
+	require.NoError(t, err)
+
+	for _, res := range VerifyArchive(&recorded) {
+		assert.Equalf(t, report.StatusOK, res.Status, `failed for "%s"`, res.Path)
+	}
+
+	// Repack the archive - with a file corrupted in place, a file modified, a file
+	// removed, and a file added (whose name is cleaned)
+	file, err := os.Create(path)
+	require.NoError(t, err)
+
+	archive := zip.NewWriter(file)
+	for name, modified := range map[string]time.Time{
+		"check.txt":      archiveTime,
+		"dir/nested.txt": archiveTime.Add(time.Hour),
+		"../new.txt":     archiveTime,
+	} {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Modified: modified})
+		require.NoError(t, err)
+
+		_, err = io.WriteString(w, "987654321")
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, archive.Close())
+	require.NoError(t, file.Close())
+
+	statuses := map[string]report.Status{}
+	for _, res := range VerifyArchive(&recorded) {
+		statuses[res.Path] = res.Status
+	}
+
+	assert.Equal(t, map[string]report.Status{
+		filepath.Join(path, "check.txt"):              report.StatusMismatch,
+		filepath.Join(path, "dir", "nested.txt"):      report.StatusModified,
+		filepath.Join(path, "dir", "sub", "deep.txt"): report.StatusMissing,
+		filepath.Join(path, "new.txt"):                report.StatusUnexpected,
+	}, statuses)
+
+	// Archives that can't be read fail every recorded file
+	require.NoError(t, os.WriteFile(path, []byte("not a zip"), 0o600))
+	results := VerifyArchive(&recorded)
+	require.Len(t, results, 3)
+	for _, res := range results {
+		assert.Equal(t, report.StatusError, res.Status)
+	}
+
+	require.NoError(t, os.Remove(path))
+	for _, res := range VerifyArchive(&recorded) {
+		assert.Equal(t, report.StatusMissing, res.Status)
+	}
+}
+
+func TestEntryPath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"a/b.txt":          "a/b.txt",
+		"/abs/path.txt":    "abs/path.txt",
+		"../../etc/passwd": "etc/passwd",
+		"a/../../b":        "b",
+		"./c//d":           "c/d",
+	} {
+		assert.Equal(t, expected, entryPath(name))
+	}
+}
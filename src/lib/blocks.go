@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// DefaultBlockSize is the block size used when none is specified - 64 MiB
+const DefaultBlockSize = 64 << 20
+
+/*
+BlockHash computes a CRC32 checksum for each block of data written to it. BlockHash
+implements io.Writer, allowing it to be used alongside other hashes in a single pass
+
+Note: It is recommended to use the NewBlockHash function to create BlockHash objects
+*/
+type BlockHash struct {
+	size    int64 // size of each block
+	written int64 // bytes written to the current block
+	current hash.Hash32
+	sums    []string // checksums for completed blocks
+}
+
+/*
+NewBlockHash creates a BlockHash with the given block size, a size less than one falls
+back to DefaultBlockSize
+*/
+func NewBlockHash(blockSize int64) *BlockHash {
+	if blockSize < 1 {
+		blockSize = DefaultBlockSize
+	}
+
+	return &BlockHash{size: blockSize, current: crc32.NewIEEE()}
+}
+
+func (b *BlockHash) Write(data []byte) (int, error) {
+	total := len(data)
+	for len(data) > 0 {
+		n := int64(len(data))
+		if left := b.size - b.written; n > left {
+			n = left
+		}
+
+		_, _ = b.current.Write(data[:n]) // never fails
+		b.written += n
+		data = data[n:]
+
+		if b.written == b.size {
+			b.sums = append(b.sums, hex.EncodeToString(b.current.Sum(nil)))
+			b.current.Reset()
+			b.written = 0
+		}
+	}
+
+	return total, nil
+}
+
+/*
+Size returns the block size
+*/
+func (b *BlockHash) Size() int64 {
+	return b.size
+}
+
+/*
+Sums returns the checksum for each block written so far, including the last (partial)
+block
+*/
+func (b *BlockHash) Sums() []string {
+	sums := append([]string{}, b.sums...)
+	if b.written > 0 {
+		sums = append(sums, hex.EncodeToString(b.current.Sum(nil)))
+	}
+
+	return sums
+}
+
+/*
+BlockChecksums computes checksums for the first `size` bytes of a file with each
+algorithm, along with the CRC32 checksum of each block of the given size - in a single
+pass over the file. Returns checksums in the same form as Checksum, followed by the
+checksum of each block
+
+Every byte has to pass through the block hash in order, the file is always read with
+buffered reads - irrespective of the read strategy, see ChecksumFile
+*/
+func BlockChecksums(
+	file *os.File, size int64, algos []string, blockSize int64,
+) (map[string]string, []string, error) {
+	blocks := NewBlockHash(blockSize)
+
+	sums, read, err := Checksum(io.NewSectionReader(file, 0, size), algos, blocks)
+	if err == nil && read != size {
+		err = errors.Wrapf(io.ErrUnexpectedEOF, "read %d of %d bytes", read, size)
+	}
+
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "(%s/BlockChecksums)", pkgName)
+	}
+
+	return sums, blocks.Sums(), nil
+}
+
+/*
+DiffBlocks compares block checksums recorded for a file against freshly computed ones,
+returning the byte ranges that differ. Adjacent differing blocks are merged into a
+single range. Blocks present in only one of the lists (i.e. the file was truncated or
+extended) are reported as differing, up to the larger of the two sizes
+
+The parameter `size` is the larger of the expected and actual file sizes, used to cap
+the end of the last range
+*/
+func DiffBlocks(expected, actual []string, blockSize, size int64) []writer.ByteRange {
+	count := len(expected)
+	if len(actual) > count {
+		count = len(actual)
+	}
+
+	var ranges []writer.ByteRange
+	for i := 0; i < count; i++ {
+		if i < len(expected) && i < len(actual) && expected[i] == actual[i] {
+			continue
+		}
+
+		start, end := int64(i)*blockSize, int64(i+1)*blockSize
+		if end > size {
+			end = size
+		}
+
+		// Merge with the previous range if adjacent
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End == start {
+			ranges[last].End = end
+			continue
+		}
+
+		ranges = append(ranges, writer.ByteRange{Start: start, End: end})
+	}
+
+	return ranges
+}
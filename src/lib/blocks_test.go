@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// crcHex returns the hex-encoded crc32 of data
+func crcHex(data []byte) string {
+	sum := crc32.NewIEEE()
+	_, _ = sum.Write(data)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func TestNewBlockHash(t *testing.T) {
+	assert.Equal(t, int64(DefaultBlockSize), NewBlockHash(0).Size())
+	assert.Equal(t, int64(DefaultBlockSize), NewBlockHash(-5).Size())
+	assert.Equal(t, int64(10), NewBlockHash(10).Size())
+	assert.Empty(t, NewBlockHash(10).Sums())
+}
+
+func TestBlockHash(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10) // 100 bytes
+
+	expected := []string{}
+	for i := 0; i < len(data); i += 32 {
+		end := i + 32
+		if end > len(data) {
+			end = len(data)
+		}
+
+		expected = append(expected, crcHex(data[i:end]))
+	}
+
+	// Results should not depend on how the data is split across writes
+	for _, chunk := range []int{1, 7, 32, 33, 100} {
+		blocks := NewBlockHash(32)
+		for i := 0; i < len(data); i += chunk {
+			end := i + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+
+			n, err := blocks.Write(data[i:end])
+			require.NoError(t, err)
+			assert.Equal(t, end-i, n)
+		}
+
+		assert.Equalf(t, expected, blocks.Sums(), "failed for chunk size %d", chunk)
+	}
+
+	path := filepath.Join(t.TempDir(), "blocks.bin")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	size := int64(len(data))
+	sums, blockSums, err := BlockChecksums(file, size, []string{AlgoCRC32}, 32)
+	require.NoError(t, err)
+	assert.Equal(t, expected, blockSums)
+
+	whole, _, _ := Checksum(bytes.NewReader(data), []string{AlgoCRC32})
+	assert.Equal(t, whole, sums)
+
+	// Files shorter than the expected size fail
+	_, _, err = BlockChecksums(file, size+1, []string{AlgoCRC32}, 32)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, _, err = BlockChecksums(file, size, []string{"crc16"}, 32)
+	assert.Error(t, err)
+}
+
+func TestDiffBlocks(t *testing.T) {
+	expected := []string{"a", "b", "c", "d", "e"}
+
+	for _, test := range []struct {
+		actual []string
+		size   int64
+		ranges []writer.ByteRange
+	}{
+		{actual: []string{"a", "b", "c", "d", "e"}, size: 45},
+		{
+			actual: []string{"a", "x", "c", "d", "e"}, size: 45,
+			ranges: []writer.ByteRange{{Start: 10, End: 20}},
+		},
+		{
+			// Adjacent blocks are merged, last block capped at the file size
+			actual: []string{"x", "x", "c", "x", "x"}, size: 45,
+			ranges: []writer.ByteRange{{Start: 0, End: 20}, {Start: 30, End: 45}},
+		},
+		{
+			// Truncated file
+			actual: []string{"a", "b", "c"}, size: 45,
+			ranges: []writer.ByteRange{{Start: 30, End: 45}},
+		},
+		{
+			// Extended file
+			actual: []string{"a", "b", "c", "d", "e", "f", "g"}, size: 65,
+			ranges: []writer.ByteRange{{Start: 50, End: 65}},
+		},
+	} {
+		assert.Equal(t, test.ranges, DiffBlocks(expected, test.actual, 10, test.size))
+	}
+}
@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+// cacheName is the name of the cache file, inside the directory from CacheDir
+const cacheName = "checksums.json"
+
+var (
+	userCacheDir = os.UserCacheDir // maps to os.UserCacheDir
+	readCache    = os.ReadFile     // maps to os.ReadFile
+)
+
+/*
+CacheKey identifies the contents of a file without reading it - the contents are
+assumed to be unchanged as long as the file (device, and inode), its size and mtime
+stay the same
+*/
+type CacheKey struct {
+	Dev     uint64
+	Ino     uint64
+	Size    int64
+	MtimeNs int64
+}
+
+func (key CacheKey) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", key.Dev, key.Ino, key.Size, key.MtimeNs)
+}
+
+func (key CacheKey) entry(algo string) string {
+	return key.String() + ":" + algo
+}
+
+/*
+cacheEntry contains a cached checksum, along with the path to the file it was computed
+for - letting entries for files that no longer exist be pruned
+*/
+type cacheEntry struct {
+	Path string
+	Sum  string
+}
+
+/*
+FileKey returns the CacheKey for a file, from the result of `os.Stat` or `os.Lstat`.
+Returns false if the key cannot be formed, either for files other than regular files,
+or on platforms where inode numbers are not available
+*/
+func FileKey(info os.FileInfo) (CacheKey, bool) {
+	if info == nil || !info.Mode().IsRegular() {
+		return CacheKey{}, false
+	}
+
+	return fileKey(info)
+}
+
+/*
+CachePath returns the path to the cache file - inside `$XDG_CACHE_HOME/crcgen`, or the
+cache directory for the platform if XDG_CACHE_HOME is not set
+*/
+func CachePath() (string, error) {
+	dir, err := userCacheDir()
+	if err != nil {
+		return "", errors.Wrapf(err, "(%s/CachePath)", pkgName)
+	}
+
+	return filepath.Join(dir, "crcgen", cacheName), nil
+}
+
+/*
+Cache maps CacheKey objects, along with a checksum algorithm, to the checksum for the
+file - letting checksums be reused across runs, instead of reading files again. Cache
+is safe for concurrent use, changes are only written to disk by Save
+
+Note: It is recommended to use the OpenCache function to create Cache objects
+*/
+type Cache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]cacheEntry
+	dirty   bool
+
+	// seen maps paths looked up in this run to their current CacheKey, as a string -
+	// empty for files that no longer exist
+	seen map[string]string
+}
+
+/*
+OpenCache loads the cache from a file, a missing or unreadable file results in an empty
+cache - the cache is only an optimization, and is rebuilt as files are hashed
+*/
+func OpenCache(path string) *Cache {
+	cache := &Cache{
+		path: path, entries: map[string]cacheEntry{}, seen: map[string]string{},
+	}
+
+	data, err := readCache(path)
+	if err == nil {
+		err = json.Unmarshal(data, &cache.entries)
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf(`(%s/OpenCache): ignoring cache "%s": %v`, pkgName, path, err)
+		cache.entries = map[string]cacheEntry{}
+	}
+
+	return cache
+}
+
+/*
+Get returns the cached checksum for a file with the algorithm, if any
+*/
+func (c *Cache) Get(key CacheKey, algo string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key.entry(algo)]
+	return entry.Sum, ok
+}
+
+/*
+Put adds the checksum for the file at the path with the algorithm to the cache, the
+file counts as looked up in this run - see Touch
+*/
+func (c *Cache) Put(path string, key CacheKey, algo, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seen[path] = key.String()
+	entry := cacheEntry{Path: path, Sum: sum}
+	if c.entries[key.entry(algo)] != entry {
+		c.entries[key.entry(algo)] = entry
+		c.dirty = true
+	}
+}
+
+/*
+Touch records the current version of the file at the path as looked up in this run,
+from the result of `os.Stat` - nil if the file no longer exists. Entries cached for
+other versions of the file are then removed by Prune
+*/
+func (c *Cache) Touch(path string, info os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seen[path] = ""
+	if key, ok := FileKey(info); ok {
+		c.seen[path] = key.String()
+	}
+}
+
+/*
+Prune removes entries for files looked up in this run that no longer exist, or that
+changed since they were cached - see Touch. Files that were not looked up are never
+checked, entries for files on volumes that are not mounted are kept
+*/
+func (c *Cache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, entry := range c.entries {
+		key, ok := c.seen[entry.Path]
+		if ok && (key == "" || !strings.HasPrefix(name, key+":")) {
+			delete(c.entries, name)
+			c.dirty = true
+		}
+	}
+}
+
+/*
+Save writes the cache to disk if it was changed, creating the parent directory if
+needed. The cache is written to a temporary file first, and renamed into place - a
+crash can't leave a corrupt cache behind. Each save uses a temporary file of its own,
+concurrent runs saving at once leave the cache of either run behind
+*/
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/Save)", pkgName)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return errors.Wrapf(err, "(%s/Save)", pkgName)
+	}
+
+	if err = writeTemp(c.path, data); err != nil {
+		return errors.Wrapf(err, "(%s/Save)", pkgName)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+/*
+writeTemp writes data to a new temporary file in the same directory as the path, and
+renames it into place. The temporary file is removed if any step fails
+*/
+func writeTemp(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(data)
+	if e := temp.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(temp.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	key, ok := FileKey(info)
+	require.True(t, ok)
+	assert.Equal(t, int64(len(checkInput)), key.Size)
+	assert.Equal(t, info.ModTime().UnixNano(), key.MtimeNs)
+	assert.NotZero(t, key.Ino)
+
+	// Modifying the file changes the key
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+
+	changed, ok := FileKey(info)
+	require.True(t, ok)
+	assert.NotEqual(t, key, changed)
+
+	// Directories, and missing info have no key
+	info, err = os.Stat(dir)
+	require.NoError(t, err)
+
+	for _, info := range []os.FileInfo{info, nil} {
+		_, ok = FileKey(info)
+		assert.False(t, ok)
+	}
+}
+
+func TestCachePath(t *testing.T) {
+	defer func() { userCacheDir = os.UserCacheDir }()
+
+	userCacheDir = func() (string, error) { return "/cache", nil }
+	path, err := CachePath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/cache", "crcgen", cacheName), path)
+
+	userCacheDir = func() (string, error) { return "", os.ErrNotExist }
+	_, err = CachePath()
+	assert.Error(t, err)
+}
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crcgen", cacheName)
+	key := CacheKey{Dev: 1, Ino: 2, Size: 9, MtimeNs: 3}
+
+	cache := OpenCache(path)
+	_, ok := cache.Get(key, AlgoCRC32)
+	assert.False(t, ok)
+
+	// Saving an unchanged cache does nothing
+	require.NoError(t, cache.Save())
+	assert.NoFileExists(t, path)
+
+	cache.Put("check.txt", key, AlgoCRC32, checkValues[AlgoCRC32])
+	require.NoError(t, cache.Save())
+
+	// Entries are keyed by algorithm, and persist across runs
+	cache = OpenCache(path)
+	sum, ok := cache.Get(key, AlgoCRC32)
+	assert.True(t, ok)
+	assert.Equal(t, checkValues[AlgoCRC32], sum)
+
+	_, ok = cache.Get(key, AlgoCRC32C)
+	assert.False(t, ok)
+
+	key.MtimeNs++
+	_, ok = cache.Get(key, AlgoCRC32)
+	assert.False(t, ok)
+}
+
+func TestOpenCache_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cacheName)
+	require.NoError(t, os.WriteFile(path, []byte("{bad"), 0o600))
+
+	// Corrupt caches are replaced, rather than failing
+	cache := OpenCache(path)
+	cache.Put("check.txt", CacheKey{}, AlgoCRC32, checkValues[AlgoCRC32])
+	require.NoError(t, cache.Save())
+
+	_, ok := OpenCache(path).Get(CacheKey{}, AlgoCRC32)
+	assert.True(t, ok)
+
+	// Unwritable paths fail to save
+	cache = OpenCache(filepath.Join(path, "nested", cacheName))
+	cache.Put("check.txt", CacheKey{}, AlgoCRC32, checkValues[AlgoCRC32])
+	assert.Error(t, cache.Save())
+}
+
+func TestCache_Prune(t *testing.T) {
+	dir := t.TempDir()
+	paths := make([]string, 4)
+	keys := make([]CacheKey, 4)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		require.NoError(t, os.WriteFile(paths[i], []byte(checkInput), 0o600))
+
+		info, err := os.Stat(paths[i])
+		require.NoError(t, err)
+
+		var ok bool
+		keys[i], ok = FileKey(info)
+		require.True(t, ok)
+	}
+
+	cache := OpenCache(filepath.Join(dir, cacheName))
+	for i := range paths {
+		cache.Put(paths[i], keys[i], AlgoCRC32, checkValues[AlgoCRC32])
+	}
+
+	require.NoError(t, cache.Save())
+
+	// Files looked up in a later run that were removed, or modified are pruned
+	require.NoError(t, os.Remove(paths[1]))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(paths[2], later, later))
+
+	cache = OpenCache(filepath.Join(dir, cacheName))
+	for _, path := range paths[:3] {
+		info, _ := os.Stat(path)
+		cache.Touch(path, info)
+	}
+
+	// Files that were not looked up are kept, even if they can't be found (i.e. on
+	// a volume that is not mounted)
+	require.NoError(t, os.Remove(paths[3]))
+
+	cache.Prune()
+	for i, expected := range []bool{true, false, false, true} {
+		_, ok := cache.Get(keys[i], AlgoCRC32)
+		assert.Equalf(t, expected, ok, `failed for "%s"`, paths[i])
+	}
+
+	// Concurrent runs saving at once use temporary files of their own
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			other := OpenCache(filepath.Join(dir, cacheName))
+			other.Put(paths[0], keys[0], AlgoCRC32C, fmt.Sprint(i))
+			errs[i] = other.Save()
+		}(i)
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
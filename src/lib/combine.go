@@ -0,0 +1,231 @@
+package lib
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ParallelThreshold is the minimum size of data (in bytes) for ChecksumAt to split it
+into segments that are hashed in parallel - smaller inputs are hashed in a single pass
+*/
+var ParallelThreshold int64 = 256 << 20
+
+// crcParams describes a CRC algorithm, as needed to combine checksums
+type crcParams struct {
+	poly  uint64 // reversed polynomial
+	width int    // size of the checksum, in bits
+}
+
+// combinable maps names of CRC algorithms to the parameters used to combine them
+var combinable = map[string]crcParams{
+	AlgoCRC32:     {poly: crc32.IEEE, width: 32},
+	AlgoCRC32C:    {poly: crc32.Castagnoli, width: 32},
+	AlgoCRC64ISO:  {poly: crc64.ISO, width: 64},
+	AlgoCRC64ECMA: {poly: crc64.ECMA, width: 64},
+}
+
+/*
+Combinable checks if checksums for each of the algorithms can be computed separately for
+segments of data, and combined later - true only for CRC algorithms
+*/
+func Combinable(algos []string) bool {
+	for _, algo := range algos {
+		if _, ok := combinable[strings.ToLower(strings.TrimSpace(algo))]; !ok {
+			return false
+		}
+	}
+
+	return len(algos) > 0
+}
+
+/*
+CombineCRC returns the checksum of two segments of data joined together, given the
+checksum of each segment, and the length of the second segment. The result is the same
+as computing the checksum over the joined data in a single pass
+
+Uses the approach from zlib's `crc32_combine` - appending `len2` zero bytes to the first
+segment is a linear operation over GF(2), applied to `crc1` by repeated squaring of the
+matrix for a single zero bit
+*/
+func CombineCRC(algo string, crc1, crc2 uint64, len2 int64) (uint64, error) {
+	params, ok := combinable[strings.ToLower(strings.TrimSpace(algo))]
+	if !ok {
+		return 0, errors.Wrapf(errUnknownAlgo, "(%s/CombineCRC): %s", pkgName, algo)
+	}
+
+	if len2 <= 0 {
+		return crc1, nil
+	}
+
+	// Operator for a single zero bit
+	odd := make([]uint64, params.width)
+	odd[0] = params.poly
+	for n, row := 1, uint64(1); n < params.width; n, row = n+1, row<<1 {
+		odd[n] = row
+	}
+
+	even := gf2Square(odd) // two zero bits
+	odd = gf2Square(even)  // four zero bits
+	for ; len2 != 0; len2 >>= 1 {
+		// First pass applies the operator for a single zero byte
+		even = gf2Square(odd)
+		if len2&1 != 0 {
+			crc1 = gf2Times(even, crc1)
+		}
+
+		if len2 >>= 1; len2 == 0 {
+			break
+		}
+
+		odd = gf2Square(even)
+		if len2&1 != 0 {
+			crc1 = gf2Times(odd, crc1)
+		}
+	}
+
+	return crc1 ^ crc2, nil
+}
+
+// gf2Times multiplies a matrix with a vector, over GF(2)
+func gf2Times(mat []uint64, vec uint64) uint64 {
+	var sum uint64
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+
+	return sum
+}
+
+// gf2Square returns the square of a matrix, over GF(2)
+func gf2Square(mat []uint64) []uint64 {
+	square := make([]uint64, len(mat))
+	for i := range mat {
+		square[i] = gf2Times(mat, mat[i])
+	}
+
+	return square
+}
+
+/*
+ChecksumAt computes checksums for the first `size` bytes of the reader, with each
+algorithm. Returns checksums in the same form as Checksum
+
+Inputs of at least ParallelThreshold bytes are split into a segment per worker, hashed
+concurrently, with the results combined into the exact checksums of a serial pass. This
+is only possible for CRC algorithms - if any algorithm cannot be combined, all data is
+hashed in a single pass
+*/
+func ChecksumAt(
+	reader io.ReaderAt, size int64, algos []string, workers int,
+) (map[string]string, error) {
+	if size < ParallelThreshold || workers < 2 || !Combinable(algos) {
+		sums, read, err := Checksum(io.NewSectionReader(reader, 0, size), algos)
+		if err == nil && read != size {
+			err = errors.Wrapf(io.ErrUnexpectedEOF, "read %d of %d bytes", read, size)
+		}
+
+		return sums, errors.Wrapf(err, "(%s/ChecksumAt)", pkgName)
+	}
+
+	segment := (size + int64(workers) - 1) / int64(workers)
+	results := make([]map[string]uint64, workers)
+	lengths := make([]int64, workers)
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		start := int64(i) * segment
+		lengths[i] = segment
+		if start+segment > size {
+			lengths[i] = size - start // last segment can be shorter
+		}
+
+		wg.Add(1)
+		go func(i int, start int64) {
+			defer wg.Done()
+			results[i], errs[i] = segmentCRC(reader, start, lengths[i], algos)
+		}(i, start)
+	}
+
+	wg.Wait()
+
+	combined := results[0]
+	for i := range errs {
+		if errs[i] != nil {
+			return nil, errors.Wrapf(errs[i], "(%s/ChecksumAt)", pkgName)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		for algo, crc := range results[i] {
+			// Errors are not possible here, since algorithms are combinable
+			combined[algo], _ = CombineCRC(algo, combined[algo], crc, lengths[i])
+		}
+	}
+
+	sums := make(map[string]string, len(combined))
+	for algo, crc := range combined {
+		if combinable[algo].width == 32 {
+			sums[algo] = fmt.Sprintf("%08x", crc)
+		} else {
+			sums[algo] = fmt.Sprintf("%016x", crc)
+		}
+	}
+
+	return sums, nil
+}
+
+/*
+segmentCRC computes CRC checksums for a segment of data, segments can be empty
+*/
+func segmentCRC(
+	reader io.ReaderAt, start, length int64, algos []string,
+) (map[string]uint64, error) {
+	hashes, err := NewHashes(algos)
+	if err != nil {
+		return nil, err
+	}
+
+	if length < 0 {
+		length = 0
+	}
+
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+
+	section := io.NewSectionReader(reader, start, length)
+	read, err := io.Copy(io.MultiWriter(writers...), section)
+	if err != nil {
+		return nil, err
+	} else if read != length {
+		return nil, errors.Wrapf(
+			io.ErrUnexpectedEOF, "segment at %d: read %d of %d bytes", start, read, length,
+		)
+	}
+
+	crcs := make(map[string]uint64, len(hashes))
+	for algo, h := range hashes {
+		switch h := h.(type) {
+		case hash.Hash32:
+			crcs[algo] = uint64(h.Sum32())
+		case hash.Hash64:
+			crcs[algo] = h.Sum64()
+		}
+	}
+
+	return crcs, nil
+}
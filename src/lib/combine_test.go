@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"hash"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crcAlgos contains names of all combinable algorithms
+var crcAlgos = []string{AlgoCRC32, AlgoCRC32C, AlgoCRC64ISO, AlgoCRC64ECMA}
+
+// sum returns the checksum for data as an unsigned integer
+func sum(t *testing.T, algo string, data []byte) uint64 {
+	t.Helper()
+
+	hashes, err := NewHashes([]string{algo})
+	require.NoError(t, err)
+
+	h := hashes[algo]
+	_, _ = h.Write(data)
+	if h32, ok := h.(hash.Hash32); ok {
+		return uint64(h32.Sum32())
+	}
+
+	return h.(hash.Hash64).Sum64()
+}
+
+func TestCombinable(t *testing.T) {
+	assert.True(t, Combinable(crcAlgos))
+	assert.True(t, Combinable([]string{" CRC32C "}))
+	assert.False(t, Combinable([]string{AlgoCRC32, AlgoSHA1}))
+	assert.False(t, Combinable(nil))
+}
+
+func TestCombineCRC(t *testing.T) {
+	data := make([]byte, 10_000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, algo := range crcAlgos {
+		expected := sum(t, algo, data)
+		for _, split := range []int{0, 1, 7, 4096, len(data) - 1, len(data)} {
+			one, two := data[:split], data[split:]
+
+			crc, err := CombineCRC(
+				algo, sum(t, algo, one), sum(t, algo, two), int64(len(two)),
+			)
+
+			require.NoError(t, err)
+			assert.Equalf(t, expected, crc, "failed for %s split at %d", algo, split)
+		}
+	}
+
+	_, err := CombineCRC(AlgoSHA256, 0, 0, 1)
+	assert.True(t, IsUnknownAlgoErr(err))
+}
+
+func TestChecksumAt(t *testing.T) {
+	defer func(threshold int64) { ParallelThreshold = threshold }(ParallelThreshold)
+
+	data := bytes.Repeat([]byte(checkInput), 1000)
+	reader := bytes.NewReader(data)
+
+	expected, _, err := Checksum(bytes.NewReader(data), crcAlgos)
+	require.NoError(t, err)
+
+	// Both serial, and parallel runs must match a single pass with Checksum
+	for _, threshold := range []int64{1 << 40, 1} {
+		ParallelThreshold = threshold
+		for _, workers := range []int{1, 3, 8, 32} {
+			sums, err := ChecksumAt(reader, int64(len(data)), crcAlgos, workers)
+			require.NoError(t, err)
+			assert.Equalf(t, expected, sums, "failed for %d workers", workers)
+		}
+	}
+
+	// More workers than bytes leaves some segments empty
+	sums, err := ChecksumAt(strings.NewReader(checkInput), 9, crcAlgos, 32)
+	require.NoError(t, err)
+	for algo, sum := range sums {
+		assert.Equal(t, checkValues[algo], sum)
+	}
+
+	// Algorithms that can't be combined fall back to a single pass
+	sums, err = ChecksumAt(strings.NewReader(checkInput), 9, []string{AlgoSHA1}, 4)
+	require.NoError(t, err)
+	assert.Equal(t, checkValues[AlgoSHA1], sums[AlgoSHA1])
+
+	// Data shorter than the expected size should fail, in both modes
+	for _, algos := range [][]string{crcAlgos, {AlgoSHA1}} {
+		_, err = ChecksumAt(strings.NewReader(checkInput), 100, algos, 4)
+		assert.Error(t, err)
+	}
+}
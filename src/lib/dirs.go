@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+var readDir = os.ReadDir // maps to os.ReadDir
+
+/*
+RecordDirs adds every directory present on disk under the root to the tree - including
+empty directories, which never hold files and are skipped by WalkPath. The mode and
+mtime of each directory are recorded as well, letting VerifyDirs detect directories
+that went missing or appeared since
+
+Each directory keeps its own mtime as DirMod. Directories that were empty keep it as
+LastMod too, directories with files keep the latest mtime of their contents. Digests
+are updated for directories that were added to
+
+Directories that can't be read get the error recorded, and the remaining directories
+are still recorded - the first error is returned
+*/
+func RecordDirs(root *writer.DirInfo) error {
+	_, err := recordDir(root)
+	return errors.Wrapf(err, "(%s/RecordDirs)", pkgName)
+}
+
+/*
+recordDir records the mode of a directory, and adds directories found on disk that are
+missing from it, recursively. Returns true if a directory was added anywhere within it,
+along with the first error. Directories representing archives are skipped, they do not
+exist on disk
+*/
+func recordDir(dir *writer.DirInfo) (bool, error) {
+	info, err := statPath(dir.Path)
+	if err != nil {
+		dir.Error = ErrorKind(err)
+		return false, err
+	}
+
+	mode := info.Mode() & modeBits
+	dir.Mode, dir.DirMod = &mode, info.ModTime().Unix()
+	if dir.LastMod == 0 {
+		dir.LastMod = dir.DirMod
+	}
+
+	// Directories already in the tree are recorded, even if the rest can't be listed
+	entries, err := readDir(dir.Path)
+	if err != nil {
+		dir.Error = ErrorKind(err)
+	}
+
+	known := make(map[string]bool, len(dir.Dirs))
+	for i := range dir.Dirs {
+		known[dir.Dirs[i].Name()] = true
+	}
+
+	added := false
+	for _, entry := range entries {
+		if entry.IsDir() && !known[entry.Name()] {
+			path := filepath.Join(dir.Path, entry.Name())
+			dir.Dirs = append(dir.Dirs, writer.DirInfo{Path: path})
+			added = true
+		}
+	}
+
+	for i := range dir.Dirs {
+		if dir.Dirs[i].Archive {
+			continue
+		}
+
+		nested, e := recordDir(&dir.Dirs[i])
+		if err == nil {
+			err = e
+		}
+
+		added = added || nested
+	}
+
+	// The digest includes names of nested directories, and has to be calculated again
+	if added {
+		dir.Digest = ""
+		_ = dir.CalcDigest()
+	}
+
+	return added, err
+}
+
+/*
+VerifyDirs checks the directories recorded in the tree against the disk, returning a
+result for each directory that failed - recorded directories that are missing (or are
+no longer directories), and empty directories that were not recorded. With `metadata`,
+directories with a changed mode are returned as well, with the change as drift
+
+Unexpected directories are only reported for trees made with RecordDirs, trees without
+a recorded mode for the root directory never recorded empty directories - nor did
+directories recorded with an error. Directories that can't be read are returned as
+errors. Directories representing archives are skipped, see VerifyArchive
+*/
+func VerifyDirs(root *writer.DirInfo, metadata bool) []report.Result {
+	var (
+		results  []report.Result
+		recorded = map[string]bool{}
+		failed   = map[string]bool{} // directories recorded with an error
+	)
+
+	inArchive := map[*writer.DirInfo]bool{}
+	for _, archive := range root.Archives() {
+		for _, dir := range archive.AllDirs() {
+			inArchive[dir] = true
+		}
+	}
+
+	for _, dir := range root.AllDirs() {
+		if inArchive[dir] {
+			continue
+		}
+
+		recorded[dir.Path] = true
+		failed[dir.Path] = dir.Error != ""
+
+		res := report.Result{Path: dir.Path, Status: report.StatusOK, Dir: true}
+		info, err := statPath(dir.Path)
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()):
+			res.Status = report.StatusMissing
+
+		case err != nil:
+			res.Status, res.Error = report.StatusError, ErrorKind(err)
+
+		case metadata && dir.Mode != nil && info.Mode()&modeBits != *dir.Mode:
+			current := writer.Metadata{Mode: info.Mode() & modeBits, UID: -1, GID: -1}
+			res.Drift = MetadataDrift(&writer.Metadata{Mode: *dir.Mode}, &current)
+		}
+
+		if res.Status != report.StatusOK || len(res.Drift) > 0 {
+			results = append(results, res)
+		}
+	}
+
+	if root.Mode == nil {
+		return results
+	}
+
+	return append(results, unexpectedDirs(root.Path, recorded, failed)...)
+}
+
+/*
+unexpectedDirs walks the directory at the path, returning a result for each empty
+directory that is not recorded. Directories that can't be read are returned as errors,
+directories that failed to be recorded are not walked
+*/
+func unexpectedDirs(path string, recorded, failed map[string]bool) []report.Result {
+	var (
+		results []report.Result
+		dirs    []string           // directories found, in the order they were walked
+		entries = map[string]int{} // number of entries in each directory
+	)
+
+	_ = filepathWalk(path, func(path string, info fs.FileInfo, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil // already reported if it was recorded
+
+		case err != nil:
+			results = append(results, report.Result{
+				Path: path, Status: report.StatusError, Error: ErrorKind(err),
+			})
+
+			entries[path] = -1 // contents are not known, not reported as empty
+			return nil
+		}
+
+		entries[filepath.Dir(path)]++
+		if info.IsDir() && failed[path] {
+			entries[path] = -1 // contents were never recorded
+			return filepath.SkipDir
+		} else if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+
+		return nil
+	})
+
+	for _, dir := range dirs {
+		if entries[dir] == 0 && !recorded[dir] {
+			results = append(results, report.Result{
+				Path: dir, Status: report.StatusUnexpected, Dir: true,
+			})
+		}
+	}
+
+	return results
+}
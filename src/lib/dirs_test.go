@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// dirTree creates a tree with a file in `data`, and empty directories `spool` and
+// `spool/in`. Returns the root, along with a DirInfo listing only the file, as made by
+// walking files
+func dirTree(t *testing.T) (string, writer.DirInfo) {
+	t.Helper()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "data"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "spool", "in"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data", "a"), nil, 0o644))
+
+	file := writer.FileInfo{Path: filepath.Join(root, "data", "a")}
+	data := writer.NewDir("data", root, nil, []writer.FileInfo{file}, 100)
+
+	return root, writer.NewDir("", root, []writer.DirInfo{data}, nil, 0)
+}
+
+func TestRecordDirs(t *testing.T) {
+	defer func() { readDir = os.ReadDir }()
+
+	root, tree := dirTree(t)
+	digest := tree.CalcDigest()
+
+	require.NoError(t, RecordDirs(&tree))
+	assert.NotEqual(t, digest, tree.Digest) // digest covers the new directories
+
+	dirs := tree.AllDirs()
+	require.Len(t, dirs, 4)
+
+	paths := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		paths = append(paths, dir.Path)
+		require.NotNil(t, dir.Mode)
+		assert.NotZero(t, *dir.Mode&fs.ModePerm)
+		assert.NotZero(t, dir.LastMod)
+		assert.NotZero(t, dir.DirMod)
+		assert.Empty(t, dir.Error)
+	}
+
+	assert.Equal(t, []string{
+		root,
+		filepath.Join(root, "data"),
+		filepath.Join(root, "spool"),
+		filepath.Join(root, "spool", "in"),
+	}, paths)
+
+	// LastMod is kept for directories with files, with their own mtime apart
+	assert.Equal(t, int64(100), dirs[1].LastMod)
+	assert.NotEqual(t, int64(100), dirs[1].DirMod)
+	assert.Equal(t, dirs[3].DirMod, dirs[3].LastMod)
+	assert.Equal(t, fs.FileMode(0o700), *dirs[3].Mode)
+
+	// Recording again doesn't add anything
+	require.NoError(t, RecordDirs(&tree))
+	assert.Len(t, tree.AllDirs(), 4)
+
+	// Directories representing archives are files on disk, and are skipped
+	archive := filepath.Join(root, "data", "a")
+	tree.Dirs[0].Dirs = append(
+		tree.Dirs[0].Dirs, writer.DirInfo{Path: archive, Archive: true},
+	)
+	require.NoError(t, RecordDirs(&tree))
+	assert.Nil(t, tree.Dirs[0].Dirs[0].Mode)
+
+	// Directories that can't be read get the error, the rest are still recorded
+	root, tree = dirTree(t)
+	spool := filepath.Join(root, "spool")
+
+	readDir = func(path string) ([]fs.DirEntry, error) {
+		if path == spool {
+			return nil, os.ErrPermission
+		}
+
+		return os.ReadDir(path)
+	}
+
+	assert.ErrorIs(t, RecordDirs(&tree), os.ErrPermission)
+
+	dirs = tree.AllDirs()
+	require.Len(t, dirs, 3) // nested directories of spool are not known
+	for _, dir := range dirs {
+		require.NotNilf(t, dir.Mode, `failed for "%s"`, dir.Path)
+		if dir.Path == spool {
+			assert.Equal(t, "permission denied", dir.Error)
+		} else {
+			assert.Empty(t, dir.Error)
+		}
+	}
+}
+
+func TestVerifyDirs(t *testing.T) {
+	root, tree := dirTree(t)
+
+	// Trees without recorded directories don't report unexpected directories
+	assert.Empty(t, VerifyDirs(&tree, true))
+
+	require.NoError(t, RecordDirs(&tree))
+	assert.Empty(t, VerifyDirs(&tree, true))
+
+	require.NoError(t, os.Remove(filepath.Join(root, "spool", "in")))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "new", "empty"), 0o755))
+	require.NoError(t, os.Chmod(filepath.Join(root, "data"), 0o777))
+
+	assert.Equal(t, []report.Result{
+		{
+			Path: filepath.Join(root, "data"), Status: report.StatusOK,
+			Drift: []string{"mode -rwxr-xr-x -> -rwxrwxrwx"}, Dir: true,
+		},
+		{Path: filepath.Join(root, "spool", "in"), Status: report.StatusMissing, Dir: true},
+		{
+			Path: filepath.Join(root, "new", "empty"), Status: report.StatusUnexpected,
+			Dir: true,
+		},
+	}, VerifyDirs(&tree, true))
+
+	// Drift is only reported with `metadata`
+	assert.Len(t, VerifyDirs(&tree, false), 2)
+
+	// A mode of zero is still a recorded mode
+	*tree.Mode = 0
+	assert.Len(t, VerifyDirs(&tree, false), 2)
+
+	// Directories within archives don't exist on disk, and are skipped
+	tree.Dirs = append(tree.Dirs, writer.DirInfo{
+		Path:    filepath.Join(root, "data.zip"),
+		Archive: true,
+		Dirs:    []writer.DirInfo{{Path: filepath.Join(root, "data.zip", "nested")}},
+	})
+
+	assert.Len(t, VerifyDirs(&tree, false), 2)
+}
+
+func TestVerifyDirs_Failed(t *testing.T) {
+	defer func() { readDir = os.ReadDir }()
+
+	root, tree := dirTree(t)
+	spool := filepath.Join(root, "spool")
+	readDir = func(path string) ([]fs.DirEntry, error) {
+		if path == spool {
+			return nil, os.ErrPermission
+		}
+
+		return os.ReadDir(path)
+	}
+
+	require.Error(t, RecordDirs(&tree))
+
+	// Directories within one that failed to be recorded are never unexpected
+	require.NoError(t, os.MkdirAll(filepath.Join(spool, "out"), 0o700))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "new"), 0o700))
+
+	assert.Equal(t, []report.Result{
+		{Path: filepath.Join(root, "new"), Status: report.StatusUnexpected, Dir: true},
+	}, VerifyDirs(&tree, false))
+}
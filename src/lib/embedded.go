@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Custom errors
+*/
+var (
+	// errNoEmbedded indicates that a file does not carry a known embedded checksum
+	errNoEmbedded = fmt.Errorf("(%s): no embedded checksum", pkgName)
+
+	// errEmbeddedCRC indicates that an embedded checksum did not match the data
+	errEmbeddedCRC = fmt.Errorf("(%s): embedded checksum mismatch", pkgName)
+)
+
+// Magic bytes identifying formats carrying embedded checksums
+var (
+	magicZip  = []byte("PK\x03\x04")
+	magicGzip = []byte{0x1f, 0x8b}
+	magicPNG  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+/*
+IsNoEmbeddedErr checks if an error was caused because a file does not carry an embedded
+checksum in a known format
+*/
+func IsNoEmbeddedErr(err error) bool {
+	return errors.Is(err, errNoEmbedded)
+}
+
+/*
+IsEmbeddedCRCErr checks if an error was caused because the checksum embedded in a file
+did not match its contents, i.e. the file is corrupt
+*/
+func IsEmbeddedCRCErr(err error) bool {
+	return errors.Is(err, errEmbeddedCRC)
+}
+
+/*
+CheckEmbedded recomputes checksums embedded within a file, and validates them against
+the stored values. Supported formats are zip (CRC32 of each entry), gzip (CRC32 and size
+in the trailer of each member), and PNG (CRC32 of each chunk). The format is detected
+from the contents of the file
+
+Returns an error that can be checked with IsEmbeddedCRCErr if a checksum does not
+match, or IsNoEmbeddedErr if the file is not in a supported format
+*/
+func CheckEmbedded(path string) error {
+	file, err := openPath(path)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/CheckEmbedded)", pkgName)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(len(magicPNG)) // shorter files return whatever is present
+
+	switch {
+	case bytes.HasPrefix(magic, magicZip):
+		err = checkZip(path)
+	case bytes.HasPrefix(magic, magicGzip):
+		err = checkGzip(reader)
+	case bytes.HasPrefix(magic, magicPNG):
+		err = checkPNG(reader)
+	default:
+		err = errNoEmbedded
+	}
+
+	return errors.Wrapf(err, "(%s/CheckEmbedded)", pkgName)
+}
+
+/*
+checkZip reads each entry in a zip archive till the end - the zip reader validates the
+CRC32 stored in the central directory once an entry is read completely
+*/
+func checkZip(path string) error {
+	reader, err := openZip(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	for _, file := range reader.File {
+		if err = checkZipEntry(file); errors.Is(err, zip.ErrChecksum) {
+			return errors.Wrapf(errEmbeddedCRC, "zip entry %s", file.Name)
+		} else if err != nil {
+			return errors.Wrapf(err, "zip entry %s", file.Name)
+		}
+	}
+
+	return nil
+}
+
+func checkZipEntry(file *zip.File) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
+
+/*
+checkGzip decompresses a gzip stream - the gzip reader validates the CRC32 and size in
+the trailer of each member once it has been read
+*/
+func checkGzip(stream io.Reader) error {
+	reader, err := gzip.NewReader(stream)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	_, err = io.Copy(io.Discard, reader)
+	if errors.Is(err, gzip.ErrChecksum) {
+		return errors.Wrap(errEmbeddedCRC, "gzip trailer")
+	}
+
+	return err
+}
+
+/*
+checkPNG validates the CRC32 of each chunk in a PNG image. The CRC covers the chunk type
+and data, and is stored after the data
+*/
+func checkPNG(reader io.Reader) error {
+	if _, err := io.CopyN(io.Discard, reader, int64(len(magicPNG))); err != nil {
+		return err
+	}
+
+	var header [8]byte // length, and type of the chunk
+	for offset := int64(len(magicPNG)); ; {
+		if _, err := io.ReadFull(reader, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "png chunk at offset %d", offset)
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunk := string(header[4:])
+
+		crc := crc32.NewIEEE()
+		_, _ = crc.Write(header[4:])
+		if _, err := io.CopyN(crc, reader, length); err != nil {
+			return errors.Wrapf(err, "png chunk %s at offset %d", chunk, offset)
+		}
+
+		var stored [4]byte
+		if _, err := io.ReadFull(reader, stored[:]); err != nil {
+			return errors.Wrapf(err, "png chunk %s at offset %d", chunk, offset)
+		}
+
+		if binary.BigEndian.Uint32(stored[:]) != crc.Sum32() {
+			return errors.Wrapf(errEmbeddedCRC, "png chunk %s at offset %d", chunk, offset)
+		}
+
+		offset += int64(len(header)) + length + int64(len(stored))
+	}
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// embeddedFiles creates a valid file in each supported format, returns the contents
+// mapped to the file names
+func embeddedFiles(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	var zipData bytes.Buffer
+	archive := zip.NewWriter(&zipData)
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: "check.txt", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = w.Write([]byte(checkInput))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	var gzData bytes.Buffer
+	gz := gzip.NewWriter(&gzData)
+	_, err = gz.Write([]byte(checkInput))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	var pngData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 4, 4))))
+
+	return map[string][]byte{
+		"file.zip": zipData.Bytes(),
+		"file.gz":  gzData.Bytes(),
+		"file.png": pngData.Bytes(),
+	}
+}
+
+// writeEmbedded writes contents to a file in a temporary directory
+func writeEmbedded(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	return path
+}
+
+func TestIsEmbeddedErrs(t *testing.T) {
+	assert.True(t, IsNoEmbeddedErr(errors.Wrap(errNoEmbedded, "test")))
+	assert.False(t, IsNoEmbeddedErr(errEmbeddedCRC))
+	assert.True(t, IsEmbeddedCRCErr(errors.Wrap(errEmbeddedCRC, "test")))
+	assert.False(t, IsEmbeddedCRCErr(errNoEmbedded))
+}
+
+func TestCheckEmbedded(t *testing.T) {
+	for name, data := range embeddedFiles(t) {
+		assert.NoErrorf(t, CheckEmbedded(writeEmbedded(t, name, data)), name)
+	}
+
+	for _, data := range []string{"", "PK", "plain text file"} {
+		err := CheckEmbedded(writeEmbedded(t, "file", []byte(data)))
+		assert.Truef(t, IsNoEmbeddedErr(err), `failed for "%s"`, data)
+	}
+
+	err := CheckEmbedded(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+}
+
+func TestCheckEmbedded_Corrupt(t *testing.T) {
+	files := embeddedFiles(t)
+
+	// Flip a bit in the stored (uncompressed) data of the zip entry
+	zipData := files["file.zip"]
+	zipData[bytes.Index(zipData, []byte(checkInput))] ^= 1
+
+	// Flip a bit in the CRC32 stored in the gzip trailer
+	gzData := files["file.gz"]
+	gzData[len(gzData)-8] ^= 1
+
+	// Flip a bit in the data of the IHDR chunk - width of the image
+	pngData := files["file.png"]
+	pngData[bytes.Index(pngData, []byte("IHDR"))+4] ^= 1
+
+	for name, data := range files {
+		err := CheckEmbedded(writeEmbedded(t, name, data))
+		assert.Truef(t, IsEmbeddedCRCErr(err), `failed for "%s": %v`, name, err)
+	}
+}
+
+func TestCheckEmbedded_Truncated(t *testing.T) {
+	// Truncated files are errors, but not checksum mismatches
+	for name, data := range embeddedFiles(t) {
+		err := CheckEmbedded(writeEmbedded(t, name, data[:len(data)-6]))
+		assert.Errorf(t, err, `failed for "%s"`, name)
+		assert.Falsef(t, IsEmbeddedCRCErr(err), `failed for "%s"`, name)
+	}
+}
@@ -1,18 +1,34 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
 )
 
 const pkgName = "lib"
 
-// errInvalidPath indicates that the root path was invalid
-var errInvalidPath = fmt.Errorf("(%s): invalid path", pkgName)
+/*
+Custom errors
+*/
+var (
+	// errInvalidPath indicates that the root path was invalid
+	errInvalidPath = fmt.Errorf("(%s): invalid path", pkgName)
+
+	// errPartial indicates that the walk completed, but skipped files due to errors
+	errPartial = fmt.Errorf("(%s): walk completed with errors", pkgName)
+
+	// errInvalidPolicy indicates that an error policy could not be parsed
+	errInvalidPolicy = fmt.Errorf("(%s): invalid error policy", pkgName)
+)
 
 var filepathWalk = filepath.Walk
 
@@ -23,30 +39,170 @@ func IsInvalidPathErr(err error) bool {
 	return errors.Is(err, errInvalidPath)
 }
 
+/*
+IsPartialErr indicates if the walk completed, but one or more files were skipped because
+of errors
+*/
+func IsPartialErr(err error) bool {
+	return errors.Is(err, errPartial)
+}
+
+/*
+IsInvalidPolicyErr indicates if an error policy could not be parsed
+*/
+func IsInvalidPolicyErr(err error) bool {
+	return errors.Is(err, errInvalidPolicy)
+}
+
 func PathExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
 
+/*
+ErrorPolicy decides how WalkPath reacts to errors encountered for individual files
+
+ErrorPolicy implements the `pflag.Value` interface, and can be used directly as a flag
+*/
+type ErrorPolicy int
+
+const (
+	// OnErrorAbort stops the walk on the first error
+	OnErrorAbort ErrorPolicy = iota
+
+	// OnErrorContinue logs the error, and moves on to the next file
+	OnErrorContinue
+)
+
+// policyNames maps each ErrorPolicy to its name
+var policyNames = map[ErrorPolicy]string{
+	OnErrorAbort:    "abort",
+	OnErrorContinue: "continue",
+}
+
+/*
+ParseErrorPolicy parses the name of an ErrorPolicy, names are case-insensitive. Use
+IsInvalidPolicyErr to check for unknown names
+*/
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	for policy, val := range policyNames {
+		if strings.EqualFold(strings.TrimSpace(name), val) {
+			return policy, nil
+		}
+	}
+
+	return OnErrorAbort, errors.Wrapf(errInvalidPolicy, "(%s/ParseErrorPolicy)", pkgName)
+}
+
+func (p ErrorPolicy) String() string {
+	return policyNames[p]
+}
+
+func (p *ErrorPolicy) Set(name string) error {
+	policy, err := ParseErrorPolicy(name)
+	if err == nil {
+		*p = policy
+	}
+
+	return err
+}
+
+func (*ErrorPolicy) Type() string {
+	return "policy"
+}
+
+/*
+ErrorKind returns a short, human-readable description for an error encountered while
+processing a file - meant to be recorded alongside the file in the output
+*/
+func ErrorKind(err error) string {
+	var unreadable *UnreadableError
+
+	switch {
+	case err == nil:
+		return ""
+
+	case errors.Is(err, fs.ErrNotExist):
+		return "vanished during scan"
+
+	case errors.Is(err, fs.ErrPermission):
+		return "permission denied"
+
+	case errors.As(err, &unreadable):
+		return unreadable.Error() // lists the unreadable parts
+
+	case errors.Is(err, syscall.EIO):
+		return "I/O error"
+
+	case IsUnstableErr(err):
+		return "changed while being read"
+
+	case IsSpecialErr(err):
+		return "special file, not read"
+
+	default:
+		return err.Error()
+	}
+}
+
+/*
+isCancelled checks if an error was caused by a context being cancelled, or its deadline
+passing
+*/
+func isCancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 /*
 WalkPath walks through the root directory, running the input `walkFunc` function on all
 files. If the root path is invalid, a custom error is returned, use IsInvalidPathErr
 to check for this.
 
-Note: The parameter `walkFunc` will be selectively run on files
+With the OnErrorAbort policy, the walk stops on the first error. With OnErrorContinue,
+errors encountered while walking are passed to `walkFunc` (so they can be recorded),
+errors returned by `walkFunc` are logged, and the walk moves on. If any errors were
+skipped over, the walk returns an error that can be checked with IsPartialErr. With
+either policy, `walkFunc` returning context.Canceled or context.DeadlineExceeded stops
+the walk - returning the error
+
+Note: The parameter `walkFunc` will be selectively run on files. The only exception
+being errors passed with the OnErrorContinue policy, where `info` can be a directory,
+or nil if the path could not be read at all. Special files (FIFOs, sockets, devices)
+are passed as well - use FileType to tell them apart, and never open them blindly
 */
-func WalkPath(path string, walkFunc filepath.WalkFunc) error {
+func WalkPath(path string, policy ErrorPolicy, walkFunc filepath.WalkFunc) error {
 	if !PathExists(path) {
 		return errInvalidPath
 	}
 
+	failed := 0 // number of files skipped due to errors
 	err := filepathWalk(path, func(path string, info fs.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+		switch {
+		case err != nil && policy == OnErrorAbort:
 			return err
+
+		case err == nil && info.IsDir():
+			return nil
+		}
+
+		e := walkFunc(path, info, err)
+		if policy == OnErrorAbort || isCancelled(e) {
+			return e
+		} else if e == nil {
+			e = err // `walkFunc` might have consumed the error after recording it
 		}
 
-		return walkFunc(path, info, err)
+		if e != nil {
+			failed++
+			logger.Warnf(`(%s/WalkPath): skipping "%s": %s`, pkgName, path, ErrorKind(e))
+		}
+
+		return nil
 	})
 
+	if err == nil && failed > 0 {
+		err = errPartial
+	}
+
 	return errors.Wrapf(err, "(%s/WalkPath)", pkgName)
 }
@@ -1,14 +1,17 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func reset() {
@@ -54,9 +57,9 @@ func TestWalkPath_PathError(t *testing.T) {
 		"/root",
 	} {
 		if PathExists(path) {
-			assert.NoError(t, WalkPath(path, walkFunc))
+			assert.NoError(t, WalkPath(path, OnErrorAbort, walkFunc))
 		} else {
-			err := WalkPath(path, walkFunc)
+			err := WalkPath(path, OnErrorAbort, walkFunc)
 
 			assert.Error(t, err)
 			assert.True(t, IsInvalidPathErr(err))
@@ -72,7 +75,7 @@ func TestWalkPath_WalkError(t *testing.T) {
 		return fmt.Errorf("(%s/TestWalk_Error): test error", pkgName)
 	}
 
-	assert.Error(t, WalkPath(".", nil))
+	assert.Error(t, WalkPath(".", OnErrorAbort, nil))
 }
 
 func TestWalkPath(t *testing.T) {
@@ -87,5 +90,115 @@ func TestWalkPath(t *testing.T) {
 		return nil
 	}
 
-	assert.NoError(t, WalkPath("../..", walkFunc))
+	assert.NoError(t, WalkPath("../..", OnErrorAbort, walkFunc))
+}
+
+func TestIsPartialErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                           false,
+		errPartial:                    true,
+		errors.Wrap(errPartial, "ok"): true,
+		errInvalidPath:                false,
+	} {
+		assert.Equal(t, expected, IsPartialErr(err))
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	for name, expected := range map[string]ErrorPolicy{
+		"abort":       OnErrorAbort,
+		"Continue":    OnErrorContinue,
+		" CONTINUE  ": OnErrorContinue,
+	} {
+		policy, err := ParseErrorPolicy(name)
+		assert.NoErrorf(t, err, `unexpected error for "%s"`, name)
+		assert.Equalf(t, expected, policy, `failed for "%s"`, name)
+	}
+
+	_, err := ParseErrorPolicy("ignore")
+	assert.True(t, IsInvalidPolicyErr(err))
+
+	// Should be usable as a flag value
+	policy := OnErrorAbort
+	assert.NoError(t, policy.Set("continue"))
+	assert.Equal(t, "continue", policy.String())
+	assert.Error(t, policy.Set("invalid"))
+	assert.Equal(t, OnErrorContinue, policy, "invalid value modified policy")
+	assert.NotEmpty(t, policy.Type())
+}
+
+func TestErrorKind(t *testing.T) {
+	for err, expected := range map[error]string{
+		nil:                                     "",
+		os.ErrNotExist:                          "vanished during scan",
+		errors.Wrap(os.ErrNotExist, "open"):     "vanished during scan",
+		&fs.PathError{Err: os.ErrPermission}:    "permission denied",
+		errors.Wrap(os.ErrPermission, "open"):   "permission denied",
+		errors.Wrap(syscall.EIO, "read"):        "I/O error",
+		errors.Wrap(errUnstable, "read"):        "changed while being read",
+		fmt.Errorf("(%s): test error", pkgName): "(lib): test error",
+	} {
+		assert.Equal(t, expected, ErrorKind(err))
+	}
+}
+
+func TestWalkPath_Continue(t *testing.T) {
+	reset()
+
+	info, err := os.Stat("fs.go") // any file will do
+	require.NoError(t, err)
+
+	// Mock a walk over three files, where the second can't be read
+	filepathWalk = func(root string, walkFunc filepath.WalkFunc) error {
+		for _, path := range []string{"a", "b", "c"} {
+			var err error
+			if path == "b" {
+				err = os.ErrPermission
+			}
+
+			if e := walkFunc(filepath.Join(root, path), info, err); e != nil {
+				return e
+			}
+		}
+
+		return nil
+	}
+
+	visited := map[string]error{}
+	walkFunc := func(path string, _ fs.FileInfo, err error) error {
+		visited[filepath.Base(path)] = err
+		return nil
+	}
+
+	// Abort should stop at the first error, without running `walkFunc` on it
+	err = WalkPath(".", OnErrorAbort, walkFunc)
+	assert.Error(t, err)
+	assert.False(t, IsPartialErr(err))
+	assert.Len(t, visited, 1)
+
+	// Continue should record the error, visit each file, and report a partial walk
+	visited = map[string]error{}
+	err = WalkPath(".", OnErrorContinue, walkFunc)
+	assert.True(t, IsPartialErr(err))
+	assert.Len(t, visited, 3)
+	assert.True(t, os.IsPermission(visited["b"]))
+
+	// Errors returned by `walkFunc` should be skipped over as well
+	err = WalkPath(".", OnErrorContinue, func(path string, _ fs.FileInfo, _ error) error {
+		return fmt.Errorf("(%s/TestWalkPath_Continue): test error", pkgName)
+	})
+	assert.True(t, IsPartialErr(err))
+
+	// Cancellation stops the walk, irrespective of the policy
+	for _, cancelled := range []error{context.Canceled, context.DeadlineExceeded} {
+		visited = map[string]error{}
+		err = WalkPath(".", OnErrorContinue, func(path string, _ fs.FileInfo, _ error) error {
+			visited[filepath.Base(path)] = nil
+			return errors.Wrap(cancelled, "walk")
+		})
+
+		assert.ErrorIs(t, err, cancelled)
+		assert.False(t, IsPartialErr(err))
+		assert.Len(t, visited, 1)
+	}
 }
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"crypto/md5"  // #nosec G501 - used for checksums, not security
+	"crypto/sha1" // #nosec G505 - used for checksums, not security
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Names of the supported checksum algorithms
+*/
+const (
+	AlgoCRC32     = "crc32"
+	AlgoCRC32C    = "crc32c"
+	AlgoCRC64ISO  = "crc64-iso"
+	AlgoCRC64ECMA = "crc64-ecma"
+	AlgoMD5       = "md5"
+	AlgoSHA1      = "sha1"
+	AlgoSHA256    = "sha256"
+)
+
+// errUnknownAlgo indicates that a checksum algorithm is not supported
+var errUnknownAlgo = fmt.Errorf("(%s): unknown checksum algorithm", pkgName)
+
+// Tables for CRC algorithms, computed once
+var (
+	tableCRC32C    = crc32.MakeTable(crc32.Castagnoli)
+	tableCRC64ISO  = crc64.MakeTable(crc64.ISO)
+	tableCRC64ECMA = crc64.MakeTable(crc64.ECMA)
+)
+
+// algorithms maps the name of each supported algorithm to a function creating it
+var algorithms = map[string]func() hash.Hash{
+	AlgoCRC32:     func() hash.Hash { return crc32.NewIEEE() },
+	AlgoCRC32C:    func() hash.Hash { return crc32.New(tableCRC32C) },
+	AlgoCRC64ISO:  func() hash.Hash { return crc64.New(tableCRC64ISO) },
+	AlgoCRC64ECMA: func() hash.Hash { return crc64.New(tableCRC64ECMA) },
+	AlgoMD5:       md5.New,
+	AlgoSHA1:      sha1.New,
+	AlgoSHA256:    sha256.New,
+}
+
+/*
+IsUnknownAlgoErr checks if an error was caused by an unsupported checksum algorithm
+*/
+func IsUnknownAlgoErr(err error) bool {
+	return errors.Is(err, errUnknownAlgo)
+}
+
+/*
+Algorithms returns the names of all supported checksum algorithms, sorted
+*/
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+/*
+NewHashes creates a hash for each algorithm, mapped to the name of the algorithm. Names
+are case-insensitive. Returns an error if an algorithm is not supported, use
+IsUnknownAlgoErr to check
+*/
+func NewHashes(algos []string) (map[string]hash.Hash, error) {
+	hashes := make(map[string]hash.Hash, len(algos))
+	for _, algo := range algos {
+		algo = strings.ToLower(strings.TrimSpace(algo))
+
+		newHash, ok := algorithms[algo]
+		if !ok {
+			return nil, errors.Wrapf(errUnknownAlgo, "(%s/NewHashes): %s", pkgName, algo)
+		}
+
+		hashes[algo] = newHash()
+	}
+
+	return hashes, nil
+}
+
+/*
+Checksum reads all data from the reader, computing checksums with each algorithm in a
+single pass. Returns the checksums (hex-encoded, mapped to the name of the algorithm)
+along with the number of bytes read
+
+Data read is also written to the `extra` writers (such as a BlockHash) in the same pass
+*/
+func Checksum(
+	reader io.Reader, algos []string, extra ...io.Writer,
+) (map[string]string, int64, error) {
+	sums, size, err := checksum(reader, algos, nil, extra...)
+	if err != nil {
+		return nil, size, errors.Wrapf(err, "(%s/Checksum)", pkgName)
+	}
+
+	return sums, size, nil
+}
+
+/*
+checksum computes checksums in the same way as Checksum, reading data through the
+buffer if it is not nil
+*/
+func checksum(
+	reader io.Reader, algos []string, buf []byte, extra ...io.Writer,
+) (map[string]string, int64, error) {
+	hashes, err := NewHashes(algos)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	writers := append(make([]io.Writer, 0, len(hashes)+len(extra)), extra...)
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+
+	size, err := io.CopyBuffer(io.MultiWriter(writers...), reader, buf)
+	if err != nil {
+		return nil, size, err
+	}
+
+	return Sums(hashes), size, nil
+}
+
+/*
+Sums returns the hex-encoded checksum for each hash, mapped to the same key
+*/
+func Sums(hashes map[string]hash.Hash) map[string]string {
+	sums := make(map[string]string, len(hashes))
+	for algo, h := range hashes {
+		sums[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return sums
+}
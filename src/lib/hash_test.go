@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkInput is the standard input used to publish check values for CRC algorithms
+const checkInput = "123456789"
+
+// checkValues maps each algorithm to its checksum for checkInput
+var checkValues = map[string]string{
+	AlgoCRC32:     "cbf43926",
+	AlgoCRC32C:    "e3069283",
+	AlgoCRC64ISO:  "b90956c775a41001",
+	AlgoCRC64ECMA: "995dc9bbdf1939fa",
+	AlgoMD5:       "25f9e794323b453885f5181f1b624d0b",
+	AlgoSHA1:      "f7c3bc1d808e04732adf679965ccc34ca7ae3441",
+	AlgoSHA256:    "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225",
+}
+
+func TestIsUnknownAlgoErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                 false,
+		errUnknownAlgo:                      true,
+		errors.Wrap(errUnknownAlgo, "test"): true,
+		errInvalidPath:                      false,
+	} {
+		assert.Equal(t, expected, IsUnknownAlgoErr(err))
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	algos := Algorithms()
+
+	assert.Len(t, algos, len(algorithms))
+	assert.IsNonDecreasing(t, algos)
+}
+
+func TestNewHashes(t *testing.T) {
+	hashes, err := NewHashes([]string{"CRC32", " sha256 "})
+	require.NoError(t, err)
+	assert.Len(t, hashes, 2)
+	assert.Contains(t, hashes, AlgoCRC32)
+	assert.Contains(t, hashes, AlgoSHA256)
+
+	_, err = NewHashes([]string{AlgoCRC32, "crc16"})
+	assert.True(t, IsUnknownAlgoErr(err))
+}
+
+func TestChecksum(t *testing.T) {
+	sums, size, err := Checksum(strings.NewReader(checkInput), Algorithms())
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(len(checkInput)), size)
+	assert.Equal(t, checkValues, sums)
+
+	_, _, err = Checksum(strings.NewReader(checkInput), []string{"crc16"})
+	assert.True(t, IsUnknownAlgoErr(err))
+
+	_, _, err = Checksum(&failReader{}, []string{AlgoCRC32})
+	assert.Error(t, err)
+}
+
+// failReader is a reader that always fails
+type failReader struct{}
+
+func (*failReader) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("(%s/failReader): test error", pkgName)
+}
+
+func TestChecksum_Extra(t *testing.T) {
+	var extra strings.Builder
+
+	sums, _, err := Checksum(strings.NewReader(checkInput), []string{AlgoCRC32}, &extra)
+	require.NoError(t, err)
+	assert.Equal(t, checkValues[AlgoCRC32], sums[AlgoCRC32])
+	assert.Equal(t, checkInput, extra.String())
+}
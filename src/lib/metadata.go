@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// modeBits are the bits of a file mode captured as metadata
+const modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+var (
+	lookupUser  = user.LookupId      // maps to user.LookupId
+	lookupGroup = user.LookupGroupId // maps to user.LookupGroupId
+	statPath    = os.Stat            // maps to os.Stat
+)
+
+/*
+ACLXattrs are the extended attributes storing POSIX ACLs on Linux, captured by default
+along with the metadata of files
+*/
+var ACLXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// names caches names of users and groups resolved from their ids, keyed by `u:<uid>`
+// and `g:<gid>`
+var names = struct {
+	sync.Mutex
+	cache map[string]string
+}{cache: map[string]string{}}
+
+/*
+resolveName returns the name of a user or group (`kind` being `u` or `g`) from its id,
+or an empty string if it can't be resolved. Lookups are cached
+*/
+func resolveName(kind string, id int) string {
+	key := kind + ":" + strconv.Itoa(id)
+
+	names.Lock()
+	defer names.Unlock()
+
+	if name, ok := names.cache[key]; ok {
+		return name
+	}
+
+	var name string
+	if kind == "u" {
+		if found, err := lookupUser(strconv.Itoa(id)); err == nil {
+			name = found.Username
+		}
+	} else if found, err := lookupGroup(strconv.Itoa(id)); err == nil {
+		name = found.Name
+	}
+
+	names.cache[key] = name
+	return name
+}
+
+/*
+CaptureMetadata captures the metadata of a file, from the result of `os.Lstat`. Values
+of the extended attributes named in `xattrs` are captured as well, attributes the file
+does not have are left out. File systems without extended attributes are not an error
+
+Symbolic links are described by the link itself, rather than its target - extended
+attributes are never captured for links, as reading them follows the link
+*/
+func CaptureMetadata(path string, info os.FileInfo, xattrs []string) (
+	*writer.Metadata, error,
+) {
+	meta := &writer.Metadata{Mode: info.Mode() & modeBits}
+
+	var ok bool
+	meta.UID, meta.GID, meta.Ctime, ok = fileOwner(info)
+	if ok {
+		meta.Owner, meta.Group = resolveName("u", meta.UID), resolveName("g", meta.GID)
+	}
+
+	if len(xattrs) == 0 || info.Mode()&fs.ModeSymlink != 0 {
+		return meta, nil
+	}
+
+	present, err := listXattrs(path)
+	if IsXattrUnsupportedErr(err) {
+		return meta, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "(%s/CaptureMetadata)", pkgName)
+	}
+
+	for _, name := range present {
+		if !contains(xattrs, name) {
+			continue
+		}
+
+		value, err := getXattr(path, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "(%s/CaptureMetadata): %s", pkgName, name)
+		}
+
+		if meta.Xattrs == nil {
+			meta.Xattrs = map[string][]byte{}
+		}
+
+		meta.Xattrs[name] = value
+	}
+
+	return meta, nil
+}
+
+/*
+MetadataDrift compares metadata recorded for a file against its current metadata,
+returning a description of each difference - changes in permissions, ownership, and
+extended attributes (changed, removed, or added). The ctime is not compared, as it
+changes with the contents too. Returns nil if nothing changed
+*/
+func MetadataDrift(recorded, current *writer.Metadata) []string {
+	var drift []string
+
+	if recorded.Mode != current.Mode {
+		drift = append(drift, fmt.Sprintf("mode %s -> %s", recorded.Mode, current.Mode))
+	}
+
+	// Ownership can only be compared if it was known both times
+	if recorded.UID >= 0 && current.UID >= 0 && recorded.UID != current.UID {
+		drift = append(drift, fmt.Sprintf(
+			"owner %s -> %s",
+			idName(recorded.UID, recorded.Owner), idName(current.UID, current.Owner),
+		))
+	}
+
+	if recorded.GID >= 0 && current.GID >= 0 && recorded.GID != current.GID {
+		drift = append(drift, fmt.Sprintf(
+			"group %s -> %s",
+			idName(recorded.GID, recorded.Group), idName(current.GID, current.Group),
+		))
+	}
+
+	attrs := make([]string, 0, len(recorded.Xattrs)+len(current.Xattrs))
+	for name := range recorded.Xattrs {
+		attrs = append(attrs, name)
+	}
+
+	for name := range current.Xattrs {
+		if _, ok := recorded.Xattrs[name]; !ok {
+			attrs = append(attrs, name)
+		}
+	}
+
+	sort.Strings(attrs)
+	for _, name := range attrs {
+		was, recordedOk := recorded.Xattrs[name]
+		value, ok := current.Xattrs[name]
+
+		switch {
+		case !recordedOk:
+			drift = append(drift, "xattr "+name+" added")
+		case !ok:
+			drift = append(drift, "xattr "+name+" removed")
+		case !bytes.Equal(value, was):
+			drift = append(drift, "xattr "+name+" changed")
+		}
+	}
+
+	return drift
+}
+
+/*
+VerifyMetadata compares the metadata recorded for a file against its current metadata,
+see MetadataDrift. The extended attributes named in `xattrs` are captured for the
+comparison, along with those recorded for the file - attributes from `xattrs` that the
+file gained since are reported as added. Returns nil for entries without recorded
+metadata
+*/
+func VerifyMetadata(entry *writer.FileInfo, xattrs []string) ([]string, error) {
+	if entry.Metadata == nil {
+		return nil, nil
+	}
+
+	// Described the same way as by `generate`, without following symbolic links
+	info, err := lstatPath(entry.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s/VerifyMetadata)", pkgName)
+	}
+
+	names := append([]string{}, xattrs...)
+	for name := range entry.Metadata.Xattrs {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	current, err := CaptureMetadata(entry.Path, info, names)
+	if err != nil {
+		return nil, err
+	}
+
+	return MetadataDrift(entry.Metadata, current), nil
+}
+
+/*
+idName formats a user or group as its name along with the id, or just the id if the
+name is not known
+*/
+func idName(id int, name string) string {
+	if name == "" {
+		return strconv.Itoa(id)
+	}
+
+	return fmt.Sprintf("%s (%d)", name, id)
+}
+
+// contains checks if a slice contains the value
+func contains(values []string, value string) bool {
+	for _, val := range values {
+		if val == value {
+			return true
+		}
+	}
+
+	return false
+}
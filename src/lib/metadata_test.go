@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func resetMetadata() {
+	lookupUser = user.LookupId
+	lookupGroup = user.LookupGroupId
+	statPath = os.Stat
+	lstatPath = os.Lstat
+	names.cache = map[string]string{}
+}
+
+func TestCaptureMetadata(t *testing.T) {
+	defer resetMetadata()
+
+	lookups := 0
+	lookupUser = func(uid string) (*user.User, error) {
+		lookups++
+		return &user.User{Uid: uid, Username: "alice"}, nil
+	}
+
+	lookupGroup = func(gid string) (*user.Group, error) {
+		return nil, user.UnknownGroupIdError(gid)
+	}
+
+	path, info := xattrFile(t)
+	require.NoError(t, os.Chmod(path, 0o640))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	meta, err := CaptureMetadata(path, info, []string{"user.test", "user.absent"})
+	require.NoError(t, err)
+
+	assert.Equal(t, fs.FileMode(0o640), meta.Mode)
+	assert.Equal(t, map[string][]byte{"user.test": []byte("1")}, meta.Xattrs)
+
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, os.Getuid(), meta.UID)
+		assert.Equal(t, os.Getgid(), meta.GID)
+		assert.Equal(t, "alice", meta.Owner)
+		assert.Empty(t, meta.Group) // unresolved
+		assert.NotZero(t, meta.Ctime)
+	}
+
+	// Names are looked up once
+	_, err = CaptureMetadata(path, info, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, lookups)
+}
+
+func TestMetadataDrift(t *testing.T) {
+	recorded := writer.Metadata{
+		Mode: 0o644, UID: 1000, GID: 1000, Owner: "alice",
+		Xattrs: map[string][]byte{"user.a": []byte("1"), "user.b": []byte("2")},
+	}
+
+	assert.Nil(t, MetadataDrift(&recorded, &recorded))
+
+	current := recorded
+	current.Mode = fs.ModeSetuid | 0o777
+	current.UID, current.Owner = 0, "root"
+	current.GID = 0
+	current.Xattrs = map[string][]byte{"user.a": []byte("x"), "user.c": []byte("3")}
+
+	assert.Equal(t, []string{
+		"mode -rw-r--r-- -> urwxrwxrwx",
+		"owner alice (1000) -> root (0)",
+		"group 1000 -> 0",
+		"xattr user.a changed",
+		"xattr user.b removed",
+		"xattr user.c added",
+	}, MetadataDrift(&recorded, &current))
+
+	// Unknown ownership is never reported as drift
+	current = recorded
+	current.UID, current.GID = -1, -1
+	assert.Nil(t, MetadataDrift(&recorded, &current))
+}
+
+func TestVerifyMetadata(t *testing.T) {
+	defer resetMetadata()
+
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	meta, err := CaptureMetadata(path, info, nil)
+	require.NoError(t, err)
+
+	entry := writer.FileInfo{Path: path, Metadata: meta}
+	drift, err := VerifyMetadata(&entry, nil)
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+
+	require.NoError(t, os.Chmod(path, 0o666))
+	drift, err = VerifyMetadata(&entry, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mode -rw------- -> -rw-rw-rw-"}, drift)
+
+	// Entries without metadata are skipped
+	drift, err = VerifyMetadata(&writer.FileInfo{Path: path}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, drift)
+
+	lstatPath = func(string) (os.FileInfo, error) { return nil, os.ErrPermission }
+	_, err = VerifyMetadata(&entry, nil)
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
+
+func TestVerifyMetadata_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	// Links are described by the link itself, the target having a different mode
+	for name, target := range map[string]string{
+		"link": path, "dangling": filepath.Join(dir, "missing"),
+	} {
+		link := filepath.Join(dir, name)
+		require.NoError(t, os.Symlink(target, link))
+
+		info, err := os.Lstat(link)
+		require.NoError(t, err)
+
+		meta, err := CaptureMetadata(link, info, ACLXattrs)
+		require.NoErrorf(t, err, "failed for %s", name)
+		assert.Equal(t, fs.ModePerm&info.Mode(), meta.Mode)
+
+		entry := writer.FileInfo{Path: link, Metadata: meta}
+		drift, err := VerifyMetadata(&entry, ACLXattrs)
+		require.NoErrorf(t, err, "failed for %s", name)
+		assert.Emptyf(t, drift, "failed for %s", name)
+	}
+}
+
+func TestVerifyMetadata_Xattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	if err := setXattr(path, "user.a", []byte("1")); IsXattrUnsupportedErr(err) {
+		t.Skip("extended attributes not supported")
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	meta, err := CaptureMetadata(path, info, []string{"user.a"})
+	require.NoError(t, err)
+
+	// Recorded attributes are compared even if not named, named attributes the file
+	// gained since are reported as added
+	require.NoError(t, setXattr(path, "user.a", []byte("2")))
+	require.NoError(t, setXattr(path, "user.b", []byte("1")))
+
+	entry := writer.FileInfo{Path: path, Metadata: meta}
+	drift, err := VerifyMetadata(&entry, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xattr user.a changed"}, drift)
+
+	drift, err = VerifyMetadata(&entry, []string{"user.b", "user.c"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xattr user.a changed", "xattr user.b added"}, drift)
+}
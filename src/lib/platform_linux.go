@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+/*
+mmapFile maps the first `size` bytes of a file into memory, read-only
+*/
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	fd := int(file.Fd())
+	return syscall.Mmap(fd, 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+/*
+munmapFile unmaps memory mapped with mmapFile
+*/
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
+
+/*
+openDirect opens a file for reading with O_DIRECT. Returns errUnsupported if the file
+system does not support O_DIRECT
+*/
+func openDirect(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if errors.Is(err, syscall.EINVAL) {
+		return nil, errors.Wrapf(errUnsupported, "%v", err)
+	}
+
+	return file, err
+}
+
+/*
+fileKey forms the CacheKey for a regular file from its device, and inode numbers
+*/
+func fileKey(info os.FileInfo) (CacheKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return CacheKey{}, false
+	}
+
+	return CacheKey{
+		Dev:     stat.Dev,
+		Ino:     stat.Ino,
+		Size:    info.Size(),
+		MtimeNs: info.ModTime().UnixNano(),
+	}, true
+}
+
+/*
+deviceNumbers returns the major, and minor numbers of a device file - decoded as done
+by glibc
+*/
+func deviceNumbers(info os.FileInfo) (major, minor uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	dev := uint64(stat.Rdev)
+	major = uint32((dev>>8)&0xfff | (dev>>32)&0xfffff000)
+	minor = uint32(dev&0xff | (dev>>12)&0xffffff00)
+	return major, minor, true
+}
+
+/*
+openNonblock opens a file for reading with O_NONBLOCK, opening a FIFO does not wait for
+a writer. Reads from regular files are not affected by the flag
+*/
+func openNonblock(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+}
+
+/*
+fileOwner returns the numeric owner, and group of a file, along with its ctime as epoch
+time
+*/
+func fileOwner(info os.FileInfo) (uid, gid int, ctime int64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, 0, false
+	}
+
+	return int(stat.Uid), int(stat.Gid), int64(stat.Ctim.Sec), true
+}
+
+/*
+xattrErr converts errors for extended attributes - file systems without support for
+them return ENOTSUP
+*/
+func xattrErr(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) {
+		return errors.Wrapf(errXattrUnsupported, "%v", err)
+	}
+
+	return err
+}
+
+/*
+setXattr sets an extended attribute on a file, replacing any existing value
+*/
+func setXattr(path, name string, value []byte) error {
+	return xattrErr(syscall.Setxattr(path, name, value, 0))
+}
+
+/*
+getXattr returns the value of an extended attribute of a file
+*/
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, xattrErr(err)
+	}
+
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, value)
+	return value[:size], xattrErr(err)
+}
+
+/*
+listXattrs returns names of all extended attributes of a file
+*/
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, xattrErr(err)
+	}
+
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, xattrErr(err)
+	}
+
+	// Names are separated by, and end with a null byte
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+
+	return names, nil
+}
+
+/*
+removeXattr removes an extended attribute from a file
+*/
+func removeXattr(path, name string) error {
+	return xattrErr(syscall.Removexattr(path, name))
+}
@@ -0,0 +1,84 @@
+//go:build !linux
+// +build !linux
+
+package lib
+
+import "os"
+
+/*
+mmapFile is not supported on this platform, always returns errUnsupported
+*/
+func mmapFile(*os.File, int64) ([]byte, error) {
+	return nil, errUnsupported
+}
+
+/*
+munmapFile is not supported on this platform, and does nothing
+*/
+func munmapFile([]byte) error {
+	return nil
+}
+
+/*
+openDirect is not supported on this platform, always returns errUnsupported
+*/
+func openDirect(string) (*os.File, error) {
+	return nil, errUnsupported
+}
+
+/*
+fileKey is not supported on this platform, keys can never be formed
+*/
+func fileKey(os.FileInfo) (CacheKey, bool) {
+	return CacheKey{}, false
+}
+
+/*
+deviceNumbers is not supported on this platform, device numbers are always unknown
+*/
+func deviceNumbers(os.FileInfo) (major, minor uint32, ok bool) {
+	return 0, 0, false
+}
+
+/*
+openNonblock opens a file for reading, non-blocking opens are not supported on this
+platform
+*/
+func openNonblock(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+/*
+fileOwner is not supported on this platform, ownership is always unknown
+*/
+func fileOwner(os.FileInfo) (uid, gid int, ctime int64, ok bool) {
+	return -1, -1, 0, false
+}
+
+/*
+setXattr is not supported on this platform, always returns errXattrUnsupported
+*/
+func setXattr(string, string, []byte) error {
+	return errXattrUnsupported
+}
+
+/*
+getXattr is not supported on this platform, always returns errXattrUnsupported
+*/
+func getXattr(string, string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+/*
+listXattrs is not supported on this platform, always returns errXattrUnsupported
+*/
+func listXattrs(string) ([]string, error) {
+	return nil, errXattrUnsupported
+}
+
+/*
+removeXattr is not supported on this platform, always returns errXattrUnsupported
+*/
+func removeXattr(string, string) error {
+	return errXattrUnsupported
+}
@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc64"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+/*
+AlgoQuick is the name used for quick hashes - these are not checksums of the complete
+contents of a file, and should never be compared against one
+*/
+const AlgoQuick = "quick"
+
+// DefaultQuickSize is the size of each sample used by a quick hash - 64 KiB
+const DefaultQuickSize = 64 << 10
+
+/*
+QuickHash computes a quick hash for the first `size` bytes of the reader, by hashing
+the size along with three samples of `sampleSize` bytes each - from the start, middle
+and end of the data. Inputs no larger than the three samples are hashed completely. A
+sample size less than one falls back to DefaultQuickSize
+
+A quick hash detects changes in size, and in the sampled regions only. It is meant for
+fast change detection where reading complete files is slow (i.e. network mounts), with
+full checksums computed only for files whose quick hash changes
+*/
+func QuickHash(reader io.ReaderAt, size, sampleSize int64) (string, error) {
+	if sampleSize < 1 {
+		sampleSize = DefaultQuickSize
+	}
+
+	crc := crc64.New(tableCRC64ECMA)
+
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(size))
+	_, _ = crc.Write(header[:]) // never fails
+
+	// Offsets for each sample, a single sample covers small inputs completely
+	offsets := []int64{0}
+	if size > 3*sampleSize {
+		offsets = append(offsets, (size-sampleSize)/2, size-sampleSize)
+	} else {
+		sampleSize = size
+	}
+
+	for _, offset := range offsets {
+		read, err := io.Copy(crc, io.NewSectionReader(reader, offset, sampleSize))
+		if err == nil && read != sampleSize {
+			err = errors.Wrapf(io.ErrUnexpectedEOF, "sample at %d", offset)
+		}
+
+		if err != nil {
+			return "", errors.Wrapf(err, "(%s/QuickHash)", pkgName)
+		}
+	}
+
+	return hex.EncodeToString(crc.Sum(nil)), nil
+}
+
+/*
+NeedsFullHash checks if the full checksum of a file has to be computed again, given its
+previous entry in an output file (nil if there is none), and its current size and quick
+hash. Full checksums are only needed for files that are new, or whose size or quick
+hash changed since the last run
+*/
+func NeedsFullHash(prev *writer.FileInfo, size int64, quick string) bool {
+	return prev == nil ||
+		prev.Checksums.CRC32 == "" ||
+		prev.Checksums.Quick == "" ||
+		prev.Size != size ||
+		prev.Checksums.Quick != quick
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func TestQuickHash(t *testing.T) {
+	data := bytes.Repeat([]byte(checkInput), 100) // 900 bytes
+	quick := func(data []byte, sampleSize int64) string {
+		sum, err := QuickHash(bytes.NewReader(data), int64(len(data)), sampleSize)
+		require.NoError(t, err)
+		return sum
+	}
+
+	expected := quick(data, 10)
+	assert.Len(t, expected, 16)
+	assert.Equal(t, expected, quick(append([]byte{}, data...), 10))
+
+	// Changes in sampled regions, or in size should be detected
+	for _, offset := range []int{0, 445, 899} {
+		changed := append([]byte{}, data...)
+		changed[offset] = 'x'
+		assert.NotEqualf(t, expected, quick(changed, 10), "failed for offset %d", offset)
+	}
+
+	assert.NotEqual(t, expected, quick(data[:899], 10))
+
+	// Changes outside the sampled regions are not detected
+	changed := append([]byte{}, data...)
+	changed[200] = 'x'
+	assert.Equal(t, expected, quick(changed, 10))
+
+	// Small inputs are hashed completely, default sample size covers all of data
+	changed[200] = data[200]
+	changed[200+1] = 'x'
+	assert.NotEqual(t, quick(data, 0), quick(changed, 0))
+	assert.NotEqual(t, quick(data, 300), quick(changed, 300))
+
+	// Inputs shorter than the size should fail
+	_, err := QuickHash(strings.NewReader(checkInput), 100, 10)
+	assert.Error(t, err)
+}
+
+func TestNeedsFullHash(t *testing.T) {
+	prev := &writer.FileInfo{
+		Checksums: writer.Checksums{CRC32: "cbf43926", Quick: "0123456789abcdef"},
+		Size:      9,
+	}
+
+	assert.False(t, NeedsFullHash(prev, 9, "0123456789abcdef"))
+	assert.True(t, NeedsFullHash(nil, 9, "0123456789abcdef"))
+	assert.True(t, NeedsFullHash(prev, 10, "0123456789abcdef"))
+	assert.True(t, NeedsFullHash(prev, 9, "fedcba9876543210"))
+
+	for _, checksums := range []writer.Checksums{
+		{CRC32: "cbf43926"}, {Quick: "0123456789abcdef"},
+	} {
+		prev.Checksums = checksums
+		assert.True(t, NeedsFullHash(prev, 9, "0123456789abcdef"))
+	}
+}
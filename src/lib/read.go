@@ -0,0 +1,342 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+/*
+Custom errors
+*/
+var (
+	// errInvalidStrategy indicates that a read strategy could not be parsed
+	errInvalidStrategy = fmt.Errorf("(%s): invalid read strategy", pkgName)
+
+	// errUnsupported indicates that a read strategy is not supported on this platform
+	errUnsupported = fmt.Errorf("(%s): read strategy not supported", pkgName)
+)
+
+/*
+Sizes used to pick, and tune read strategies
+*/
+const (
+	// DefaultBufferSize is the size of buffers used to read files - 1 MiB
+	DefaultBufferSize = 1 << 20
+
+	// directAlign is the alignment needed for buffers, offsets and sizes of reads
+	// with O_DIRECT - matches the page size on most systems
+	directAlign = 4096
+
+	// mmapMinSize is the minimum size of a file to be memory-mapped by ReadAuto
+	mmapMinSize = 1 << 20
+
+	// directMinSize is the minimum size of a file to be read with O_DIRECT by
+	// ReadAuto, larger files would only pollute the page cache
+	directMinSize = 1 << 30
+)
+
+/*
+IsInvalidStrategyErr indicates if a read strategy could not be parsed
+*/
+func IsInvalidStrategyErr(err error) bool {
+	return errors.Is(err, errInvalidStrategy)
+}
+
+/*
+ReadStrategy decides how files are read while computing checksums
+
+ReadStrategy implements the `pflag.Value` interface, and can be used directly as a flag
+*/
+type ReadStrategy int
+
+const (
+	// ReadBuffered reads files through a buffer of configurable size, the default
+	ReadBuffered ReadStrategy = iota
+
+	// ReadMmap maps files into memory, avoiding copies into a buffer. Faults while
+	// reading mapped files are returned as I/O errors, see checksumMapped
+	ReadMmap
+
+	// ReadDirect reads files with O_DIRECT, bypassing the page cache
+	ReadDirect
+
+	// ReadAuto picks a strategy based on the size of each file
+	ReadAuto
+)
+
+// strategyNames maps each ReadStrategy to its name
+var strategyNames = map[ReadStrategy]string{
+	ReadBuffered: "buffered",
+	ReadMmap:     "mmap",
+	ReadDirect:   "direct",
+	ReadAuto:     "auto",
+}
+
+/*
+ReadStrategies returns all strategies that read files, i.e. all except ReadAuto
+*/
+func ReadStrategies() []ReadStrategy {
+	return []ReadStrategy{ReadBuffered, ReadMmap, ReadDirect}
+}
+
+/*
+ParseReadStrategy parses the name of a ReadStrategy, names are case-insensitive. Use
+IsInvalidStrategyErr to check for unknown names
+*/
+func ParseReadStrategy(name string) (ReadStrategy, error) {
+	for strategy, val := range strategyNames {
+		if strings.EqualFold(strings.TrimSpace(name), val) {
+			return strategy, nil
+		}
+	}
+
+	return ReadBuffered, errors.Wrapf(
+		errInvalidStrategy, "(%s/ParseReadStrategy)", pkgName,
+	)
+}
+
+func (s ReadStrategy) String() string {
+	return strategyNames[s]
+}
+
+func (s *ReadStrategy) Set(name string) error {
+	strategy, err := ParseReadStrategy(name)
+	if err == nil {
+		*s = strategy
+	}
+
+	return err
+}
+
+func (*ReadStrategy) Type() string {
+	return "strategy"
+}
+
+/*
+For resolves the strategy used to read a file of the given size - ReadAuto picks a
+strategy based on the size, other strategies are returned as is
+*/
+func (s ReadStrategy) For(size int64) ReadStrategy {
+	switch {
+	case s != ReadAuto:
+		return s
+	case size >= directMinSize:
+		return ReadDirect
+	case size >= mmapMinSize:
+		return ReadMmap
+	}
+
+	return ReadBuffered
+}
+
+/*
+ReadOptions configures how files are read while computing checksums
+*/
+type ReadOptions struct {
+	// Strategy decides how files are read, defaults to ReadBuffered
+	Strategy ReadStrategy
+
+	// BufferSize contains the size of buffers used by ReadBuffered and ReadDirect, in
+	// bytes. Defaults to DefaultBufferSize, sizes are rounded up to a multiple of 4 KiB
+	// for ReadDirect
+	BufferSize int
+
+	// Workers is the number of segments files of at least ParallelThreshold bytes are
+	// hashed in concurrently, when only CRC algorithms are used - see ChecksumAt. Such
+	// files are read with the strategy when below two
+	Workers int
+}
+
+// bufferSize returns the size of buffers to be used, aligned for ReadDirect if needed
+func (opts *ReadOptions) bufferSize(aligned bool) int {
+	size := opts.BufferSize
+	if size < 1 {
+		size = DefaultBufferSize
+	}
+
+	if aligned {
+		size = (size + directAlign - 1) / directAlign * directAlign
+	}
+
+	return size
+}
+
+/*
+ChecksumFile computes checksums for the first `size` bytes of a file, with each
+algorithm, reading the file with the strategy from the options. Returns checksums in
+the same form as Checksum, along with the strategy the file was actually read with
+
+Strategies that are not supported for a file (i.e. O_DIRECT on tmpfs, or mapping an
+empty file), or the platform, fall back to ReadBuffered
+*/
+func ChecksumFile(
+	file *os.File, size int64, algos []string, opts ReadOptions,
+) (map[string]string, ReadStrategy, error) {
+	strategy := opts.Strategy.For(size)
+
+	var (
+		sums map[string]string
+		read int64
+		err  error
+	)
+
+	switch strategy {
+	case ReadMmap:
+		sums, read, err = checksumMmap(file, size, algos)
+	case ReadDirect:
+		sums, read, err = checksumDirect(file, size, algos, opts.bufferSize(true))
+	default:
+		strategy = ReadBuffered
+	}
+
+	if errors.Is(err, errUnsupported) {
+		logger.Debugf(
+			`(%s/ChecksumFile): "%s": %s not supported, falling back to buffered reads`,
+			pkgName, file.Name(), strategy,
+		)
+
+		strategy = ReadBuffered
+	}
+
+	if strategy == ReadBuffered {
+		buf := make([]byte, opts.bufferSize(false))
+		section := io.NewSectionReader(file, 0, size)
+
+		// Hide WriterTo of the reader, letting io.CopyBuffer use the buffer
+		sums, read, err = checksum(struct{ io.Reader }{section}, algos, buf)
+	}
+
+	if err == nil && read != size {
+		err = errors.Wrapf(io.ErrUnexpectedEOF, "read %d of %d bytes", read, size)
+	}
+
+	return sums, strategy, errors.Wrapf(err, "(%s/ChecksumFile)", pkgName)
+}
+
+/*
+checksumMmap computes checksums for a file by mapping it into memory
+*/
+func checksumMmap(
+	file *os.File, size int64, algos []string,
+) (map[string]string, int64, error) {
+	// Accessing pages beyond the end of a file crashes, map no more than its size
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	} else if info.Size() < size {
+		size = info.Size()
+	}
+
+	if size == 0 {
+		return nil, 0, errUnsupported // empty files can't be mapped
+	}
+
+	data, err := mmapFile(file, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func() { _ = munmapFile(data) }()
+	return checksumMapped(data, algos)
+}
+
+/*
+checksumMapped computes checksums for memory mapped from a file. Pages of a file that
+was truncated after being mapped, or that fail to be read from disk raise SIGBUS when
+accessed - crashing the process. Such faults are turned into a panic instead (see
+debug.SetPanicOnFault), and returned as an I/O error (EIO)
+*/
+func checksumMapped(data []byte, algos []string) (
+	sums map[string]string, read int64, err error,
+) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if rec := recover(); rec != nil {
+			fault, ok := rec.(interface{ Addr() uintptr })
+			if !ok {
+				panic(rec) // not a memory fault
+			}
+
+			err = errors.Wrapf(syscall.EIO, "fault at %#x in mapped file", fault.Addr())
+		}
+	}()
+
+	return checksum(bytes.NewReader(data), algos, nil)
+}
+
+/*
+checksumDirect computes checksums for a file by opening it again with O_DIRECT, and
+reading it into buffers aligned in memory
+*/
+func checksumDirect(
+	file *os.File, size int64, algos []string, bufSize int,
+) (map[string]string, int64, error) {
+	direct, err := openDirect(file.Name())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func() { _ = direct.Close() }()
+
+	buf := getAligned(bufSize)
+	defer alignedPool.Put(buf)
+
+	return checksum(&directReader{file: direct, left: size}, algos, *buf)
+}
+
+/*
+directReader reads up to `left` bytes from a file opened with O_DIRECT. Reads with
+O_DIRECT must be a multiple of the alignment, so the complete buffer is always read
+into, with data beyond `left` discarded after reading
+*/
+type directReader struct {
+	file *os.File
+	left int64
+}
+
+func (r *directReader) Read(buf []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, io.EOF
+	}
+
+	n, err := r.file.Read(buf)
+	if int64(n) > r.left {
+		n = int(r.left)
+	}
+
+	r.left -= int64(n)
+	return n, err
+}
+
+// alignedPool contains buffers aligned for reads with O_DIRECT
+var alignedPool sync.Pool
+
+/*
+getAligned returns a buffer of the given size from alignedPool, with its start aligned
+to directAlign in memory
+*/
+func getAligned(size int) *[]byte {
+	if buf, ok := alignedPool.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+
+	raw := make([]byte, size+directAlign)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) & (directAlign - 1)); rem != 0 {
+		offset = directAlign - rem
+	}
+
+	buf := raw[offset : offset+size : offset+size]
+	return &buf
+}
@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsInvalidStrategyErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                     false,
+		errInvalidStrategy:                      true,
+		errors.Wrap(errInvalidStrategy, "test"): true,
+		errUnsupported:                          false,
+	} {
+		assert.Equal(t, expected, IsInvalidStrategyErr(err))
+	}
+}
+
+func TestReadStrategy(t *testing.T) {
+	for name, expected := range map[string]ReadStrategy{
+		"auto":     ReadAuto,
+		"Buffered": ReadBuffered,
+		" mmap ":   ReadMmap,
+		"DIRECT":   ReadDirect,
+	} {
+		var strategy ReadStrategy
+		assert.NoError(t, strategy.Set(name))
+		assert.Equal(t, expected, strategy)
+		assert.Equal(t, expected.String(), strategy.String())
+	}
+
+	strategy := ReadMmap
+	assert.True(t, IsInvalidStrategyErr(strategy.Set("aio")))
+	assert.Equal(t, ReadMmap, strategy, "strategy changed on failure")
+	assert.Equal(t, "strategy", strategy.Type())
+
+	var unset ReadOptions
+	assert.Equal(t, ReadBuffered, unset.Strategy, "files are read buffered by default")
+
+	assert.Len(t, ReadStrategies(), len(strategyNames)-1)
+	assert.NotContains(t, ReadStrategies(), ReadAuto)
+}
+
+func TestReadStrategy_For(t *testing.T) {
+	for size, expected := range map[int64]ReadStrategy{
+		0:             ReadBuffered,
+		mmapMinSize:   ReadMmap,
+		directMinSize: ReadDirect,
+	} {
+		assert.Equal(t, expected, ReadAuto.For(size))
+		assert.Equal(t, ReadBuffered, ReadBuffered.For(size))
+	}
+}
+
+func TestReadOptions_BufferSize(t *testing.T) {
+	opts := ReadOptions{}
+	assert.Equal(t, DefaultBufferSize, opts.bufferSize(false))
+
+	opts.BufferSize = 5000
+	assert.Equal(t, 5000, opts.bufferSize(false))
+	assert.Equal(t, 2*directAlign, opts.bufferSize(true))
+}
+
+func TestChecksumFile(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte(checkInput), 10_000) // not a multiple of the alignment
+
+	expected, _, err := Checksum(bytes.NewReader(data), []string{AlgoCRC32, AlgoSHA1})
+	require.NoError(t, err)
+
+	for name, content := range map[string][]byte{"data": data, "empty": nil} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, content, 0o600))
+
+		file, err := os.Open(path)
+		require.NoError(t, err)
+
+		for _, strategy := range append(ReadStrategies(), ReadAuto) {
+			opts := ReadOptions{Strategy: strategy, BufferSize: 1000}
+			sums, used, err := ChecksumFile(
+				file, int64(len(content)), []string{AlgoCRC32, AlgoSHA1}, opts,
+			)
+
+			require.NoErrorf(t, err, "failed for %s", strategy)
+			if name == "data" {
+				assert.Equalf(t, expected, sums, "failed for %s", strategy)
+			}
+
+			// Strategies only fall back to buffered reads
+			size := int64(len(content))
+			assert.Containsf(
+				t, []ReadStrategy{strategy.For(size), ReadBuffered}, used,
+				"failed for %s", strategy,
+			)
+		}
+
+		// Empty files can't be mapped
+		if name == "empty" {
+			_, used, err := ChecksumFile(
+				file, 0, []string{AlgoCRC32}, ReadOptions{Strategy: ReadMmap},
+			)
+
+			require.NoError(t, err)
+			assert.Equal(t, ReadBuffered, used)
+		}
+
+		// Files shorter than the expected size should fail
+		for _, strategy := range ReadStrategies() {
+			opts := ReadOptions{Strategy: strategy}
+			_, _, err = ChecksumFile(file, 1<<20, []string{AlgoCRC32}, opts)
+			assert.Errorf(t, err, "failed for %s", strategy)
+		}
+
+		_ = file.Close()
+	}
+}
+
+func TestChecksumMapped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapped.bin")
+	require.NoError(t, os.WriteFile(path, make([]byte, 1<<16), 0o600))
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	data, err := mmapFile(file, 1<<16)
+	if errors.Is(err, errUnsupported) {
+		t.Skip("mmap not supported on this platform")
+	}
+
+	require.NoError(t, err)
+	defer func() { _ = munmapFile(data) }()
+
+	_, read, err := checksumMapped(data, []string{AlgoCRC32})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<16), read)
+
+	// Pages beyond the end of a truncated file fault, which must not crash
+	require.NoError(t, file.Truncate(0))
+	_, _, err = checksumMapped(data, []string{AlgoCRC32})
+	assert.ErrorIs(t, err, syscall.EIO)
+}
+
+func TestGetAligned(t *testing.T) {
+	for _, size := range []int{directAlign, 3 * directAlign} {
+		buf := getAligned(size)
+		assert.Len(t, *buf, size)
+		assert.Zero(t, uintptr(unsafe.Pointer(&(*buf)[0]))%directAlign)
+
+		alignedPool.Put(buf)
+	}
+}
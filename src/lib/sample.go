@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+/*
+SampleFiles picks a random subset of `count` files, without replacement. The subset is
+reproducible - the same files, and the same seed always result in the same subset
+
+With `bySize` set, each file is picked with a probability proportional to its size -
+the sample then covers a larger share of the data, and the corruption rate estimated
+from it is closer to the rate per byte. Otherwise, each file is equally likely to be
+picked. Files are returned ordered by path
+*/
+func SampleFiles(
+	files []*writer.FileInfo, count int, seed int64, bySize bool,
+) []*writer.FileInfo {
+	if count >= len(files) {
+		count = len(files)
+	}
+
+	// Keys follow the same order across runs, irrespective of the order of files
+	sorted := append([]*writer.FileInfo(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	// Weighted sampling by Efraimidis & Spirakis - each file gets the key u^(1/weight),
+	// picking files with the largest keys. Compared as ln(u)/weight to stay accurate
+	rnd := rand.New(rand.NewSource(seed)) // #nosec G404 - reproducible, not for security
+	keys := make(map[*writer.FileInfo]float64, len(sorted))
+
+	for _, file := range sorted {
+		weight := 1.0
+		if bySize {
+			weight += float64(file.Size) // empty files can still be picked
+		}
+
+		keys[file] = math.Log(1-rnd.Float64()) / weight
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return keys[sorted[i]] > keys[sorted[j]]
+	})
+
+	sample := sorted[:count]
+	sort.Slice(sample, func(i, j int) bool { return sample[i].Path < sample[j].Path })
+
+	return sample
+}
+
+/*
+FailureBound returns the upper bound on the rate of failures across all files, given
+the number of files sampled and the failures among them, with the confidence (between
+0 and 1) - i.e. the true rate is below the bound with the given confidence
+
+The bound is the one-sided Clopper-Pearson interval, which is conservative when the
+sample is a large fraction of all files. Returns 1 if nothing was sampled
+*/
+func FailureBound(sampled, failed int, confidence float64) float64 {
+	if sampled <= 0 || failed >= sampled {
+		return 1
+	}
+
+	// The bound is the rate at which observing `failed` or fewer failures is as likely
+	// as 1-confidence - found by bisection, the probability falls as the rate grows
+	alpha := 1 - confidence
+	low, high := float64(failed)/float64(sampled), 1.0
+
+	for i := 0; i < 64; i++ {
+		mid := (low + high) / 2
+		if binomialCDF(failed, sampled, mid) > alpha {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return high
+}
+
+/*
+binomialCDF returns the probability of `k` or fewer successes in `n` trials, with a
+probability `p` of success for each trial. Terms are computed in log space, to avoid
+overflows in the binomial coefficients
+*/
+func binomialCDF(k, n int, p float64) float64 {
+	if p <= 0 {
+		return 1
+	}
+
+	lnFactN, _ := math.Lgamma(float64(n + 1))
+
+	sum := 0.0
+	for i := 0; i <= k; i++ {
+		lnFactI, _ := math.Lgamma(float64(i + 1))
+		lnFactNI, _ := math.Lgamma(float64(n - i + 1))
+
+		sum += math.Exp(
+			lnFactN - lnFactI - lnFactNI +
+				float64(i)*math.Log(p) + float64(n-i)*math.Log1p(-p),
+		)
+	}
+
+	return sum
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func TestSampleFiles(t *testing.T) {
+	files := make([]*writer.FileInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		files = append(files, &writer.FileInfo{Path: fmt.Sprintf("/%03d", i), Size: 1})
+	}
+
+	sample := SampleFiles(files, 10, 42, false)
+	assert.Len(t, sample, 10)
+	for i := 1; i < len(sample); i++ {
+		assert.Less(t, sample[i-1].Path, sample[i].Path)
+	}
+
+	// Reproducible with the same seed, irrespective of the order of files
+	reversed := make([]*writer.FileInfo, 0, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		reversed = append(reversed, files[i])
+	}
+
+	assert.Equal(t, sample, SampleFiles(reversed, 10, 42, false))
+	assert.NotEqual(t, sample, SampleFiles(files, 10, 43, false))
+
+	// Counts beyond the number of files return all files
+	assert.Len(t, SampleFiles(files, 500, 42, false), len(files))
+	assert.Empty(t, SampleFiles(nil, 5, 42, true))
+
+	// Weighted by size, a single large file is almost always picked
+	large := &writer.FileInfo{Path: "/large", Size: 1 << 40}
+	picked := 0
+	for seed := int64(0); seed < 50; seed++ {
+		for _, file := range SampleFiles(append(files, large), 1, seed, true) {
+			if file == large {
+				picked++
+			}
+		}
+	}
+
+	assert.Equal(t, 50, picked)
+}
+
+func TestFailureBound(t *testing.T) {
+	// No failures - the bound is 1 - (1-confidence)^(1/n), close to the rule of three
+	assert.InDelta(t, 1-math.Pow(0.05, 0.01), FailureBound(100, 0, 0.95), 1e-9)
+	assert.InDelta(t, 0.0295, FailureBound(100, 0, 0.95), 1e-4)
+
+	// Known values of the one-sided Clopper-Pearson bound
+	assert.InDelta(t, 0.0466, FailureBound(100, 1, 0.95), 1e-4)
+	assert.InDelta(t, 0.0405, FailureBound(1000, 30, 0.95), 1e-4)
+
+	assert.Less(t, FailureBound(1000, 0, 0.95), FailureBound(100, 0, 0.95))
+	assert.Less(t, FailureBound(100, 0, 0.9), FailureBound(100, 0, 0.99))
+
+	assert.Equal(t, 1.0, FailureBound(0, 0, 0.95))
+	assert.Equal(t, 1.0, FailureBound(10, 10, 0.95))
+}
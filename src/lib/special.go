@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+/*
+Types of special files, as recorded in writer.FileInfo
+*/
+const (
+	TypeFIFO        = "fifo"
+	TypeSocket      = "socket"
+	TypeCharDevice  = "char-device"
+	TypeBlockDevice = "block-device"
+	TypeSymlink     = "symlink"   // only seen without following links, see os.Lstat
+	TypeIrregular   = "irregular" // file of a type unknown to Go
+)
+
+var (
+	lstatPath = os.Lstat    // maps to os.Lstat
+	readLink  = os.Readlink // maps to os.Readlink
+)
+
+// errSpecial indicates that a special file was not read
+var errSpecial = fmt.Errorf("(%s): special file, not read", pkgName)
+
+/*
+IsSpecialErr checks if an error was caused because a special file (a FIFO, socket, or
+device) was not read
+*/
+func IsSpecialErr(err error) bool {
+	return errors.Is(err, errSpecial)
+}
+
+/*
+FileType returns the type of a special file from its mode, one of the Type constants.
+Returns an empty string for regular files, and directories
+*/
+func FileType(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		return TypeFIFO
+	case mode&fs.ModeSocket != 0:
+		return TypeSocket
+	case mode&fs.ModeCharDevice != 0:
+		return TypeCharDevice
+	case mode&fs.ModeDevice != 0:
+		return TypeBlockDevice
+	case mode&fs.ModeIrregular != 0:
+		return TypeIrregular
+	}
+
+	return ""
+}
+
+/*
+DescribeSpecial returns the type of a special file, along with its device numbers for
+character, and block devices. Device numbers are nil for other files, and on platforms
+without them
+*/
+func DescribeSpecial(info os.FileInfo) (string, *writer.Device) {
+	kind := FileType(info.Mode())
+	if kind != TypeCharDevice && kind != TypeBlockDevice {
+		return kind, nil
+	}
+
+	major, minor, ok := deviceNumbers(info)
+	if !ok {
+		return kind, nil
+	}
+
+	return kind, &writer.Device{Major: major, Minor: minor}
+}
+
+/*
+LinkTarget returns the path a symbolic link points to, without following it
+*/
+func LinkTarget(path string) (string, error) {
+	target, err := readLink(path)
+	return target, errors.Wrapf(err, "(%s/LinkTarget)", pkgName)
+}
+
+/*
+OpenFile opens a file for reading, refusing special files - opening a FIFO blocks until
+something writes to it, and reading devices can block, or never end. With `devices`,
+block devices are opened as well. Returns an error that can be checked with
+IsSpecialErr for files that were not opened
+
+Files are opened without blocking, so a file replaced by a FIFO after being checked is
+still refused
+*/
+func OpenFile(path string, devices bool) (*os.File, error) {
+	info, err := statPath(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s/OpenFile)", pkgName)
+	} else if err = checkSpecial(info, devices); err != nil {
+		return nil, errors.Wrapf(err, "(%s/OpenFile): %s", pkgName, path)
+	}
+
+	file, err := openNonblock(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "(%s/OpenFile)", pkgName)
+	}
+
+	if info, err = file.Stat(); err == nil {
+		err = checkSpecial(info, devices)
+	}
+
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "(%s/OpenFile): %s", pkgName, path)
+	}
+
+	return file, nil
+}
+
+/*
+openRegular opens a file for reading, refusing all special files - see OpenFile
+*/
+func openRegular(path string) (*os.File, error) {
+	return OpenFile(path, false)
+}
+
+/*
+checkSpecial returns errSpecial for special files, other than block devices when
+`devices` is set
+*/
+func checkSpecial(info os.FileInfo, devices bool) error {
+	kind := FileType(info.Mode())
+	if kind == "" || (devices && kind == TypeBlockDevice) {
+		return nil
+	}
+
+	return errors.Wrapf(errSpecial, "%s", kind)
+}
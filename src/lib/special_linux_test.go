@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func TestOpenFile_FIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	require.NoError(t, syscall.Mkfifo(path, 0o600))
+
+	// Opening a FIFO without a writer would block forever
+	_, err := OpenFile(path, true)
+	assert.True(t, IsSpecialErr(err))
+
+	// Opened without blocking, as done after the check
+	file, err := openNonblock(path)
+	require.NoError(t, err)
+	_ = file.Close()
+
+	res := VerifyFile(&writer.FileInfo{Path: path}, ReadOptions{})
+	assert.Equal(t, report.StatusError, res.Status)
+	assert.Equal(t, "special file, not read", res.Error)
+}
+
+func TestWalkArchive_FIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo.zip")
+	require.NoError(t, syscall.Mkfifo(path, 0o600))
+
+	// FIFOs named as archives are refused, rather than blocking forever
+	err := WalkArchive(path, []string{AlgoCRC32}, func(*ArchiveEntry) error { return nil })
+	assert.True(t, IsSpecialErr(err))
+
+	assert.True(t, IsSpecialErr(CheckEmbedded(path)))
+}
+
+func TestVerifyFile_Special(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	require.NoError(t, syscall.Mkfifo(path, 0o600))
+
+	// Special files are compared by their type, without being opened
+	fifo := writer.FileInfo{Path: path, Type: TypeFIFO}
+	assert.Equal(t, report.Result{
+		Path: path, Status: report.StatusOK, Expected: "fifo", Actual: "fifo",
+	}, VerifyFile(&fifo, ReadOptions{}))
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	res := VerifyFile(&fifo, ReadOptions{})
+	assert.Equal(t, report.StatusModified, res.Status)
+	assert.Equal(t, "file", res.Actual)
+
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, report.StatusMissing, VerifyFile(&fifo, ReadOptions{}).Status)
+
+	// Devices are compared by their device numbers as well
+	null := writer.FileInfo{
+		Path: "/dev/null", Type: TypeCharDevice, Device: &writer.Device{Major: 1, Minor: 3},
+	}
+
+	assert.Equal(t, report.StatusOK, VerifyFile(&null, ReadOptions{}).Status)
+
+	null.Device.Minor = 5
+	res = VerifyFile(&null, ReadOptions{})
+	assert.Equal(t, report.StatusModified, res.Status)
+	assert.Equal(t, "char-device 1:5", res.Expected)
+	assert.Equal(t, "char-device 1:3", res.Actual)
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// modeInfo is a FileInfo with only a mode
+type modeInfo struct {
+	mode fs.FileMode
+}
+
+func (m modeInfo) Name() string       { return "test" }
+func (m modeInfo) Size() int64        { return 0 }
+func (m modeInfo) Mode() fs.FileMode  { return m.mode }
+func (m modeInfo) ModTime() time.Time { return time.Time{} }
+func (m modeInfo) IsDir() bool        { return m.mode.IsDir() }
+func (m modeInfo) Sys() interface{}   { return nil }
+
+func TestFileType(t *testing.T) {
+	for mode, expected := range map[fs.FileMode]string{
+		0o644:                             "",
+		fs.ModeDir | 0o755:                "",
+		fs.ModeSymlink | 0o777:            TypeSymlink,
+		fs.ModeNamedPipe | 0o644:          TypeFIFO,
+		fs.ModeSocket | 0o755:             TypeSocket,
+		fs.ModeDevice | fs.ModeCharDevice: TypeCharDevice,
+		fs.ModeDevice | 0o660:             TypeBlockDevice,
+		fs.ModeIrregular:                  TypeIrregular,
+	} {
+		assert.Equalf(t, expected, FileType(mode), "failed for %s", mode)
+	}
+}
+
+func TestCheckSpecial(t *testing.T) {
+	assert.NoError(t, checkSpecial(modeInfo{0o644}, false))
+	assert.True(t, IsSpecialErr(checkSpecial(modeInfo{fs.ModeNamedPipe}, true)))
+
+	// Block devices are only opened when enabled, character devices never are
+	block := modeInfo{fs.ModeDevice}
+	assert.True(t, IsSpecialErr(checkSpecial(block, false)))
+	assert.NoError(t, checkSpecial(block, true))
+
+	char := modeInfo{fs.ModeDevice | fs.ModeCharDevice}
+	assert.True(t, IsSpecialErr(checkSpecial(char, true)))
+	assert.Equal(t, "special file, not read", ErrorKind(checkSpecial(char, true)))
+}
+
+func TestDescribeSpecial(t *testing.T) {
+	kind, device := DescribeSpecial(modeInfo{0o644})
+	assert.Empty(t, kind)
+	assert.Nil(t, device)
+
+	if runtime.GOOS != "linux" {
+		t.Skip("device numbers are only known on linux")
+	}
+
+	info, err := os.Stat("/dev/null")
+	require.NoError(t, err)
+
+	kind, device = DescribeSpecial(info)
+	assert.Equal(t, TypeCharDevice, kind)
+	assert.Equal(t, &writer.Device{Major: 1, Minor: 3}, device)
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	file, err := OpenFile(path, false)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	sums, _, err := Checksum(file, []string{AlgoCRC32})
+	require.NoError(t, err)
+	assert.Equal(t, "cbf43926", sums[AlgoCRC32])
+
+	_, err = OpenFile(filepath.Join(t.TempDir(), "absent"), false)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	if runtime.GOOS != "windows" {
+		_, err = OpenFile("/dev/null", true)
+		assert.True(t, IsSpecialErr(err))
+	}
+}
+
+func TestVerifyFile_Device(t *testing.T) {
+	// Block devices are read as a stream, a regular file stands in for one
+	path := filepath.Join(t.TempDir(), "disk.img")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	entry := writer.FileInfo{
+		Path: path, Size: 9, Checksums: writer.Checksums{CRC32: "cbf43926"},
+		Type: TypeBlockDevice, LastMod: 1, // mtime is not used
+	}
+
+	assert.Equal(t, report.StatusOK, VerifyFile(&entry, ReadOptions{}).Status)
+
+	entry.Checksums.CRC32 = "00000000"
+	assert.Equal(t, report.StatusMismatch, VerifyFile(&entry, ReadOptions{}).Status)
+
+	entry.Path = filepath.Join(t.TempDir(), "absent")
+	assert.Equal(t, report.StatusMissing, VerifyFile(&entry, ReadOptions{}).Status)
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+// DefaultRetries is the number of times a file that changed while being read is read
+// again, before giving up on it
+const DefaultRetries = 3
+
+// errUnstable indicates that a file kept changing while being read
+var errUnstable = fmt.Errorf("(%s): file changed while being read", pkgName)
+
+/*
+IsUnstableErr checks if an error was caused because a file kept changing while being
+read, on every attempt
+*/
+func IsUnstableErr(err error) bool {
+	return errors.Is(err, errUnstable)
+}
+
+/*
+StableRead runs `read` on a file, comparing the size and mtime of the file before and
+after. A file that changed while being read leaves a result matching neither version
+(a torn read) - it is read again, up to `retries` more times
+
+Reads failing with an error are retried the same way if the file changed meanwhile -
+the error is likely caused by the change, such as a file truncated while being read.
+Errors from `read` are returned as is for files that did not change
+
+Returns the file info from before the last read, i.e. the version of the file the
+result belongs to. Returns an error that can be checked with IsUnstableErr if the file
+changed during every attempt
+*/
+func StableRead(
+	file *os.File, retries int, read func(info os.FileInfo) error,
+) (os.FileInfo, error) {
+	for attempt := 0; ; attempt++ {
+		before, err := file.Stat()
+		if err != nil {
+			return nil, errors.Wrapf(err, "(%s/StableRead)", pkgName)
+		}
+
+		readErr := read(before)
+
+		after, err := file.Stat()
+		if err != nil {
+			return nil, errors.Wrapf(err, "(%s/StableRead)", pkgName)
+		}
+
+		unchanged := before.Size() == after.Size() && before.ModTime().Equal(after.ModTime())
+		switch {
+		case unchanged && readErr != nil:
+			return nil, readErr
+		case unchanged:
+			return before, nil
+		case attempt >= retries:
+			return nil, errors.Wrapf(
+				errUnstable, "(%s/StableRead): %d attempts", pkgName, attempt+1,
+			)
+		}
+
+		logger.Debugf(
+			`(%s/StableRead): "%s" changed while being read, retrying`,
+			pkgName, file.Name(),
+		)
+	}
+}
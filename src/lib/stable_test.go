@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUnstableErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                               false,
+		errUnstable:                       true,
+		errors.Wrap(errUnstable, "test"):  true,
+		fmt.Errorf("(%s): test", pkgName): false,
+	} {
+		assert.Equal(t, expected, IsUnstableErr(err))
+	}
+}
+
+func TestStableRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+
+	defer func() { _ = file.Close() }()
+
+	// Changes the file during the first `changes` reads
+	modTime := time.Unix(1_600_000_000, 0)
+	reads := 0
+	readWith := func(changes int) func(os.FileInfo) error {
+		reads = 0
+		return func(os.FileInfo) error {
+			if reads++; reads <= changes {
+				modTime = modTime.Add(time.Second)
+				return os.Chtimes(path, modTime, modTime)
+			}
+
+			return nil
+		}
+	}
+
+	info, err := StableRead(file, 3, readWith(0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(checkInput)), info.Size())
+	assert.Equal(t, 1, reads)
+
+	// The file info belongs to the version of the file read last
+	info, err = StableRead(file, 3, readWith(2))
+	require.NoError(t, err)
+	assert.Equal(t, 3, reads)
+	assert.Equal(t, modTime, info.ModTime())
+
+	_, err = StableRead(file, 3, readWith(4))
+	assert.True(t, IsUnstableErr(err))
+	assert.Equal(t, 4, reads)
+
+	_, err = StableRead(file, 0, readWith(1))
+	assert.True(t, IsUnstableErr(err))
+
+	// Errors while reading are returned as is, unless the file changed meanwhile
+	_, err = StableRead(file, 3, func(os.FileInfo) error { return os.ErrClosed })
+	assert.ErrorIs(t, err, os.ErrClosed)
+
+	failWith := func(changes int) func(os.FileInfo) error {
+		read := readWith(changes)
+		return func(info os.FileInfo) error {
+			if err := read(info); err != nil || reads <= changes {
+				return os.ErrClosed
+			}
+
+			return nil
+		}
+	}
+
+	_, err = StableRead(file, 3, failWith(2))
+	require.NoError(t, err)
+	assert.Equal(t, 3, reads)
+
+	_, err = StableRead(file, 3, failWith(4))
+	assert.True(t, IsUnstableErr(err))
+	assert.Equal(t, 4, reads)
+
+	require.NoError(t, file.Close())
+	_, err = StableRead(file, 3, readWith(0))
+	assert.Error(t, err)
+}
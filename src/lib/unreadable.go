@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+const (
+	// DefaultIORetries is the number of times a read failing with an I/O error is tried
+	// again, before mapping the unreadable parts of the file
+	DefaultIORetries = 3
+
+	// ProbeSize is the size of the blocks (in bytes) read to map unreadable parts of a
+	// file, i.e. the resolution of the mapped ranges
+	ProbeSize = 4 << 10
+
+	// probeChunk is the size of the chunks read to find unreadable parts of a file,
+	// only chunks that fail to be read are probed block by block
+	probeChunk = 1 << 20
+
+	// maxListedRanges is the maximum number of ranges listed in error messages
+	maxListedRanges = 8
+)
+
+var (
+	sleep      = time.Sleep             // maps to time.Sleep
+	retryDelay = 100 * time.Millisecond // delay before the first retry, doubled after
+)
+
+/*
+UnreadableError indicates that parts of a file could not be read due to I/O errors,
+even after retrying - listing the byte ranges that failed. Commonly a sign of a failing
+disk
+*/
+type UnreadableError struct {
+	// Ranges contains the byte ranges that could not be read, in order
+	Ranges []writer.ByteRange
+
+	// Err contains the error from the last attempt at reading the file
+	Err error
+}
+
+func (e *UnreadableError) Error() string {
+	if len(e.Ranges) == 0 {
+		return "I/O error"
+	}
+
+	listed := make([]string, 0, maxListedRanges)
+	for i := 0; i < len(e.Ranges) && i < maxListedRanges; i++ {
+		listed = append(listed, e.Ranges[i].String())
+	}
+
+	msg := "I/O error, unreadable bytes " + strings.Join(listed, ", ")
+	if len(e.Ranges) > maxListedRanges {
+		msg += fmt.Sprintf(" and %d more ranges", len(e.Ranges)-maxListedRanges)
+	}
+
+	return msg
+}
+
+func (e *UnreadableError) Unwrap() error {
+	return e.Err
+}
+
+/*
+UnreadableRanges returns the byte ranges that could not be read from an error returned
+by RetryIO, nil for other errors
+*/
+func UnreadableRanges(err error) []writer.ByteRange {
+	var unreadable *UnreadableError
+	if errors.As(err, &unreadable) {
+		return unreadable.Ranges
+	}
+
+	return nil
+}
+
+/*
+RetryIO runs `read`, running it again if it fails with an I/O error (EIO) - up to
+`retries` more times, with a delay doubling after each attempt. Errors other than EIO
+are returned as is
+
+If all attempts fail with EIO, the first `size` bytes of the reader are read in small
+blocks to map which parts can't be read. Returns an UnreadableError in this case, use
+UnreadableRanges to fetch the ranges
+*/
+func RetryIO(reader io.ReaderAt, size int64, retries int, read func() error) error {
+	err := read()
+
+	delay := retryDelay
+	for attempt := 0; attempt < retries && errors.Is(err, syscall.EIO); attempt++ {
+		logger.Debugf(
+			"(%s/RetryIO): I/O error, retrying in %s: %v", pkgName, delay, err,
+		)
+
+		sleep(delay)
+		delay *= 2
+
+		err = read()
+	}
+
+	if !errors.Is(err, syscall.EIO) {
+		return err
+	}
+
+	return &UnreadableError{Ranges: MapUnreadable(reader, size, ProbeSize), Err: err}
+}
+
+/*
+MapUnreadable reads the first `size` bytes of the reader, returning the byte ranges
+that could not be read - with a resolution of `blockSize` bytes. Adjacent ranges are
+merged together. Data is read in large chunks, with only chunks that fail being read
+again block by block
+*/
+func MapUnreadable(reader io.ReaderAt, size, blockSize int64) []writer.ByteRange {
+	if blockSize < 1 {
+		blockSize = ProbeSize
+	}
+
+	var (
+		ranges []writer.ByteRange
+		buf    = make([]byte, probeChunk)
+	)
+
+	for offset := int64(0); offset < size; offset += probeChunk {
+		chunk := min64(probeChunk, size-offset)
+		if readable(reader, buf[:chunk], offset) {
+			continue
+		}
+
+		for start := offset; start < offset+chunk; start += blockSize {
+			end := start + min64(blockSize, offset+chunk-start)
+			if readable(reader, buf[:end-start], start) {
+				continue
+			}
+
+			if last := len(ranges) - 1; last >= 0 && ranges[last].End == start {
+				ranges[last].End = end
+			} else {
+				ranges = append(ranges, writer.ByteRange{Start: start, End: end})
+			}
+		}
+	}
+
+	return ranges
+}
+
+/*
+readable checks if the buffer can be filled from the reader at the offset. Reaching the
+end of the data (i.e. the file shrank) is not treated as a failure
+*/
+func readable(reader io.ReaderAt, buf []byte, offset int64) bool {
+	_, err := reader.ReadAt(buf, offset)
+	return err == nil || errors.Is(err, io.EOF)
+}
+
+// min64 returns the smaller of two values
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
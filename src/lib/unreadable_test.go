@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+// badReader mimics a disk with unreadable regions - reads overlapping a bad range fail
+// with EIO, as with failing sectors
+type badReader struct {
+	size int64
+	bad  []writer.ByteRange
+}
+
+func (r *badReader) ReadAt(buf []byte, offset int64) (int, error) {
+	end := offset + int64(len(buf))
+	for _, bad := range r.bad {
+		if offset < bad.End && bad.Start < end {
+			return 0, &fs.PathError{Op: "read", Path: "disk", Err: syscall.EIO}
+		}
+	}
+
+	if end > r.size {
+		return int(r.size - offset), io.EOF
+	}
+
+	return len(buf), nil
+}
+
+func TestMapUnreadable(t *testing.T) {
+	reader := &badReader{
+		size: 3<<20 + 100,
+		bad: []writer.ByteRange{
+			{Start: 5000, End: 5001},
+			{Start: 8192, End: 8193}, // adjacent to the block above, merged
+			{Start: 2<<20 + 10, End: 2<<20 + 9000},
+			{Start: 3<<20 + 50, End: 3<<20 + 60}, // within the last, partial block
+		},
+	}
+
+	assert.Equal(t, []writer.ByteRange{
+		{Start: 4096, End: 12288},
+		{Start: 2 << 20, End: 2<<20 + 12288},
+		{Start: 3 << 20, End: 3<<20 + 100},
+	}, MapUnreadable(reader, reader.size, ProbeSize))
+
+	assert.Empty(t, MapUnreadable(&badReader{size: 10 << 20}, 10<<20, 0))
+	assert.Empty(t, MapUnreadable(reader, 0, ProbeSize))
+}
+
+func TestRetryIO(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+
+	var delays []time.Duration
+	sleep = func(delay time.Duration) { delays = append(delays, delay) }
+
+	reader := &badReader{size: 8192, bad: []writer.ByteRange{{Start: 10, End: 20}}}
+
+	// Reads failing with EIO are retried with growing delays
+	attempts := 0
+	failFor := func(failures int, err error) func() error {
+		attempts = 0
+		return func() error {
+			if attempts++; attempts <= failures {
+				return &fs.PathError{Op: "read", Path: "disk", Err: err}
+			}
+
+			return nil
+		}
+	}
+
+	assert.NoError(t, RetryIO(reader, reader.size, 3, failFor(2, syscall.EIO)))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []time.Duration{retryDelay, 2 * retryDelay}, delays)
+
+	// Other errors are returned right away
+	err := RetryIO(reader, reader.size, 3, failFor(1, os.ErrPermission))
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.Equal(t, 1, attempts)
+	assert.Nil(t, UnreadableRanges(err))
+
+	// Once retries run out, unreadable ranges are mapped
+	err = RetryIO(reader, reader.size, 3, failFor(10, syscall.EIO))
+	assert.Equal(t, 4, attempts)
+	assert.ErrorIs(t, err, syscall.EIO)
+	assert.Equal(t, []writer.ByteRange{{Start: 0, End: 4096}}, UnreadableRanges(err))
+	assert.Equal(t, "I/O error, unreadable bytes 0-4096", ErrorKind(err))
+}
+
+func TestUnreadableError(t *testing.T) {
+	err := &UnreadableError{Err: syscall.EIO}
+	assert.Equal(t, "I/O error", err.Error())
+
+	for i := int64(0); i < 10; i++ {
+		err.Ranges = append(err.Ranges, writer.ByteRange{Start: i * 10, End: i*10 + 5})
+	}
+
+	assert.True(t, strings.HasPrefix(err.Error(), "I/O error, unreadable bytes 0-5, "))
+	assert.True(t, strings.HasSuffix(err.Error(), "70-75 and 2 more ranges"))
+}
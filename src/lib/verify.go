@@ -0,0 +1,213 @@
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+/*
+VerifyFile verifies a file against its entry in an output file, by computing its CRC32
+and comparing it (along with the size) to the entry. The file is read with the options,
+and read again if it changes while being read - see StableRead. Reads failing with I/O
+errors are retried, and mapped to the unreadable byte ranges - see RetryIO
+
+Entries with block checksums are read along with the checksum of each block, see
+BlockChecksums. Large files are hashed in parallel with the workers from the options,
+see ChecksumAt. Files that do not match report the byte ranges that differ
+
+The outcome is returned as a report.Result - files that no longer exist are reported as
+missing, and files that can't be read as errors. Entries for block devices with a
+checksum are read as a whole, see OpenFile. Other entries for special files (including
+symbolic links) are never read, see verifySpecial. Files that do not match are
+classified by their size and mtime, see Classify
+*/
+func VerifyFile(entry *writer.FileInfo, opts ReadOptions) report.Result {
+	res := report.Result{Path: entry.Path, Expected: entry.Checksums.CRC32}
+	switch {
+	case entry.Type == TypeBlockDevice && entry.Checksums.CRC32 != "":
+		return verifyDevice(entry, res)
+	case entry.Type != "":
+		return verifySpecial(entry, res)
+	}
+
+	file, err := openPath(entry.Path)
+	if err != nil {
+		return failedResult(res, err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var (
+		sums   map[string]string
+		blocks []string
+		algos  = []string{AlgoCRC32}
+	)
+
+	info, err := StableRead(file, DefaultRetries, func(info os.FileInfo) error {
+		return RetryIO(file, info.Size(), DefaultIORetries, func() (err error) {
+			if entry.BlockSize > 0 && len(entry.Blocks) > 0 {
+				sums, blocks, err = BlockChecksums(file, info.Size(), algos, entry.BlockSize)
+				return err
+			}
+
+			if info.Size() >= ParallelThreshold && opts.Workers > 1 {
+				sums, err = ChecksumAt(file, info.Size(), algos, opts.Workers)
+				return err
+			}
+
+			sums, _, err = ChecksumFile(file, info.Size(), algos, opts)
+			return err
+		})
+	})
+
+	if err != nil {
+		return failedResult(res, err)
+	}
+
+	res.Actual = sums[AlgoCRC32]
+	if res.Actual == res.Expected && info.Size() == entry.Size {
+		res.Status = report.StatusOK
+		return res
+	}
+
+	res.Status = Classify(entry, info)
+	if blocks != nil {
+		res.Ranges = diffRanges(entry, blocks, info.Size())
+	}
+
+	return res
+}
+
+/*
+diffRanges returns the byte ranges that differ between the block checksums recorded for
+a file, and the checksums computed for a file of the given size - see DiffBlocks
+*/
+func diffRanges(entry *writer.FileInfo, blocks []string, size int64) []string {
+	if entry.Size > size {
+		size = entry.Size
+	}
+
+	diff := DiffBlocks(entry.Blocks, blocks, entry.BlockSize, size)
+	ranges := make([]string, 0, len(diff))
+	for _, r := range diff {
+		ranges = append(ranges, r.String())
+	}
+
+	return ranges
+}
+
+/*
+Classify decides why a file no longer matches its entry in an output file. A file with
+a different size or mtime was modified the usual way, and is reported as modified.
+Otherwise, the contents changed without the size or mtime being updated - which
+ordinary writes never do, making it probable silent corruption, reported as a mismatch
+
+Entries without an mtime (LastMod of zero) are only classified by their size
+*/
+func Classify(entry *writer.FileInfo, info fs.FileInfo) report.Status {
+	if info.Size() != entry.Size {
+		return report.StatusModified
+	}
+
+	if entry.LastMod != 0 && info.ModTime().Unix() != entry.LastMod {
+		return report.StatusModified
+	}
+
+	return report.StatusMismatch
+}
+
+/*
+verifyDevice verifies a block device hashed along with files, reading it until the
+end. Devices have no meaningful mtime, differences are always reported as a mismatch
+*/
+func verifyDevice(entry *writer.FileInfo, res report.Result) report.Result {
+	file, err := OpenFile(entry.Path, true)
+	if err != nil {
+		return failedResult(res, err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	sums, size, err := Checksum(file, []string{AlgoCRC32})
+	if err != nil {
+		return failedResult(res, err)
+	}
+
+	res.Actual, res.Status = sums[AlgoCRC32], report.StatusOK
+	if res.Actual != res.Expected || size != entry.Size {
+		res.Status = report.StatusMismatch
+	}
+
+	return res
+}
+
+/*
+verifySpecial verifies a special file recorded without a checksum, without opening it -
+comparing its current type, device numbers, and the target of symbolic links, to the
+entry. Links are never followed. Special files replaced by a different type, device, or
+target, are reported as modified
+*/
+func verifySpecial(entry *writer.FileInfo, res report.Result) report.Result {
+	info, err := lstatPath(entry.Path)
+	if err != nil {
+		return failedResult(res, errors.Wrapf(err, "(%s/verifySpecial)", pkgName))
+	}
+
+	current := writer.FileInfo{}
+	current.Type, current.Device = DescribeSpecial(info)
+	if current.Type == TypeSymlink {
+		if current.Target, err = LinkTarget(entry.Path); err != nil {
+			return failedResult(res, err)
+		}
+	}
+
+	res.Expected, res.Actual = describeType(entry), describeType(&current)
+
+	moved := entry.Device != nil && current.Device != nil &&
+		*current.Device != *entry.Device
+
+	res.Status = report.StatusOK
+	if current.Type != entry.Type || current.Target != entry.Target || moved {
+		res.Status = report.StatusModified
+	}
+
+	return res
+}
+
+/*
+describeType formats the type of a file along with its device numbers, or the target
+of a symbolic link, if known - i.e. `char-device 1:3`, or `symlink -> data`. Regular
+files are described as `file`
+*/
+func describeType(entry *writer.FileInfo) string {
+	switch {
+	case entry.Type == "":
+		return "file"
+	case entry.Type == TypeSymlink:
+		return entry.Type + " -> " + entry.Target
+	case entry.Device == nil:
+		return entry.Type
+	}
+
+	return fmt.Sprintf("%s %d:%d", entry.Type, entry.Device.Major, entry.Device.Minor)
+}
+
+/*
+failedResult marks a result as missing or failed, based on the error
+*/
+func failedResult(res report.Result, err error) report.Result {
+	if errors.Is(err, fs.ErrNotExist) {
+		res.Status = report.StatusMissing
+	} else {
+		res.Status = report.StatusError
+		res.Error = ErrorKind(err)
+	}
+
+	return res
+}
@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/notsatan/crcgen/src/report"
+	"github.com/notsatan/crcgen/src/writer"
+)
+
+func TestVerifyFile(t *testing.T) {
+	defer func() { openPath = openRegular }()
+
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	entry := writer.FileInfo{
+		Path:      path,
+		Checksums: writer.Checksums{CRC32: checkValues[AlgoCRC32]},
+		Size:      int64(len(checkInput)),
+	}
+
+	assert.Equal(t, report.Result{
+		Path:     path,
+		Status:   report.StatusOK,
+		Expected: checkValues[AlgoCRC32],
+		Actual:   checkValues[AlgoCRC32],
+	}, VerifyFile(&entry, ReadOptions{}))
+
+	// Differences in the checksum alone are mismatches, changes to the size are not
+	var (
+		corrupt  = writer.Checksums{CRC32: "00000000"}
+		mismatch = writer.FileInfo{Path: path, Checksums: corrupt, Size: entry.Size}
+		resized  = writer.FileInfo{Path: path, Checksums: entry.Checksums, Size: 1}
+	)
+
+	for changed, status := range map[*writer.FileInfo]report.Status{
+		&mismatch: report.StatusMismatch, &resized: report.StatusModified,
+	} {
+		res := VerifyFile(changed, ReadOptions{})
+		assert.Equal(t, status, res.Status)
+		assert.Equal(t, checkValues[AlgoCRC32], res.Actual)
+	}
+
+	// Changes along with the mtime are modifications
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	modified := entry
+	modified.Checksums.CRC32 = "00000000"
+	modified.LastMod = info.ModTime().Unix() - 60
+	assert.Equal(t, report.StatusModified, VerifyFile(&modified, ReadOptions{}).Status)
+
+	modified.LastMod = info.ModTime().Unix()
+	assert.Equal(t, report.StatusMismatch, VerifyFile(&modified, ReadOptions{}).Status)
+
+	missing := writer.FileInfo{Path: filepath.Join(t.TempDir(), "missing.txt")}
+	assert.Equal(t, report.StatusMissing, VerifyFile(&missing, ReadOptions{}).Status)
+
+	openPath = func(path string) (*os.File, error) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrPermission}
+	}
+
+	res := VerifyFile(&entry, ReadOptions{})
+	assert.Equal(t, report.StatusError, res.Status)
+	assert.Equal(t, "permission denied", res.Error)
+}
+
+func TestVerifyFile_Parallel(t *testing.T) {
+	defer func(threshold int64) { ParallelThreshold = threshold }(ParallelThreshold)
+
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	entry := writer.FileInfo{
+		Path:      path,
+		Checksums: writer.Checksums{CRC32: checkValues[AlgoCRC32]},
+		Size:      int64(len(checkInput)),
+	}
+
+	// Checksums combined from segments should match the recorded checksum
+	ParallelThreshold = 1
+	for _, workers := range []int{2, 4} {
+		res := VerifyFile(&entry, ReadOptions{Workers: workers})
+		assert.Equalf(t, report.StatusOK, res.Status, "failed for %d workers", workers)
+		assert.Equal(t, checkValues[AlgoCRC32], res.Actual)
+	}
+
+	entry.Checksums.CRC32 = "00000000"
+	res := VerifyFile(&entry, ReadOptions{Workers: 4})
+	assert.Equal(t, report.StatusMismatch, res.Status)
+	assert.Equal(t, checkValues[AlgoCRC32], res.Actual)
+}
+
+func TestVerifyFile_Blocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.bin")
+	data := make([]byte, 100)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+
+	sums, blocks, err := BlockChecksums(file, 100, []string{AlgoCRC32}, 10)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	entry := writer.FileInfo{
+		Path:      path,
+		Checksums: writer.Checksums{CRC32: sums[AlgoCRC32]},
+		Size:      100,
+		BlockSize: 10,
+		Blocks:    blocks,
+	}
+
+	assert.Equal(t, report.StatusOK, VerifyFile(&entry, ReadOptions{}).Status)
+
+	// Corrupt bytes in the 3rd, 4th and 8th blocks, and extend the file
+	data[25], data[31], data[79] = 1, 1, 1
+	require.NoError(t, os.WriteFile(path, append(data, 0, 0, 0), 0o600))
+
+	res := VerifyFile(&entry, ReadOptions{})
+	assert.Equal(t, report.StatusModified, res.Status)
+	assert.Equal(t, []string{"20-40", "70-80", "100-103"}, res.Ranges)
+}
+
+func TestClassify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	modTime := time.Unix(1_600_000_000, 0)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	for lastMod, expected := range map[int64]report.Status{
+		modTime.Unix():      report.StatusMismatch,
+		modTime.Unix() - 1:  report.StatusModified,
+		modTime.Unix() + 60: report.StatusModified,
+		0:                   report.StatusMismatch, // unknown mtime
+	} {
+		entry := writer.FileInfo{Path: path, Size: info.Size(), LastMod: lastMod}
+		assert.Equalf(t, expected, Classify(&entry, info), "failed for %d", lastMod)
+	}
+
+	// Files with a different size were modified, whatever their mtime
+	for _, lastMod := range []int64{modTime.Unix(), 0} {
+		entry := writer.FileInfo{Path: path, Size: info.Size() + 1, LastMod: lastMod}
+		assert.Equalf(
+			t, report.StatusModified, Classify(&entry, info), "failed for %d", lastMod,
+		)
+	}
+}
+
+func TestVerifyFile_Symlink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "link")
+	require.NoError(t, os.Symlink("missing", path))
+
+	// Links are compared by their target, without being followed
+	link := writer.FileInfo{Path: path, Type: TypeSymlink, Target: "missing"}
+	assert.Equal(t, report.Result{
+		Path:     path,
+		Status:   report.StatusOK,
+		Expected: "symlink -> missing",
+		Actual:   "symlink -> missing",
+	}, VerifyFile(&link, ReadOptions{}))
+
+	link.Target = "other"
+	assert.Equal(t, report.StatusModified, VerifyFile(&link, ReadOptions{}).Status)
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	res := VerifyFile(&link, ReadOptions{})
+	assert.Equal(t, report.StatusModified, res.Status)
+	assert.Equal(t, "file", res.Actual)
+}
@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Names of extended attributes storing checksums - each checksum is stored in an attribute
+named after its algorithm (`user.crcgen.crc32`), along with the mtime of the file at
+the time checksums were computed
+*/
+const (
+	xattrPrefix = "user.crcgen."
+	xattrMtime  = xattrPrefix + "mtime"
+)
+
+/*
+Custom errors
+*/
+var (
+	// errNoXattrs indicates that a file does not carry checksums in extended attributes
+	errNoXattrs = fmt.Errorf("(%s): no checksums in extended attributes", pkgName)
+
+	// errXattrUnsupported indicates that extended attributes are not supported by the
+	// platform, or the file system
+	errXattrUnsupported = fmt.Errorf("(%s): extended attributes not supported", pkgName)
+)
+
+/*
+IsNoXattrsErr checks if an error was caused because a file does not carry checksums in
+its extended attributes
+*/
+func IsNoXattrsErr(err error) bool {
+	return errors.Is(err, errNoXattrs)
+}
+
+/*
+IsXattrUnsupportedErr checks if an error was caused because extended attributes are not
+supported by the platform, or the file system
+*/
+func IsXattrUnsupportedErr(err error) bool {
+	return errors.Is(err, errXattrUnsupported)
+}
+
+/*
+XattrSums contains checksums stored in the extended attributes of a file
+*/
+type XattrSums struct {
+	// MtimeNs contains the mtime of the file (in ns) when the checksums were computed
+	MtimeNs int64
+
+	// Checksums maps each algorithm to the checksum of the file
+	Checksums map[string]string
+}
+
+/*
+Fresh checks if the checksums are still valid for the file, i.e. the file has not been
+modified since they were computed
+*/
+func (sums *XattrSums) Fresh(info os.FileInfo) bool {
+	return info.ModTime().UnixNano() == sums.MtimeNs
+}
+
+/*
+WriteXattrs stores checksums in the extended attributes of a file, replacing checksums
+stored earlier. Returns an error that can be checked with IsXattrUnsupportedErr if the
+file system does not support extended attributes
+*/
+func WriteXattrs(path string, sums XattrSums) error {
+	if err := ClearXattrs(path); err != nil {
+		return err
+	}
+
+	for algo, sum := range sums.Checksums {
+		if err := setXattr(path, xattrPrefix+algo, []byte(sum)); err != nil {
+			return errors.Wrapf(err, "(%s/WriteXattrs)", pkgName)
+		}
+	}
+
+	// The mtime is written last, checksums are ignored without it
+	mtime := []byte(strconv.FormatInt(sums.MtimeNs, 10))
+	return errors.Wrapf(setXattr(path, xattrMtime, mtime), "(%s/WriteXattrs)", pkgName)
+}
+
+/*
+ReadXattrs reads checksums stored in the extended attributes of a file. Returns an
+error that can be checked with IsNoXattrsErr if the file does not carry checksums
+*/
+func ReadXattrs(path string) (XattrSums, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return XattrSums{}, errors.Wrapf(err, "(%s/ReadXattrs)", pkgName)
+	}
+
+	sums := XattrSums{Checksums: map[string]string{}}
+	hasMtime := false
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+
+		value, err := getXattr(path, name)
+		if err != nil {
+			return XattrSums{}, errors.Wrapf(err, "(%s/ReadXattrs)", pkgName)
+		}
+
+		if name == xattrMtime {
+			sums.MtimeNs, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return XattrSums{}, errors.Wrapf(err, "(%s/ReadXattrs): mtime", pkgName)
+			}
+
+			hasMtime = true
+		} else if algo := strings.TrimPrefix(name, xattrPrefix); algorithms[algo] != nil {
+			sums.Checksums[algo] = string(value)
+		}
+	}
+
+	if !hasMtime || len(sums.Checksums) == 0 {
+		return XattrSums{}, errors.Wrapf(errNoXattrs, "(%s/ReadXattrs)", pkgName)
+	}
+
+	return sums, nil
+}
+
+/*
+ClearXattrs removes all checksums stored in the extended attributes of a file, other
+extended attributes are left untouched
+*/
+func ClearXattrs(path string) error {
+	names, err := listXattrs(path)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/ClearXattrs)", pkgName)
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+
+		if err = removeXattr(path, name); err != nil {
+			return errors.Wrapf(err, "(%s/ClearXattrs)", pkgName)
+		}
+	}
+
+	return nil
+}
+
+/*
+TrustedXattrs returns checksums for each algorithm from the extended attributes of a
+file, as long as the file was not modified since they were computed. Returns false if
+any checksum is missing, or stale - the file has to be read in that case
+*/
+func TrustedXattrs(path string, info os.FileInfo, algos []string) (
+	map[string]string, bool,
+) {
+	sums, err := ReadXattrs(path)
+	if err != nil || !sums.Fresh(info) {
+		return nil, false
+	}
+
+	trusted := make(map[string]string, len(algos))
+	for _, algo := range algos {
+		algo = strings.ToLower(strings.TrimSpace(algo))
+
+		sum, ok := sums.Checksums[algo]
+		if !ok {
+			return nil, false
+		}
+
+		trusted[algo] = sum
+	}
+
+	return trusted, len(trusted) > 0
+}
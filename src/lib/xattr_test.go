@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xattrFile creates a file to store checksums on, skipping the test if the platform
+// or the file system does not support extended attributes
+func xattrFile(t *testing.T) (string, os.FileInfo) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "check.txt")
+	require.NoError(t, os.WriteFile(path, []byte(checkInput), 0o600))
+
+	if err := setXattr(path, "user.test", []byte("1")); IsXattrUnsupportedErr(err) {
+		t.Skip("extended attributes not supported")
+	} else {
+		require.NoError(t, err)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	return path, info
+}
+
+func TestIsXattrErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                              false,
+		errNoXattrs:                      true,
+		errors.Wrap(errNoXattrs, "test"): true,
+		errXattrUnsupported:              false,
+	} {
+		assert.Equal(t, expected, IsNoXattrsErr(err))
+	}
+
+	assert.True(t, IsXattrUnsupportedErr(errors.Wrap(errXattrUnsupported, "test")))
+	assert.False(t, IsXattrUnsupportedErr(errNoXattrs))
+}
+
+func TestXattrs(t *testing.T) {
+	path, info := xattrFile(t)
+
+	_, err := ReadXattrs(path)
+	assert.True(t, IsNoXattrsErr(err))
+
+	sums := XattrSums{
+		MtimeNs:   info.ModTime().UnixNano(),
+		Checksums: map[string]string{AlgoCRC32: checkValues[AlgoCRC32]},
+	}
+
+	require.NoError(t, WriteXattrs(path, sums))
+
+	stored, err := ReadXattrs(path)
+	require.NoError(t, err)
+	assert.Equal(t, sums, stored)
+	assert.True(t, stored.Fresh(info))
+
+	// Writing again replaces earlier checksums
+	sums.Checksums = map[string]string{AlgoCRC32C: checkValues[AlgoCRC32C]}
+	require.NoError(t, WriteXattrs(path, sums))
+
+	stored, err = ReadXattrs(path)
+	require.NoError(t, err)
+	assert.Equal(t, sums, stored)
+
+	// Clearing leaves other attributes untouched
+	require.NoError(t, ClearXattrs(path))
+	_, err = ReadXattrs(path)
+	assert.True(t, IsNoXattrsErr(err))
+
+	value, err := getXattr(path, "user.test")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	// Missing files fail
+	missing := filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, WriteXattrs(missing, sums))
+	assert.Error(t, ClearXattrs(missing))
+
+	_, err = ReadXattrs(missing)
+	assert.False(t, IsNoXattrsErr(err))
+}
+
+func TestTrustedXattrs(t *testing.T) {
+	path, info := xattrFile(t)
+	algos := []string{AlgoCRC32, AlgoCRC32C}
+
+	_, ok := TrustedXattrs(path, info, algos)
+	assert.False(t, ok)
+
+	require.NoError(t, WriteXattrs(path, XattrSums{
+		MtimeNs:   info.ModTime().UnixNano(),
+		Checksums: map[string]string{AlgoCRC32: "00000000", AlgoCRC32C: "11111111"},
+	}))
+
+	sums, ok := TrustedXattrs(path, info, []string{" CRC32 ", AlgoCRC32C})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{AlgoCRC32: "00000000", AlgoCRC32C: "11111111"}, sums)
+
+	// Algorithms not stored, or checksums older than the file can't be trusted
+	_, ok = TrustedXattrs(path, info, []string{AlgoSHA1})
+	assert.False(t, ok)
+
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	_, ok = TrustedXattrs(path, info, algos)
+	assert.False(t, ok)
+}
@@ -0,0 +1,294 @@
+/*
+Package report writes the results of verifying files against an output file as reports
+that can be consumed by other tools - JUnit XML for CI test UIs, and a JSON summary
+
+Use the function report.Write to write a report, the format is picked based on the
+extension of the report file
+*/
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+const pkgName = "report"
+
+// errUnknownFormat indicates that no report format matches the extension of the file
+var errUnknownFormat = fmt.Errorf("(%s): unknown report format", pkgName)
+
+var osWriteFile = os.WriteFile // maps to os.WriteFile
+
+/*
+IsUnknownFormatErr checks if an error was caused because the report format could not be
+detected from the extension of the report file
+*/
+func IsUnknownFormatErr(err error) bool {
+	return errors.Is(err, errUnknownFormat)
+}
+
+/*
+Status indicates the outcome of verifying a single file, or directory
+*/
+type Status string
+
+const (
+	StatusOK       Status = "ok"       // checksums match
+	StatusMismatch Status = "mismatch" // checksums differ, while the mtime is unchanged
+	StatusModified Status = "modified" // file was modified after checksums were stored
+	StatusMissing  Status = "missing"  // file no longer exists
+	StatusError    Status = "error"    // file could not be verified
+
+	// StatusUnexpected marks an empty directory, or a file within an archive, that was
+	// not recorded in the output file
+	StatusUnexpected Status = "unexpected"
+)
+
+/*
+Result contains the outcome of verifying a single file
+*/
+type Result struct {
+	// Path contains the full path to the file
+	Path string
+
+	// Status indicates the outcome of the verification
+	Status Status
+
+	// Expected, and Actual contain the checksum from the output file, and the checksum
+	// computed during the verification respectively. Empty when not applicable
+	Expected string `json:",omitempty"`
+	Actual   string `json:",omitempty"`
+
+	// Error describes why the file could not be verified
+	Error string `json:",omitempty"`
+
+	// Ranges contains the byte ranges that differ (as `start-end`), for files with
+	// block checksums that no longer match
+	Ranges []string `json:",omitempty"`
+
+	// Drift describes changes to the permissions, ownership, or extended attributes of
+	// the file - independent of the status, which only reflects the contents
+	Drift []string `json:",omitempty"`
+
+	// Dir marks results for directories, rather than files
+	Dir bool `json:",omitempty"`
+}
+
+/*
+Summary aggregates results from a verification run
+*/
+type Summary struct {
+	Total    int
+	Passed   int
+	Mismatch int
+	Modified int
+	Missing  int
+	Errors   int
+
+	// Unexpected counts empty directories, and files within archives, that were not
+	// recorded in the output file
+	Unexpected int `json:",omitempty"`
+
+	// Drifted counts files with changes to their metadata, irrespective of their status
+	Drifted int
+
+	Results []Result
+}
+
+/*
+Summarize counts the results for each status, and bundles them into a Summary
+*/
+func Summarize(results []Result) Summary {
+	summary := Summary{Total: len(results), Results: results}
+	for i := range results {
+		switch results[i].Status {
+		case StatusOK:
+			summary.Passed++
+		case StatusMismatch:
+			summary.Mismatch++
+		case StatusModified:
+			summary.Modified++
+		case StatusMissing:
+			summary.Missing++
+		case StatusError:
+			summary.Errors++
+		case StatusUnexpected:
+			summary.Unexpected++
+		}
+
+		if len(results[i].Drift) > 0 {
+			summary.Drifted++
+		}
+	}
+
+	return summary
+}
+
+// formats maps report file extensions to the function generating the report. Keys do
+// not contain periods, and are stored in lower-case
+var formats = map[string]func(name string, summary *Summary) ([]byte, error){
+	"json": marshalJSON,
+	"xml":  marshalJUnit,
+}
+
+/*
+Write writes a report for the results to the path, the format of the report is detected
+from the file extension - `.xml` generates a JUnit XML report, while `.json` generates
+a JSON summary. Use IsUnknownFormatErr to check for unsupported extensions
+
+The parameter `name` is used to identify the verification run in the report
+*/
+func Write(path, name string, results []Result) error {
+	marshal, err := format(path)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/Write)", pkgName)
+	}
+
+	summary := Summarize(results)
+	data, err := marshal(name, &summary)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/Write)", pkgName)
+	}
+
+	if err = osWriteFile(path, data, 0o644); err != nil {
+		logger.Warnf("(%s/Write): failed to write report: %v", pkgName, err)
+		return errors.Wrapf(err, "(%s/Write)", pkgName)
+	}
+
+	return nil
+}
+
+/*
+CheckFormat checks if a report can be written to the path, i.e. its extension matches
+a report format - see Write. Use IsUnknownFormatErr to check for unsupported extensions
+*/
+func CheckFormat(path string) error {
+	_, err := format(path)
+	return errors.Wrapf(err, "(%s/CheckFormat)", pkgName)
+}
+
+/*
+format returns the function generating reports for the extension of the path
+*/
+func format(path string) (func(string, *Summary) ([]byte, error), error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if marshal, ok := formats[ext]; ok {
+		return marshal, nil
+	}
+
+	return nil, errors.Wrapf(errUnknownFormat, "%s", path)
+}
+
+/*
+marshalJSON generates a report as an indented JSON summary
+*/
+func marshalJSON(_ string, summary *Summary) ([]byte, error) {
+	return json.MarshalIndent(summary, "", "\t")
+}
+
+// driftType is the type of JUnit failures for files with drift in their metadata
+const driftType = "drift"
+
+// Structures defining the JUnit XML schema, limited to the elements that are used
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+/*
+marshalJUnit generates a report as JUnit XML, with one test case per file. Mismatched,
+modified, and missing files are reported as failures, as are unexpected directories,
+and files with drift in their metadata. Files that could not be verified are reported
+as errors
+*/
+func marshalJUnit(name string, summary *Summary) ([]byte, error) {
+	suite := junitSuite{
+		Name:   name,
+		Tests:  summary.Total,
+		Errors: summary.Errors,
+		Cases:  make([]junitCase, 0, len(summary.Results)),
+	}
+
+	for _, res := range summary.Results {
+		test := junitCase{Name: res.Path, ClassName: name}
+
+		switch res.Status {
+		case StatusMismatch, StatusModified:
+			msg := fmt.Sprintf("expected %s, got %s", res.Expected, res.Actual)
+			if len(res.Ranges) > 0 {
+				msg += ", bytes " + strings.Join(res.Ranges, ", ") + " differ"
+			}
+
+			test.Failure = &junitMessage{Message: msg, Type: string(res.Status)}
+
+		case StatusMissing:
+			test.Failure = &junitMessage{
+				Message: kind(&res) + " is missing", Type: string(res.Status),
+			}
+
+		case StatusUnexpected:
+			test.Failure = &junitMessage{
+				Message: kind(&res) + " is not recorded", Type: string(res.Status),
+			}
+
+		case StatusError:
+			test.Error = &junitMessage{Message: res.Error, Type: string(res.Status)}
+
+		default:
+			if len(res.Drift) > 0 {
+				test.Failure = &junitMessage{
+					Message: strings.Join(res.Drift, ", "), Type: driftType,
+				}
+			}
+		}
+
+		if test.Failure != nil {
+			suite.Failures++
+		}
+
+		suite.Cases = append(suite.Cases, test)
+	}
+
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "\t")
+	return append([]byte(xml.Header), data...), err
+}
+
+/*
+kind names the kind of entry a result is for, in failure messages
+*/
+func kind(res *Result) string {
+	if res.Dir {
+		return "directory"
+	}
+
+	return "file"
+}
@@ -0,0 +1,154 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reset() {
+	osWriteFile = os.WriteFile
+}
+
+// testResults contains one result for each status
+var testResults = []Result{
+	{Path: "/a", Status: StatusOK, Expected: "abcd", Actual: "abcd"},
+	{Path: "/b", Status: StatusMismatch, Expected: "abcd", Actual: "1234"},
+	{Path: "/c", Status: StatusMissing, Expected: "abcd"},
+	{Path: "/d", Status: StatusError, Error: "permission denied"},
+	{Path: "/e", Status: StatusOK},
+	{
+		Path: "/f", Status: StatusModified, Expected: "abcd", Actual: "5678",
+		Ranges: []string{"0-64", "128-192"},
+	},
+	{Path: "/g", Status: StatusOK, Drift: []string{"mode -rw-r--r-- -> -rwxrwxrwx"}},
+	{Path: "/h", Status: StatusUnexpected},
+}
+
+func TestIsUnknownFormatErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                   false,
+		errUnknownFormat:                      true,
+		errors.Wrap(errUnknownFormat, "test"): true,
+		fmt.Errorf("(%s): test", pkgName):     false,
+	} {
+		assert.Equal(t, expected, IsUnknownFormatErr(err))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	summary := Summarize(testResults)
+
+	assert.Equal(t, 8, summary.Total)
+	assert.Equal(t, 3, summary.Passed)
+	assert.Equal(t, 1, summary.Drifted)
+	assert.Equal(t, 1, summary.Mismatch)
+	assert.Equal(t, 1, summary.Modified)
+	assert.Equal(t, 1, summary.Missing)
+	assert.Equal(t, 1, summary.Errors)
+	assert.Equal(t, 1, summary.Unexpected)
+	assert.Equal(t, testResults, summary.Results)
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	reset()
+
+	for _, path := range []string{"report.txt", "report", "/tmp/"} {
+		assert.Truef(
+			t, IsUnknownFormatErr(Write(path, "test", nil)), `failed for "%s"`, path,
+		)
+	}
+}
+
+func TestWrite_Fail(t *testing.T) {
+	reset()
+
+	osWriteFile = func(string, []byte, os.FileMode) error { return os.ErrPermission }
+	assert.Error(t, Write("report.json", "test", testResults))
+}
+
+func TestWrite_JSON(t *testing.T) {
+	reset()
+
+	path := filepath.Join(t.TempDir(), "report.JSON") // case-insensitive
+	require.NoError(t, Write(path, "test", testResults))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var summary Summary
+	require.NoError(t, json.Unmarshal(data, &summary))
+	assert.Equal(t, Summarize(testResults), summary)
+}
+
+func TestWrite_JUnit(t *testing.T) {
+	reset()
+
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	require.NoError(t, Write(path, "release", testResults))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var suites junitSuites
+	require.NoError(t, xml.Unmarshal(data, &suites))
+	require.Len(t, suites.Suites, 1)
+
+	suite := suites.Suites[0]
+	assert.Equal(t, "release", suite.Name)
+	assert.Equal(t, 8, suite.Tests)
+	assert.Equal(t, 5, suite.Failures)
+	assert.Equal(t, 1, suite.Errors)
+	require.Len(t, suite.Cases, 8)
+
+	// One test case per file, in order
+	for i, test := range suite.Cases {
+		assert.Equal(t, testResults[i].Path, test.Name)
+	}
+
+	assert.Nil(t, suite.Cases[0].Failure)
+	assert.Equal(t, "expected abcd, got 1234", suite.Cases[1].Failure.Message)
+	assert.Equal(t, string(StatusMissing), suite.Cases[2].Failure.Type)
+	assert.Equal(t, "permission denied", suite.Cases[3].Error.Message)
+	assert.Equal(t, string(StatusModified), suite.Cases[5].Failure.Type)
+	assert.Equal(
+		t, "expected abcd, got 5678, bytes 0-64, 128-192 differ",
+		suite.Cases[5].Failure.Message,
+	)
+	assert.Equal(t, driftType, suite.Cases[6].Failure.Type)
+	assert.Equal(t, "mode -rw-r--r-- -> -rwxrwxrwx", suite.Cases[6].Failure.Message)
+	assert.Equal(t, string(StatusUnexpected), suite.Cases[7].Failure.Type)
+
+	// Messages name the kind of entry that is missing, or not recorded
+	assert.Equal(t, "file is missing", suite.Cases[2].Failure.Message)
+	assert.Equal(t, "file is not recorded", suite.Cases[7].Failure.Message)
+
+	require.NoError(t, Write(path, "release", []Result{
+		{Path: "/x", Status: StatusMissing, Dir: true},
+		{Path: "/y", Status: StatusUnexpected, Dir: true},
+	}))
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+
+	var dirs junitSuites
+	require.NoError(t, xml.Unmarshal(data, &dirs))
+	require.Len(t, dirs.Suites, 1)
+
+	cases := dirs.Suites[0].Cases
+	assert.Equal(t, "directory is missing", cases[0].Failure.Message)
+	assert.Equal(t, "directory is not recorded", cases[1].Failure.Message)
+}
+
+func TestCheckFormat(t *testing.T) {
+	assert.NoError(t, CheckFormat("report.xml"))
+	assert.NoError(t, CheckFormat("report.JSON"))
+	assert.True(t, IsUnknownFormatErr(CheckFormat("report.txt")))
+}
@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/notsatan/crcgen/src/logger"
@@ -35,6 +36,20 @@ func getHandler(ext string) Handler {
 	return outHandlers[ext]
 }
 
+/*
+FileTypes returns the extensions of all valid output files, i.e. extensions for which a
+Handler has been registered. Extensions are sorted, and do not contain periods
+*/
+func FileTypes() []string {
+	types := make([]string, 0, len(outHandlers))
+	for ext := range outHandlers {
+		types = append(types, ext)
+	}
+
+	sort.Strings(types)
+	return types
+}
+
 /*
 AddHandler registers a Handler that will be used to handle a particular output file,
 these handlers are detected based on file extensions
@@ -98,3 +98,14 @@ func TestAddHandler(t *testing.T) {
 		assert.Truef(t, flag, `extension "%s" not found in expected keys`, ext)
 	}
 }
+
+func TestFileTypes(t *testing.T) {
+	reset()
+
+	assert.Empty(t, FileTypes())
+
+	testFileTypes = []string{"YAML", ".json", "yml"}
+	AddHandler(&mockHandler{})
+
+	assert.Equal(t, []string{"json", "yaml", "yml"}, FileTypes())
+}
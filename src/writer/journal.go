@@ -0,0 +1,197 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/notsatan/crcgen/src/logger"
+)
+
+// journalExt is appended to the path of the output file to form the path to the
+// sidecar journal
+const journalExt = ".journal"
+
+// checkpointSize is the default number of entries buffered by a Journal before they
+// are flushed to the disk
+const checkpointSize = 64
+
+var (
+	openFile   = os.OpenFile     // maps to os.OpenFile
+	removeFile = os.Remove       // maps to os.Remove
+	syncFile   = (*os.File).Sync // maps to method Sync in os.File
+)
+
+/*
+JournalPath returns the path to the sidecar journal for the output file at the path
+*/
+func JournalPath(output string) string {
+	return output + journalExt
+}
+
+/*
+Journal periodically checkpoints completed FileInfo objects to a sidecar file, allowing
+an interrupted run to pick up where it stopped instead of starting over
+
+Entries are stored as one JSON object per line irrespective of the format of the output
+file. Use ReadJournal to load the entries back
+
+Note: It is recommended to use the OpenJournal function to create Journal objects
+*/
+type Journal struct {
+	mu sync.Mutex
+
+	file *os.File
+	buf  *bufio.Writer
+
+	// pending is the number of entries written to buf since the last checkpoint
+	pending int
+
+	// every is the number of entries after which a checkpoint is made implicitly
+	every int
+}
+
+/*
+OpenJournal opens the journal at the given path for appending, creating it if needed.
+Entries added to the journal are flushed to the disk after every `every` entries, a
+value less than one falls back to a sane default
+
+Returns an error if the file cannot be opened, use IsPathNotWriteableErr to check
+*/
+func OpenJournal(path string, every int) (*Journal, error) {
+	file, err := openFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		logger.Warnf("(%s/OpenJournal): failed to open journal: %v", pkgName, err)
+		return nil, errors.Wrapf(errNotWritable, "(%s/OpenJournal)", pkgName)
+	}
+
+	if every < 1 {
+		every = checkpointSize
+	}
+
+	return &Journal{file: file, buf: bufio.NewWriter(file), every: every}, nil
+}
+
+/*
+Add appends a FileInfo object to the journal. Once enough entries have been added, the
+journal is implicitly checkpointed
+
+Safe for concurrent use
+*/
+func (j *Journal) Add(info *FileInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/Journal.Add)", pkgName)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A newline separates each entry, makes a torn write detectable
+	if _, err = j.buf.Write(append(data, '\n')); err != nil {
+		return errors.Wrapf(errNotWritable, "(%s/Journal.Add)", pkgName)
+	}
+
+	if j.pending++; j.pending < j.every {
+		return nil
+	}
+
+	return j.checkpoint()
+}
+
+/*
+Checkpoint flushes buffered entries in the journal to the disk
+
+Safe for concurrent use
+*/
+func (j *Journal) Checkpoint() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.checkpoint()
+}
+
+/*
+checkpoint flushes the buffer, and syncs the journal file. Expects the caller to hold
+the lock
+*/
+func (j *Journal) checkpoint() error {
+	if err := j.buf.Flush(); err != nil {
+		return errors.Wrapf(errNotWritable, "(%s/Journal.checkpoint)", pkgName)
+	}
+
+	if err := syncFile(j.file); err != nil {
+		return errors.Wrapf(errNotWritable, "(%s/Journal.checkpoint)", pkgName)
+	}
+
+	j.pending = 0
+	return nil
+}
+
+/*
+Close checkpoints any pending entries, and closes the journal
+*/
+func (j *Journal) Close() error {
+	err := j.Checkpoint()
+	if e := closeFile(j.file); err == nil {
+		err = errors.Wrapf(e, "(%s/Journal.Close)", pkgName)
+	}
+
+	return err
+}
+
+/*
+ReadJournal reads entries from an existing journal, mapping each FileInfo object to its
+path. A journal that does not exist is treated as empty
+
+A run killed midway can leave a partially written entry at the end of the journal, such
+entries are silently dropped. Returns an error if the journal can't be read, use
+IsReadFileErr to check
+*/
+func ReadJournal(path string) (map[string]FileInfo, error) {
+	data, err := osReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return map[string]FileInfo{}, nil
+
+	case err != nil:
+		logger.Warnf("(%s/ReadJournal): failed to read journal: %v", pkgName, err)
+		return nil, errors.Wrapf(errReadFile, "(%s/ReadJournal)", pkgName)
+	}
+
+	entries := map[string]FileInfo{}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		var info FileInfo
+		if len(line) == 0 || json.Unmarshal(line, &info) != nil {
+			continue // skip blank lines, and torn writes
+		}
+
+		entries[info.Path] = info
+	}
+
+	return entries, nil
+}
+
+/*
+RemoveJournal deletes the journal at the given path, designed to be run once the output
+file has been written successfully. A journal that does not exist is not an error
+*/
+func RemoveJournal(path string) error {
+	if err := removeFile(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "(%s/RemoveJournal)", pkgName)
+	}
+
+	return nil
+}
+
+/*
+Resumable checks if an entry read from the journal can be reused for a file, i.e. the
+file has not been modified since the entry was recorded
+*/
+func Resumable(entry *FileInfo, info os.FileInfo) bool {
+	return entry.Size == info.Size() && entry.LastMod == info.ModTime().Unix()
+}
@@ -0,0 +1,129 @@
+package writer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalPath(t *testing.T) {
+	assert.Equal(
+		t, "/path/to/output.json"+journalExt, JournalPath("/path/to/output.json"),
+	)
+}
+
+func TestOpenJournal_Fail(t *testing.T) {
+	reset()
+
+	openFile = func(string, int, os.FileMode) (*os.File, error) {
+		return nil, fmt.Errorf("(%s/TestOpenJournal_Fail): test error", pkgName)
+	}
+
+	journal, err := OpenJournal("output.json.journal", 0)
+	assert.Nil(t, journal)
+	assert.True(t, IsPathNotWriteableErr(err))
+}
+
+func TestJournal(t *testing.T) {
+	reset()
+
+	path := filepath.Join(t.TempDir(), "output.json"+journalExt)
+
+	// Checkpoint after every two entries
+	journal, err := OpenJournal(path, 2)
+	require.NoError(t, err)
+
+	assert.NoError(t, journal.Add(&FileInfo{Path: "/a", Size: 1}))
+	entries, err := ReadJournal(path)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "entry flushed before checkpoint")
+
+	assert.NoError(t, journal.Add(&FileInfo{Path: "/b", Size: 2}))
+	entries, err = ReadJournal(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "entries not flushed on checkpoint")
+
+	// Pending entries should be flushed when the journal closes
+	assert.NoError(t, journal.Add(&FileInfo{Path: "/c", Size: 3}))
+	assert.NoError(t, journal.Close())
+
+	// Re-opening the journal should append to it, not truncate
+	journal, err = OpenJournal(path, 0)
+	require.NoError(t, err)
+	assert.NoError(t, journal.Add(&FileInfo{Path: "/a", Size: 10}))
+	assert.NoError(t, journal.Close())
+
+	entries, err = ReadJournal(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, int64(10), entries["/a"].Size, "later entries should win")
+	assert.Equal(t, int64(3), entries["/c"].Size)
+
+	assert.NoError(t, RemoveJournal(path))
+	assert.NoError(t, RemoveJournal(path), "missing journal should not be an error")
+}
+
+func TestJournal_CheckpointFail(t *testing.T) {
+	reset()
+
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "out.journal"), 1)
+	require.NoError(t, err)
+
+	syncFile = func(*os.File) error {
+		return fmt.Errorf("(%s/TestJournal_CheckpointFail): test error", pkgName)
+	}
+
+	assert.True(t, IsPathNotWriteableErr(journal.Add(&FileInfo{Path: "/a"})))
+	assert.Error(t, journal.Close())
+}
+
+func TestReadJournal(t *testing.T) {
+	reset()
+
+	// Journal that does not exist should be treated as empty
+	entries, err := ReadJournal(filepath.Join(t.TempDir(), "missing.journal"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Torn writes at the end of the journal should be dropped
+	osReadFile = func(string) ([]byte, error) {
+		return []byte("{\"Path\":\"/a\",\"Size\":4}\n\n{\"Path\":\"/b\",\"Si"), nil
+	}
+
+	entries, err = ReadJournal("out.journal")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]FileInfo{"/a": {Path: "/a", Size: 4}}, entries)
+
+	osReadFile = func(string) ([]byte, error) { return nil, os.ErrPermission }
+	_, err = ReadJournal("out.journal")
+	assert.True(t, IsReadFileErr(err))
+}
+
+func TestRemoveJournal_Fail(t *testing.T) {
+	reset()
+
+	removeFile = func(string) error { return os.ErrPermission }
+	assert.Error(t, RemoveJournal("out.journal"))
+}
+
+func TestResumable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0o600))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	entry := FileInfo{Path: path, Size: info.Size(), LastMod: info.ModTime().Unix()}
+	assert.True(t, Resumable(&entry, info))
+
+	entry.Size++
+	assert.False(t, Resumable(&entry, info), "size change not detected")
+
+	entry.Size--
+	entry.LastMod--
+	assert.False(t, Resumable(&entry, info), "mod time change not detected")
+}
@@ -54,6 +54,16 @@ func TestJsonHandler_Marshal(t *testing.T) {
 	assert.Equal(t, []byte(rawJSON), res)
 }
 
+func TestJsonHandler_MarshalPartial(t *testing.T) {
+	// Partial marker should only be written when set
+	res, err := handler().Marshal(&writer.DirInfo{Path: "/test/path", Partial: true})
+	require.NoError(t, err)
+	assert.Equal(
+		t, `{"Path":"/test/path","Dirs":null,"Files":null,"LastMod":0,"Partial":true}`,
+		string(res),
+	)
+}
+
 func TestJsonHandler_Unmarshal(t *testing.T) {
 	in := `
 {
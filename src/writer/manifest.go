@@ -0,0 +1,85 @@
+package writer
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	renameFile = os.Rename // maps to os.Rename
+	chmodFile  = os.Chmod  // maps to os.Chmod
+)
+
+/*
+ReadManifest reads an output file at the path into a DirInfo object. Unlike Start, this
+does not set up the package, letting any number of output files be read
+
+Returns an error if no handler can interact with the filetype, if the file cannot be
+read, or if its contents can't be parsed. Use the functions IsHandlerNotFoundErr,
+IsReadFileErr and IsInvalidManifestErr to check for these errors
+*/
+func ReadManifest(path string) (DirInfo, error) {
+	handler := getHandler(filepath.Ext(path))
+	if handler == nil {
+		return DirInfo{}, errors.Wrapf(errNoHandler, "(%s/ReadManifest)", pkgName)
+	}
+
+	data, err := osReadFile(path)
+	if err != nil {
+		return DirInfo{}, errors.Wrapf(errReadFile, "(%s/ReadManifest): %v", pkgName, err)
+	}
+
+	var info DirInfo
+	if err = handler.Unmarshal(data, &info); err != nil {
+		return DirInfo{}, errors.Wrapf(
+			errInvalidManifest, "(%s/ReadManifest): %v", pkgName, err,
+		)
+	}
+
+	return info, nil
+}
+
+/*
+WriteManifest writes a DirInfo object to the output file at the path, replacing its
+contents. Contents are written to a temporary file first, and renamed into place - the
+output file is never left partially written. Existing output files keep their
+permissions, new output files are only readable by the owner
+
+Returns an error if no handler can interact with the filetype, or if the file cannot
+be written to. Use the functions IsHandlerNotFoundErr, and IsPathNotWriteableErr to
+check for these errors. If neither matches, marshaling the DirInfo object failed
+*/
+func WriteManifest(path string, info *DirInfo) error {
+	handler := getHandler(filepath.Ext(path))
+	if handler == nil {
+		return errors.Wrapf(errNoHandler, "(%s/WriteManifest)", pkgName)
+	}
+
+	data, err := handler.Marshal(info, true)
+	if err != nil {
+		return errors.Wrapf(err, "(%s/WriteManifest)", pkgName)
+	}
+
+	mode := fs.FileMode(0o600)
+	if stat, e := pathStats(path); e == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	temp := path + ".tmp"
+	if err = osWriteFile(temp, data, mode); err == nil {
+		err = chmodFile(temp, mode) // permissions are not subject to the umask
+	}
+
+	if err == nil {
+		err = renameFile(temp, path)
+	}
+
+	if err != nil {
+		return errors.Wrapf(errNotWritable, "(%s/WriteManifest): %v", pkgName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package writer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadManifest(t *testing.T) {
+	reset()
+	defer reset()
+
+	_, err := ReadManifest("/path/to/output.mp3")
+	assert.True(t, IsHandlerNotFoundErr(err))
+
+	outHandlers = map[string]Handler{"yml": &mockHandler{}, "yaml": &mockHandlerFail{}}
+
+	_, err = ReadManifest(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.True(t, IsReadFileErr(err))
+
+	osReadFile = func(string) ([]byte, error) { return []byte("data"), nil }
+	_, err = ReadManifest("/path/to/output.yaml")
+	assert.True(t, IsInvalidManifestErr(err))
+
+	info, err := ReadManifest("/path/to/output.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, DirInfo{}, info)
+}
+
+func TestWriteManifest(t *testing.T) {
+	reset()
+	defer reset()
+
+	assert.True(t, IsHandlerNotFoundErr(WriteManifest("/output.mp3", &DirInfo{})))
+
+	outHandlers = map[string]Handler{"yml": &mockHandler{}, "yaml": &mockHandlerFail{}}
+	assert.Error(t, WriteManifest("/output.yaml", &DirInfo{}))
+
+	path := filepath.Join(t.TempDir(), "output.yml")
+	require.NoError(t, WriteManifest(path, &DirInfo{}))
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, path+".tmp", "temporary file left behind")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "new files are private")
+
+	// Rewriting an existing output file should keep its permissions
+	require.NoError(t, os.Chmod(path, 0o644))
+	require.NoError(t, WriteManifest(path, &DirInfo{}))
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	chmodFile = func(string, os.FileMode) error { return os.ErrPermission }
+	assert.True(t, IsPathNotWriteableErr(WriteManifest(path, &DirInfo{})))
+	chmodFile = os.Chmod
+
+	// Failing to write, or rename the temporary file should fail
+	renameFile = func(string, string) error {
+		return fmt.Errorf("(%s/TestWriteManifest): test error", pkgName)
+	}
+
+	assert.True(t, IsPathNotWriteableErr(WriteManifest(path, &DirInfo{})))
+
+	osWriteFile = func(string, []byte, os.FileMode) error { return os.ErrPermission }
+	assert.True(t, IsPathNotWriteableErr(WriteManifest(path, &DirInfo{})))
+}
@@ -1,12 +1,24 @@
 package writer
 
-import "path/filepath"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
 
 /*
 Checksums contains the various checksums generated for files
 */
 type Checksums struct {
 	CRC32 string
+
+	// Quick contains a quick hash, computed by sampling parts of the file along with
+	// its size. It only serves to detect changes, and is not a checksum of the file
+	Quick string `json:",omitempty"`
 }
 
 /*
@@ -26,6 +38,96 @@ type FileInfo struct {
 	// LastMod indicates time when the file was last modified. Represents epoch time,
 	// not intended to be human-readable
 	LastMod int64
+
+	// Error describes why the file could not be processed (permission denied, I/O
+	// error, etc.). Empty for files processed successfully
+	Error string `json:",omitempty"`
+
+	// BlockSize contains the size of each block (in bytes) used to compute Blocks
+	BlockSize int64 `json:",omitempty"`
+
+	// Blocks optionally contains the CRC32 checksum of each block of the file, used to
+	// localize corruption within large files
+	Blocks []string `json:",omitempty"`
+
+	// LastVerified indicates the time when the file was last verified against its
+	// checksums. Represents epoch time, zero for files never verified
+	LastVerified int64 `json:",omitempty"`
+
+	// VerifyCount contains the number of times the file was verified successfully
+	VerifyCount int `json:",omitempty"`
+
+	// Unstable marks files that kept changing while being read - no checksum is
+	// recorded for these, as it would match neither version of the file
+	Unstable bool `json:",omitempty"`
+
+	// BadRanges contains the byte ranges that could not be read, for files that failed
+	// with I/O errors. Points to failing sectors of the underlying disk
+	BadRanges []ByteRange `json:",omitempty"`
+
+	// Metadata optionally contains permissions, ownership, and extended attributes of
+	// the file - nil unless captured
+	Metadata *Metadata `json:",omitempty"`
+
+	// Type marks special files (`fifo`, `socket`, `char-device`, `block-device`,
+	// `symlink`, or `irregular`), which are recorded without being read. Empty for
+	// regular files
+	Type string `json:",omitempty"`
+
+	// Device contains the device numbers of character, and block devices
+	Device *Device `json:",omitempty"`
+
+	// Target contains the path a symbolic link points to, as stored in the link
+	Target string `json:",omitempty"`
+}
+
+/*
+Device contains the major, and minor numbers identifying a device
+*/
+type Device struct {
+	Major uint32
+	Minor uint32
+}
+
+/*
+Metadata contains metadata of a file other than its size, and modification time - used
+to detect changes to permissions, and ownership
+*/
+type Metadata struct {
+	// Mode contains the permission bits of the file, along with the setuid, setgid, and
+	// sticky bits
+	Mode fs.FileMode
+
+	// UID, and GID contain the numeric owner and group of the file, -1 on platforms
+	// without them. Owner, and Group contain their names, if they could be resolved
+	UID   int
+	GID   int
+	Owner string `json:",omitempty"`
+	Group string `json:",omitempty"`
+
+	// Ctime indicates the time when the metadata of the file last changed. Represents
+	// epoch time, not intended to be human-readable
+	Ctime int64 `json:",omitempty"`
+
+	// Xattrs contains values of selected extended attributes of the file - including
+	// POSIX ACLs, stored as the `system.posix_acl_access` attribute
+	Xattrs map[string][]byte `json:",omitempty"`
+}
+
+/*
+ByteRange defines a range of bytes within a file, the start is inclusive while the end
+is exclusive
+*/
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+/*
+String returns the range in the form `start-end`
+*/
+func (r ByteRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
 }
 
 /*
@@ -54,6 +156,32 @@ type DirInfo struct {
 	// LastMod indicates the time when the directory was last modified. Represents epoch
 	// time, not intended to be human-readable
 	LastMod int64
+
+	// Mode contains the permission bits of the directory, nil if not recorded. Output
+	// files recording every directory (including empty directories) set this for all
+	// directories
+	Mode *fs.FileMode `json:",omitempty"`
+
+	// DirMod contains the mtime of the directory itself, recorded along with Mode -
+	// unlike LastMod, which holds the latest mtime of the contents of the directory
+	DirMod int64 `json:",omitempty"`
+
+	// Error contains the reason the directory could not be recorded completely, i.e.
+	// its entries could not be read. Directories nested within it may be missing
+	Error string `json:",omitempty"`
+
+	// Archive marks directories representing the contents of an archive, nested under
+	// the path to the archive file - these do not exist on disk
+	Archive bool `json:",omitempty"`
+
+	// Partial marks the output as incomplete, i.e. the run was stopped before all files
+	// were processed. Only meaningful for the root directory
+	Partial bool `json:",omitempty"`
+
+	// Digest contains a hash of the contents of the directory, derived from names and
+	// checksums of everything nested within it. Directories with identical contents
+	// have the same digest
+	Digest string `json:",omitempty"`
 }
 
 /*
@@ -101,6 +229,182 @@ func (dir *DirInfo) CalcModTime() int64 {
 	return modTime
 }
 
+/*
+CalcDigest calculates the Digest for a directory, in case this has already been
+calculated, the previous value is directly returned
+
+The digest is the SHA256 of the sorted list of entries in the directory - the name,
+CRC32 and size of each file, and the name and digest of each directory. The path to the
+directory is not included, letting trees be compared at the root regardless of where
+they are located
+
+Note: Similar to CalcModTime, this method ends up being recursive for directories
+without a digest
+*/
+func (dir *DirInfo) CalcDigest() string {
+	if dir.Digest != "" {
+		return dir.Digest
+	}
+
+	entries := make([]string, 0, len(dir.Files)+len(dir.Dirs))
+	for i := range dir.Files {
+		file := &dir.Files[i]
+		entries = append(entries, fmt.Sprintf(
+			"f\x00%s\x00%s\x00%d", file.Name(), file.Checksums.CRC32, file.Size,
+		))
+	}
+
+	for i := range dir.Dirs {
+		entries = append(entries, fmt.Sprintf(
+			"d\x00%s\x00%s", dir.Dirs[i].Name(), dir.Dirs[i].CalcDigest(),
+		))
+	}
+
+	// Sort entries to keep the digest independent of the order of Files and Dirs
+	sort.Strings(entries)
+
+	digest := sha256.New()
+	for _, entry := range entries {
+		_, _ = digest.Write([]byte(entry + "\n"))
+	}
+
+	dir.Digest = hex.EncodeToString(digest.Sum(nil)) // save this value for future use
+	return dir.Digest
+}
+
+/*
+Failed returns files that could not be processed, i.e. files with a non-empty Error,
+from this directory and all directories nested within it
+*/
+func (dir *DirInfo) Failed() []FileInfo {
+	var failed []FileInfo
+	for i := range dir.Files {
+		if dir.Files[i].Error != "" {
+			failed = append(failed, dir.Files[i])
+		}
+	}
+
+	for i := range dir.Dirs {
+		failed = append(failed, dir.Dirs[i].Failed()...)
+	}
+
+	return failed
+}
+
+/*
+AllFiles returns pointers to files in this directory and all directories nested within
+it, letting files be updated in place
+*/
+func (dir *DirInfo) AllFiles() []*FileInfo {
+	files := make([]*FileInfo, 0, len(dir.Files))
+	for i := range dir.Files {
+		files = append(files, &dir.Files[i])
+	}
+
+	for i := range dir.Dirs {
+		files = append(files, dir.Dirs[i].AllFiles()...)
+	}
+
+	return files
+}
+
+/*
+AddFile adds a file to the directory containing it, nested within this directory -
+creating intermediate directories as needed. Returns false without adding the file if
+it is not nested within this directory
+*/
+func (dir *DirInfo) AddFile(file FileInfo) bool {
+	parent := dir.parentDir(file.Path)
+	if parent == nil {
+		return false
+	}
+
+	parent.Files = append(parent.Files, file)
+	return true
+}
+
+/*
+AddDir adds a directory to the directory containing it, nested within this directory -
+creating intermediate directories as needed. Returns false without adding the directory
+if it is not nested within this directory
+*/
+func (dir *DirInfo) AddDir(nested DirInfo) bool {
+	parent := dir.parentDir(nested.Path)
+	if parent == nil {
+		return false
+	}
+
+	parent.Dirs = append(parent.Dirs, nested)
+	return true
+}
+
+/*
+parentDir returns the directory that should contain the path, nested within this
+directory - creating intermediate directories as needed. Returns nil if the path is not
+nested within this directory
+*/
+func (dir *DirInfo) parentDir(path string) *DirInfo {
+	rel, err := filepath.Rel(dir.Path, filepath.Dir(path))
+	rel = filepath.ToSlash(rel)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil
+	}
+
+	parent := dir
+	if rel != "." {
+		for _, name := range strings.Split(rel, "/") {
+			parent = parent.childDir(name)
+		}
+	}
+
+	return parent
+}
+
+/*
+childDir returns the directory with the name nested directly within this directory,
+creating it if needed
+*/
+func (dir *DirInfo) childDir(name string) *DirInfo {
+	for i := range dir.Dirs {
+		if dir.Dirs[i].Name() == name {
+			return &dir.Dirs[i]
+		}
+	}
+
+	dir.Dirs = append(dir.Dirs, DirInfo{Path: filepath.Join(dir.Path, name)})
+	return &dir.Dirs[len(dir.Dirs)-1]
+}
+
+/*
+AllDirs returns pointers to this directory, and all directories nested within it,
+letting directories be updated in place
+*/
+func (dir *DirInfo) AllDirs() []*DirInfo {
+	dirs := []*DirInfo{dir}
+	for i := range dir.Dirs {
+		dirs = append(dirs, dir.Dirs[i].AllDirs()...)
+	}
+
+	return dirs
+}
+
+/*
+Archives returns directories representing archives nested within this directory, see
+DirInfo.Archive. Directories within archives are not searched
+*/
+func (dir *DirInfo) Archives() []*DirInfo {
+	if dir.Archive {
+		return []*DirInfo{dir}
+	}
+
+	var archives []*DirInfo
+	for i := range dir.Dirs {
+		archives = append(archives, dir.Dirs[i].Archives()...)
+	}
+
+	return archives
+}
+
 /*
 NewDir is a wrapper to create DirInfo objects. Objects created using this method would
 ensure they have DirInfo.LastMod value set and more
@@ -127,5 +431,6 @@ func NewDir(
 	}
 
 	_ = result.CalcModTime() // ensures the directory created has mod time set
+	_ = result.CalcDigest()
 	return result
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDirInfo_Name(t *testing.T) {
@@ -124,3 +125,148 @@ func TestNewDir(t *testing.T) {
 		)
 	}
 }
+
+func TestDirInfo_Failed(t *testing.T) {
+	obj := DirInfo{
+		Files: []FileInfo{
+			{Path: "/a"},
+			{Path: "/b", Error: "permission denied"},
+		},
+		Dirs: []DirInfo{
+			{Files: []FileInfo{{Path: "/c/d", Error: "I/O error"}, {Path: "/c/e"}}},
+			{},
+		},
+	}
+
+	assert.Equal(t, []FileInfo{
+		{Path: "/b", Error: "permission denied"},
+		{Path: "/c/d", Error: "I/O error"},
+	}, obj.Failed())
+
+	assert.Empty(t, (&DirInfo{}).Failed())
+}
+
+func TestByteRange_String(t *testing.T) {
+	assert.Equal(t, "0-10", ByteRange{Start: 0, End: 10}.String())
+	assert.Equal(t, "4096-8192", ByteRange{Start: 4096, End: 8192}.String())
+}
+
+func TestDirInfo_CalcDigest(t *testing.T) {
+	tree := func(root string, crc string) DirInfo {
+		return DirInfo{
+			Path: root,
+			Files: []FileInfo{
+				{Path: root + "/a", Checksums: Checksums{CRC32: "cbf43926"}, Size: 9},
+			},
+			Dirs: []DirInfo{{
+				Path:  root + "/sub",
+				Files: []FileInfo{{Path: root + "/sub/b", Checksums: Checksums{CRC32: crc}}},
+			}},
+		}
+	}
+
+	// Identical contents at different locations share the same digest
+	one, two := tree("/one", "e3069283"), tree("/two", "e3069283")
+	assert.Len(t, one.CalcDigest(), 64)
+	assert.Equal(t, one.CalcDigest(), two.CalcDigest())
+	assert.Equal(t, one.Dirs[0].Digest, two.Dirs[0].Digest, "subtree digest not saved")
+
+	// A change deep within the tree changes the digest of the root
+	changed := tree("/one", "00000000")
+	assert.NotEqual(t, one.CalcDigest(), changed.CalcDigest())
+
+	// Digest does not depend on the order of entries
+	swapped := tree("/one", "e3069283")
+	swapped.Files = append(swapped.Files, FileInfo{Path: "/one/c"})
+	reordered := tree("/one", "e3069283")
+	reordered.Files = append([]FileInfo{{Path: "/one/c"}}, reordered.Files...)
+	assert.Equal(t, swapped.CalcDigest(), reordered.CalcDigest())
+
+	// Existing values are returned directly
+	obj := DirInfo{Digest: "cached", Files: []FileInfo{{Path: "/a"}}}
+	assert.Equal(t, "cached", obj.CalcDigest())
+}
+
+func TestDirInfo_AllFiles(t *testing.T) {
+	obj := DirInfo{
+		Files: []FileInfo{{Path: "/a"}},
+		Dirs: []DirInfo{
+			{Files: []FileInfo{{Path: "/b/c"}}, Dirs: []DirInfo{{Files: []FileInfo{{}}}}},
+		},
+	}
+
+	files := obj.AllFiles()
+	require.Len(t, files, 3)
+	assert.Equal(t, "/a", files[0].Path)
+	assert.Equal(t, "/b/c", files[1].Path)
+
+	// Changes to files are reflected in the directory
+	files[1].VerifyCount++
+	assert.Equal(t, 1, obj.Dirs[0].Files[0].VerifyCount)
+
+	assert.Empty(t, (&DirInfo{}).AllFiles())
+}
+
+func TestDirInfo_AllDirs(t *testing.T) {
+	obj := DirInfo{
+		Path: "/",
+		Dirs: []DirInfo{{Path: "/a", Dirs: []DirInfo{{Path: "/a/b"}}}, {Path: "/c"}},
+	}
+
+	dirs := obj.AllDirs()
+	require.Len(t, dirs, 4)
+	for i, path := range []string{"/", "/a", "/a/b", "/c"} {
+		assert.Equal(t, path, dirs[i].Path)
+	}
+
+	// Changes to directories are reflected in the tree
+	dirs[2].LastMod = 100
+	assert.Equal(t, int64(100), obj.Dirs[0].Dirs[0].LastMod)
+}
+
+func TestDirInfo_AddFile(t *testing.T) {
+	obj := DirInfo{Path: "/root"}
+
+	assert.True(t, obj.AddFile(FileInfo{Path: "/root/a"}))
+	assert.True(t, obj.AddFile(FileInfo{Path: "/root/b/c/d"}))
+	assert.True(t, obj.AddFile(FileInfo{Path: "/root/b/e"}))
+	assert.False(t, obj.AddFile(FileInfo{Path: "/other/f"}))
+	assert.False(t, obj.AddFile(FileInfo{Path: "/root/../g"}))
+
+	require.Len(t, obj.Files, 1)
+	require.Len(t, obj.Dirs, 1)
+	assert.Equal(t, "/root/b", obj.Dirs[0].Path)
+	assert.Equal(t, "/root/b/e", obj.Dirs[0].Files[0].Path)
+	assert.Equal(t, "/root/b/c/d", obj.Dirs[0].Dirs[0].Files[0].Path)
+}
+
+func TestDirInfo_AddDir(t *testing.T) {
+	obj := DirInfo{Path: "/root"}
+
+	assert.True(t, obj.AddDir(DirInfo{Path: "/root/a/b.zip", Archive: true}))
+	assert.True(t, obj.AddDir(DirInfo{Path: "/root/c"}))
+	assert.False(t, obj.AddDir(DirInfo{Path: "/root"}))
+	assert.False(t, obj.AddDir(DirInfo{Path: "/other/d"}))
+
+	require.Len(t, obj.Dirs, 2)
+	assert.Equal(t, "/root/a", obj.Dirs[0].Path)
+	assert.Equal(t, "/root/a/b.zip", obj.Dirs[0].Dirs[0].Path)
+	assert.Equal(t, "/root/c", obj.Dirs[1].Path)
+}
+
+func TestDirInfo_Archives(t *testing.T) {
+	obj := DirInfo{
+		Path: "/",
+		Dirs: []DirInfo{
+			{Path: "/a", Dirs: []DirInfo{{
+				Path: "/a/b.zip", Archive: true, Dirs: []DirInfo{{Path: "/a/b.zip/c"}},
+			}}},
+			{Path: "/d.tar", Archive: true},
+		},
+	}
+
+	archives := obj.Archives()
+	require.Len(t, archives, 2)
+	assert.Equal(t, "/a/b.zip", archives[0].Path)
+	assert.Equal(t, "/d.tar", archives[1].Path)
+}
@@ -47,6 +47,12 @@ var (
 	errNotWritable = fmt.Errorf("(%s): path is not writeable", pkgName)
 	errReadFile    = fmt.Errorf("(%s): output file cannot be read", pkgName)
 	errNoHandler   = fmt.Errorf("(%s): no handler found for filetype", pkgName)
+
+	// errInvalidManifest is a specific case of errInvalidFile, where the output file
+	// exists but its contents can't be parsed
+	errInvalidManifest = fmt.Errorf(
+		"(%s): output file is malformed: %w", pkgName, errInvalidFile,
+	)
 )
 
 // once ensures Start can call inner start function exactly one time
@@ -63,6 +69,14 @@ func IsInvalidFileErr(err error) bool {
 	return errors.Is(err, errInvalidFile)
 }
 
+/*
+IsInvalidManifestErr checks if an error was caused because the contents of an existing
+output file could not be parsed. Such errors also match IsInvalidFileErr
+*/
+func IsInvalidManifestErr(err error) bool {
+	return errors.Is(err, errInvalidManifest)
+}
+
 /*
 IsInvalidExtErr checks if an error returned by package `writer` was caused by the file
 having an invalid extension
@@ -237,7 +251,7 @@ func readFile(info *DirInfo) error {
 
 	if err = handler.Unmarshal(data, info); err != nil {
 		logger.Warnf("(%s/readFile): unmarshal caused an error: %v", pkgName, err)
-		return errors.Wrapf(errInvalidFile, "(%s/readFile)", pkgName)
+		return errors.Wrapf(errInvalidManifest, "(%s/readFile)", pkgName)
 	}
 
 	return nil
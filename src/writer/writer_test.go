@@ -21,6 +21,11 @@ func reset() {
 	absPath = filepath.Abs
 	osReadFile = os.ReadFile
 	osWriteFile = os.WriteFile
+	openFile = os.OpenFile
+	removeFile = os.Remove
+	syncFile = (*os.File).Sync
+	renameFile = os.Rename
+	chmodFile = os.Chmod
 
 	outHandlers = map[string]Handler{}
 }
@@ -57,6 +62,24 @@ func TestIsInvalidFileErr(t *testing.T) {
 	}
 }
 
+func TestIsInvalidManifestErr(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                     false,
+		errInvalidFile:                          false,
+		errInvalidManifest:                      true,
+		errors.Wrap(errInvalidManifest, "test"): true,
+		fmt.Errorf("test error"):                false,
+	} {
+		assert.Equalf(
+			t, expected, IsInvalidManifestErr(err),
+			`failed to match "%v" -> "%v"`, expected, err,
+		)
+	}
+
+	// Malformed output files should still be detected as invalid files
+	assert.True(t, IsInvalidFileErr(errInvalidManifest))
+}
+
 func TestIsPathNotWriteableErr(t *testing.T) {
 	for err, expected := range map[error]bool{
 		nil:                      false,
@@ -312,7 +335,7 @@ func TestReadFile(t *testing.T) {
 	// Ensure error is returned if unmarshal fails
 	outHandlers["yaml"] = &mockHandlerFail{}
 	filePath = "output.yaml"
-	assert.True(t, IsInvalidFileErr(readFile(&DirInfo{})))
+	assert.True(t, IsInvalidManifestErr(readFile(&DirInfo{})))
 
 	// No error should be returned for a successful run
 	outHandlers = map[string]Handler{"yml": &mockHandler{}}